@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// BackfillOptions are the flags of the backfill command
+type BackfillOptions struct {
+	Since time.Duration `long:"since" description:"Send the log files written to in this long before now (RDS keeps them for a day or so by default)" default:"24h"`
+}
+
+// Backfill reads the log's files written to since the given time ago, oldest
+// first, and sends them through the parser and output like Stream does, to
+// fill in what was written before rdslogs started tailing. Unlike Download,
+// nothing is saved locally.
+func (c *CLI) Backfill(since time.Duration) error {
+	src, err := c.source()
+	if err != nil {
+		return err
+	}
+	logFiles, err := c.backfillFiles(since)
+	if err != nil {
+		return err
+	}
+	if len(logFiles) == 0 {
+		return fmt.Errorf("no %s files written in the last %s", c.Options.LogFile, since)
+	}
+	closeOutput, err := c.openOutput(src)
+	if err != nil {
+		return err
+	}
+	defer closeOutput()
+
+	for _, lf := range logFiles {
+		logrus.WithFields(logrus.Fields{
+			"file": lf.LogFileName,
+			"size": lf.Size,
+		}).Info("Backfilling log file")
+		if err := c.sendLogFile(lf); err != nil {
+			if err == ErrAborted {
+				return err
+			}
+			return fmt.Errorf("error reading %s: %s", lf.LogFileName, err)
+		}
+	}
+	return nil
+}
+
+// backfillFiles returns the log's files written to since the given time ago,
+// oldest first
+func (c *CLI) backfillFiles(since time.Duration) ([]LogFile, error) {
+	logFiles, err := c.ListLogs()
+	if err != nil {
+		return nil, err
+	}
+	cutoff := c.now().Add(-since)
+	var recent []LogFile
+	for _, lf := range logFiles {
+		if !lf.LastWrittenTime.Before(cutoff) {
+			recent = append(recent, lf)
+		}
+	}
+	return recent, nil
+}
+
+// sendLogFile reads all of a log file and sends it to the output
+func (c *CLI) sendLogFile(lf LogFile) error {
+	err := c.readLogFile(lf, func(data string) error {
+		if data != "" {
+			c.output.Write(data)
+		}
+		return nil
+	})
+	// the file's last line won't be finished by the next file
+	c.output.Flush()
+	return err
+}
@@ -0,0 +1,65 @@
+package cli
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rds"
+)
+
+func TestBackfill(t *testing.T) {
+	hour := int64(time.Hour / time.Millisecond)
+	now := time.Unix(100*hour/1000, 0)
+	portions := map[string][]string{
+		"slowquery/mysql-slowquery.log.2": {"# Time: 1\n", "select 1;\n"},
+		"slowquery/mysql-slowquery.log":   {"# Time: 3\nselect 3;\n"},
+	}
+	fake := &FakeRDS{
+		logFiles: []*rds.DescribeDBLogFilesDetails{
+			{LogFileName: aws.String("slowquery/mysql-slowquery.log"), LastWritten: aws.Int64(100 * hour), Size: aws.Int64(20)},
+			{LogFileName: aws.String("slowquery/mysql-slowquery.log.1"), LastWritten: aws.Int64(97 * hour), Size: aws.Int64(20)},
+			{LogFileName: aws.String("slowquery/mysql-slowquery.log.2"), LastWritten: aws.Int64(99 * hour), Size: aws.Int64(20)},
+		},
+		portions: func(in *rds.DownloadDBLogFilePortionInput) (*rds.DownloadDBLogFilePortionOutput, error) {
+			// markers count the portions read so far
+			i, _ := strconv.Atoi(*in.Marker)
+			parts := portions[*in.LogFileName]
+			return &rds.DownloadDBLogFilePortionOutput{
+				LogFileData:           aws.String(parts[i]),
+				Marker:                aws.String(strconv.Itoa(i + 1)),
+				AdditionalDataPending: aws.Bool(i+1 < len(parts)),
+			}, nil
+		},
+	}
+	out := &capturePublisher{}
+	c := &CLI{
+		Options:   &Options{InstanceIdentifier: "db", LogFile: "slowquery/mysql-slowquery.log"},
+		RDS:       fake,
+		fakeNower: &FakeNower{t: now},
+		output:    out,
+	}
+
+	logFiles, err := c.backfillFiles(2 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, lf := range logFiles {
+		names = append(names, lf.LogFileName)
+	}
+	if expected := []string{"slowquery/mysql-slowquery.log.2", "slowquery/mysql-slowquery.log"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected the files from the last 2 hours, oldest first, got %v", names)
+	}
+
+	for _, lf := range logFiles {
+		if err := c.sendLogFile(lf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if expected := []string{"# Time: 1\n", "select 1;\n", "# Time: 3\nselect 3;\n"}; !reflect.DeepEqual(out.blobs, expected) {
+		t.Errorf("expected %q, got %q", expected, out.blobs)
+	}
+}
@@ -0,0 +1,93 @@
+package cli
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rds"
+)
+
+// maxBinarySkip is the furthest we'll probe for the end of a stretch of
+// binary data in one go. If it goes on further than this we skip this much,
+// and look again from there.
+const maxBinarySkip = 1024 * 1024
+
+// isBinaryData is true for the error RDS returns when asked for a portion of
+// log it won't return because it contains binary data
+func isBinaryData(err error) bool {
+	return strings.HasPrefix(err.Error(), "InvalidParameterValue: This file contains binary data")
+}
+
+// skipBinary finds how many bytes past sPos we need to skip to get past data
+// RDS won't return because it's binary. It probes in small steps, doubling
+// each time, until it gets past the binary data, then bisects to find the
+// smallest skip that does, so we lose as little log as possible.
+func (c *CLI) skipBinary(sPos StreamPos) (int, error) {
+	// lo is a skip known to still be in binary data, hi a candidate that
+	// might get past it
+	lo, hi := 0, 1
+	for {
+		ok, err := c.readableAt(sPos, hi)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			break
+		}
+		if hi >= maxBinarySkip {
+			return maxBinarySkip, nil
+		}
+		lo = hi
+		hi *= 2
+		if hi > maxBinarySkip {
+			hi = maxBinarySkip
+		}
+	}
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		ok, err := c.readableAt(sPos, mid)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	return hi, nil
+}
+
+// readableAt is true if RDS will return the log skip bytes past sPos
+func (c *CLI) readableAt(sPos StreamPos, skip int) (bool, error) {
+	marker, err := sPos.Add(skip)
+	if err != nil {
+		return false, err
+	}
+	for {
+		_, err = c.RDS.DownloadDBLogFilePortion(&rds.DownloadDBLogFilePortionInput{
+			DBInstanceIdentifier: aws.String(c.Options.InstanceIdentifier),
+			LogFileName:          aws.String(sPos.logFile.LogFileName),
+			Marker:               aws.String(marker),
+			NumberOfLines:        aws.Int64(1),
+		})
+		if err == nil {
+			return true, nil
+		}
+		if isBinaryData(err) {
+			return false, nil
+		}
+		if strings.HasPrefix(err.Error(), "Throttling: Rate exceeded") {
+			c.waitFor(time.Duration(c.Options.BackoffTimer) * time.Second)
+			// waitFor returns straight away once we've been stopped
+			select {
+			case <-c.Abort:
+				return false, ErrAborted
+			default:
+			}
+			continue
+		}
+		return false, err
+	}
+}
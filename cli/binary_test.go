@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rds"
+)
+
+func TestSkipBinary(t *testing.T) {
+	// bytes [1000, 1300) of hour 12 are binary
+	var calls int
+	fake := &FakeRDS{
+		portions: func(in *rds.DownloadDBLogFilePortionInput) (*rds.DownloadDBLogFilePortionOutput, error) {
+			calls++
+			offset, _ := strconv.Atoi(strings.Split(*in.Marker, ":")[1])
+			if offset >= 1000 && offset < 1300 {
+				return nil, errors.New("InvalidParameterValue: This file contains binary data and should be downloaded instead of viewed.")
+			}
+			return &rds.DownloadDBLogFilePortionOutput{LogFileData: aws.String("line\n")}, nil
+		},
+	}
+	c := &CLI{Options: &Options{}, RDS: fake}
+	sPos := StreamPos{logFile: LogFile{LogFileName: "slowquery/mysql-slowquery.log"}, marker: "12:1000"}
+	skip, err := c.skipBinary(sPos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skip != 300 {
+		t.Errorf("expected to skip 300 bytes, got %d", skip)
+	}
+	// 10 doublings to get past it, and 9 bisections back
+	if calls > 20 {
+		t.Errorf("expected at most 20 calls, took %d", calls)
+	}
+}
+
+func TestSkipBinaryGivesUpAtMax(t *testing.T) {
+	fake := &FakeRDS{
+		portions: func(in *rds.DownloadDBLogFilePortionInput) (*rds.DownloadDBLogFilePortionOutput, error) {
+			return nil, errors.New("InvalidParameterValue: This file contains binary data and should be downloaded instead of viewed.")
+		},
+	}
+	c := &CLI{Options: &Options{}, RDS: fake}
+	skip, err := c.skipBinary(StreamPos{marker: "12:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skip != maxBinarySkip {
+		t.Errorf("expected to skip %d bytes, got %d", maxBinarySkip, skip)
+	}
+}
+
+func TestReadableAtStopsWhenAborted(t *testing.T) {
+	var calls int
+	fake := &FakeRDS{
+		portions: func(in *rds.DownloadDBLogFilePortionInput) (*rds.DownloadDBLogFilePortionOutput, error) {
+			calls++
+			if calls > 100 {
+				return &rds.DownloadDBLogFilePortionOutput{}, nil
+			}
+			return nil, errors.New("Throttling: Rate exceeded")
+		},
+	}
+	abort := make(chan bool)
+	close(abort)
+	c := &CLI{Options: &Options{BackoffTimer: 5}, RDS: fake, Abort: abort}
+	if _, err := c.readableAt(StreamPos{marker: "12:0"}, 100); err != ErrAborted {
+		t.Errorf("expected to be aborted, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected to stop after being throttled once, called %d times", calls)
+	}
+}
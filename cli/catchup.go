@@ -0,0 +1,148 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/sirupsen/logrus"
+
+	"github.com/honeycombio/rdslogs/publisher"
+)
+
+// splitMarker splits an RDS "hour:offset" marker in to its parts
+func splitMarker(marker string) (string, int64, bool) {
+	parts := strings.Split(marker, ":")
+	if len(parts) != 2 {
+		return "", 0, false
+	}
+	offset, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return parts[0], offset, true
+}
+
+// catchUp reads what's left of a log that's been rotated out from under us,
+// from where we'd got to in it. Without it, falling behind (after being
+// throttled, say) loses the end of the log when it's rotated. RDS markers
+// are positions within the log, so the marker we'd reached in the active file
+// still applies to the file it was renamed to.
+//
+// If the rest of the log can't be read, the gap is reported to the output.
+func (c *CLI) catchUp(from StreamPos, rotatedFile string) {
+	if _, _, ok := splitMarker(from.marker); !ok {
+		// we hadn't started reading; there's nothing to catch up on
+		return
+	}
+	pos := StreamPos{logFile: LogFile{LogFileName: rotatedFile}, marker: from.marker}
+	logrus.WithFields(logrus.Fields{
+		"file":   rotatedFile,
+		"marker": pos.marker,
+	}).Info("Log rotated, reading the rest of it before moving on")
+	for {
+		select {
+		case <-c.Abort:
+			return
+		default:
+		}
+		resp, err := c.getRecentEntries(pos)
+		if err != nil {
+			if strings.HasPrefix(err.Error(), "Throttling: Rate exceeded") {
+				c.waitFor(time.Duration(c.Options.BackoffTimer) * time.Second)
+				continue
+			}
+			c.reportGap(pos, fmt.Sprintf("rotated before it was read: %s", err))
+			return
+		}
+		data := aws.StringValue(resp.LogFileData)
+		if data != "" {
+			c.output.Write(data)
+		}
+		// a rotated file isn't written to any more, so "0" means we've
+		// reached the end of it
+		next := aws.StringValue(resp.Marker)
+		if next == "0" {
+			if data == "" {
+				break
+			}
+			if next, err = pos.Add(len(data)); err != nil {
+				break
+			}
+		}
+		done := !aws.BoolValue(resp.AdditionalDataPending) || next == pos.marker
+		pos.marker = next
+		if done {
+			break
+		}
+	}
+
+	// make sure we really did get to the end of it
+	if _, offset, ok := splitMarker(pos.marker); ok {
+		if size, ok := c.logFileSize(rotatedFile); ok && size > offset {
+			c.reportGap(pos, "rotated file ended before its reported size")
+		}
+	}
+}
+
+// reportGap tells the output about log from sPos onwards that we'll never
+// read. The gap runs to the end of the file, if we can tell where that is.
+func (c *CLI) reportGap(sPos StreamPos, reason string) {
+	gap := publisher.Gap{
+		LogFile: sPos.logFile.LogFileName,
+		Marker:  sPos.marker,
+		Reason:  reason,
+	}
+	if size, ok := c.logFileSize(sPos.logFile.LogFileName); ok {
+		if _, offset, ok := splitMarker(sPos.marker); ok && size > offset {
+			gap.Bytes = size - offset
+		}
+	}
+	logrus.WithFields(logrus.Fields{
+		"file":   gap.LogFile,
+		"marker": gap.Marker,
+		"bytes":  gap.Bytes,
+	}).Warn("unable to read log, reporting a gap: " + reason)
+	c.output.Flush()
+	c.output.Gap(gap)
+}
+
+// logFileSize looks up the size of a log file, returning false if it isn't
+// there (or we can't tell)
+func (c *CLI) logFileSize(name string) (int64, bool) {
+	logFiles, err := c.listLogFiles()
+	if err != nil {
+		return 0, false
+	}
+	for _, lf := range logFiles {
+		if lf.LogFileName == name {
+			return lf.Size, true
+		}
+	}
+	return 0, false
+}
+
+// nextLogFile returns the log file written after current, for logs where
+// each rotation starts a new file, or an empty LogFile if there isn't one
+// yet. If we've fallen more than one file behind, that's the one after current
+// rather than the newest, so we don't skip any. If current has gone (RDS only
+// keeps logs for so long), gone is true and it returns the oldest file left.
+func (c *CLI) nextLogFile(current string) (next LogFile, gone bool, err error) {
+	logFiles, err := c.GetLogFiles()
+	if err != nil {
+		return LogFile{}, false, err
+	}
+	sort.SliceStable(logFiles, func(i, j int) bool { return logFiles[i].LastWritten < logFiles[j].LastWritten })
+	for i, lf := range logFiles {
+		if lf.LogFileName == current {
+			if i+1 < len(logFiles) {
+				return logFiles[i+1], false, nil
+			}
+			return LogFile{}, false, nil
+		}
+	}
+	return logFiles[0], true, nil
+}
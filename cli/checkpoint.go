@@ -0,0 +1,110 @@
+package cli
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Checkpoint records how far through a log rdslogs has read, so that it can
+// pick up where it left off when restarted.
+type Checkpoint struct {
+	// LogGroup is the CloudWatch Logs log group being read
+	LogGroup string `json:"log_group,omitempty"`
+	// Timestamp is the time (in msec since the epoch) of the newest
+	// CloudWatch Logs event read
+	Timestamp int64 `json:"timestamp,omitempty"`
+	// EventIDs are the IDs of the events read at Timestamp. We ask for events
+	// starting at Timestamp, so we need them to skip the ones already read.
+	EventIDs []string `json:"event_ids,omitempty"`
+
+	// Instance is the RDS instance whose log is being tailed
+	Instance string `json:"instance,omitempty"`
+	// LogFile is the RDS log file being tailed
+	LogFile string `json:"log_file,omitempty"`
+	// Marker is the position in LogFile to read from next
+	Marker string `json:"marker,omitempty"`
+}
+
+// loadCheckpoint reads the checkpoint in path, returning an empty checkpoint
+// if there isn't one yet
+func loadCheckpoint(path string) (*Checkpoint, error) {
+	cp := &Checkpoint{}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// save writes the checkpoint to path. It writes a temporary file and renames
+// it in to place, so a crash never leaves a half-written checkpoint behind.
+func (cp *Checkpoint) save(path string) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// resumePos returns the position in the RDS log to carry on from, if the
+// checkpoint is for the log being tailed and its file is still there
+func (c *CLI) resumePos(cp *Checkpoint) (StreamPos, bool) {
+	if cp.LogFile == "" || cp.Instance != c.Options.InstanceIdentifier {
+		return StreamPos{}, false
+	}
+	if _, ok := c.logFileSize(cp.LogFile); !ok {
+		logrus.WithField("file", cp.LogFile).
+			Warn("checkpointed log file is gone, starting from the latest file")
+		return StreamPos{}, false
+	}
+	logrus.WithFields(logrus.Fields{
+		"file":   cp.LogFile,
+		"marker": cp.Marker,
+	}).Info("Resuming from checkpoint")
+	return StreamPos{logFile: LogFile{LogFileName: cp.LogFile}, marker: cp.Marker}, true
+}
+
+// saveStreamCheckpoint records sPos as the position to carry on from. The
+// output may not have sent everything before sPos yet, so what it had
+// buffered is lost if rdslogs dies: delivery is at most once, as the flag's
+// help says.
+func (c *CLI) saveStreamCheckpoint(cp *Checkpoint, sPos StreamPos) {
+	cp.Instance = c.Options.InstanceIdentifier
+	cp.LogFile = sPos.logFile.LogFileName
+	cp.Marker = sPos.marker
+	c.saveCheckpoint(cp)
+}
+
+// saveCheckpoint saves cp to --checkpoint_file, if there is one. Once
+// leadership is lost it's the new leader's checkpoint, so it's left alone.
+func (c *CLI) saveCheckpoint(cp *Checkpoint) {
+	if c.Options.Tail.CheckpointFile == "" || c.leadershipLost() {
+		return
+	}
+	if err := cp.save(c.Options.Tail.CheckpointFile); err != nil {
+		logrus.WithError(err).Warn("unable to save checkpoint")
+	}
+}
@@ -0,0 +1,139 @@
+package cli
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rds"
+)
+
+func TestStreamCheckpoint(t *testing.T) {
+	c := &CLI{
+		Options: &Options{
+			InstanceIdentifier: "db",
+			Tail:               TailOptions{CheckpointFile: filepath.Join(t.TempDir(), "checkpoint.json")},
+		},
+		RDS: &FakeRDS{logFiles: []*rds.DescribeDBLogFilesDetails{{
+			LogFileName: aws.String("error/postgresql.log.2022-05-17-10"),
+			LastWritten: aws.Int64(1000),
+			Size:        aws.Int64(5000),
+		}}},
+	}
+	cp := &Checkpoint{}
+	c.saveStreamCheckpoint(cp, StreamPos{logFile: LogFile{LogFileName: "error/postgresql.log.2022-05-17-10"}, marker: "10:2000"})
+
+	cp, err := loadCheckpoint(c.Options.Tail.CheckpointFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sPos, ok := c.resumePos(cp)
+	if !ok || sPos.logFile.LogFileName != "error/postgresql.log.2022-05-17-10" || sPos.marker != "10:2000" {
+		t.Errorf("expected to resume at error/postgresql.log.2022-05-17-10 10:2000, got %v %v", sPos, ok)
+	}
+
+	// a checkpoint for another instance doesn't apply
+	c.Options.InstanceIdentifier = "other"
+	if _, ok := c.resumePos(cp); ok {
+		t.Error("resumed from another instance's checkpoint")
+	}
+
+	// nor does one whose file has gone
+	c.Options.InstanceIdentifier = "db"
+	cp.LogFile = "error/postgresql.log.2022-05-16-10"
+	if _, ok := c.resumePos(cp); ok {
+		t.Error("resumed from a log file that's gone")
+	}
+}
+
+func TestStreamAbortSavesCheckpoint(t *testing.T) {
+	abort := make(chan bool)
+	c := &CLI{
+		Options: &Options{
+			InstanceIdentifier: "db",
+			DBType:             DBTypePostgreSQL,
+			LogType:            LogTypeQuery,
+			LogFile:            "error/postgresql.log",
+			Output:             "stdout",
+			NumLines:           100,
+			Tail:               TailOptions{CheckpointFile: filepath.Join(t.TempDir(), "checkpoint.json")},
+		},
+		RDS: &FakeRDS{
+			logFiles: []*rds.DescribeDBLogFilesDetails{{
+				LogFileName: aws.String("error/postgresql.log.2022-05-17-10"),
+				LastWritten: aws.Int64(1000),
+				Size:        aws.Int64(5000),
+			}},
+			portions: func(*rds.DownloadDBLogFilePortionInput) (*rds.DownloadDBLogFilePortionOutput, error) {
+				// stop once we've read something
+				close(abort)
+				return &rds.DownloadDBLogFilePortionOutput{
+					AdditionalDataPending: aws.Bool(true),
+					LogFileData:           aws.String("2022-05-17 10:00:00 UTC::@:[1]:LOG:  checkpoint starting\n"),
+					Marker:                aws.String("10:100"),
+				}, nil
+			},
+		},
+		Abort: abort,
+	}
+	if err := c.Stream(); err != ErrAborted {
+		t.Fatalf("expected ErrAborted, got %v", err)
+	}
+	cp, err := loadCheckpoint(c.Options.Tail.CheckpointFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.LogFile != "error/postgresql.log.2022-05-17-10" || cp.Marker != "10:100" {
+		t.Errorf("expected a checkpoint at error/postgresql.log.2022-05-17-10 10:100, got %+v", cp)
+	}
+}
+
+func TestStreamLostLeadershipLeavesCheckpoint(t *testing.T) {
+	abort := make(chan bool)
+	lost := make(chan struct{})
+	checkpointFile := filepath.Join(t.TempDir(), "checkpoint.json")
+	c := &CLI{
+		Options: &Options{
+			InstanceIdentifier: "db",
+			DBType:             DBTypePostgreSQL,
+			LogType:            LogTypeQuery,
+			LogFile:            "error/postgresql.log",
+			Output:             "stdout",
+			NumLines:           100,
+			Tail:               TailOptions{CheckpointFile: checkpointFile},
+		},
+		RDS: &FakeRDS{
+			logFiles: []*rds.DescribeDBLogFilesDetails{{
+				LogFileName: aws.String("error/postgresql.log.2022-05-17-10"),
+				LastWritten: aws.Int64(1000),
+				Size:        aws.Int64(5000),
+			}},
+			portions: func(*rds.DownloadDBLogFilePortionInput) (*rds.DownloadDBLogFilePortionOutput, error) {
+				// another replica takes over, and gets further than we have
+				newLeader := &Checkpoint{Instance: "db", LogFile: "error/postgresql.log.2022-05-17-10", Marker: "10:900"}
+				if err := newLeader.save(checkpointFile); err != nil {
+					t.Fatal(err)
+				}
+				close(lost)
+				close(abort)
+				return &rds.DownloadDBLogFilePortionOutput{
+					AdditionalDataPending: aws.Bool(true),
+					LogFileData:           aws.String("2022-05-17 10:00:00 UTC::@:[1]:LOG:  checkpoint starting\n"),
+					Marker:                aws.String("10:100"),
+				}, nil
+			},
+		},
+		Abort: abort,
+		lost:  lost,
+	}
+	if err := c.Stream(); err != ErrAborted {
+		t.Fatalf("expected ErrAborted, got %v", err)
+	}
+	cp, err := loadCheckpoint(checkpointFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.Marker != "10:900" {
+		t.Errorf("expected the new leader's checkpoint to be left alone, got %+v", cp)
+	}
+}
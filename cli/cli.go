@@ -4,59 +4,126 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 	"github.com/aws/aws-sdk-go/service/rds"
-	"github.com/honeycombio/honeytail/parsers"
-	"github.com/honeycombio/honeytail/parsers/csv"
-	"github.com/honeycombio/honeytail/parsers/mysql"
-	"github.com/honeycombio/honeytail/parsers/postgresql"
+	"github.com/aws/aws-sdk-go/service/rds/rdsiface"
 	"github.com/honeycombio/rdslogs/publisher"
 	"github.com/sirupsen/logrus"
 )
 
-// Fortunately for us, the RDS team has diligently ignored requests to make
-// RDS Postgres's `log_line_prefix` customizable for years
-// (https://forums.aws.amazon.com/thread.jspa?threadID=143460).
-// So we can hard-code this prefix format for Postgres log lines.
+// rdsPostgresLinePrefix is the `log_line_prefix` RDS Postgres uses out of the
+// box. For years it couldn't be changed at all
+// (https://forums.aws.amazon.com/thread.jspa?threadID=143460); newer versions
+// allow it to be set in the DB parameter group, so we look it up there and
+// fall back to this.
 const rdsPostgresLinePrefix = "%t:%r:%u@%d:[%p]:"
 
 const DBTypePostgreSQL = "postgresql"
 const DBTypeMySQL = "mysql"
+const DBTypeMariaDB = "mariadb"
+const DBTypeOracle = "oracle"
+const DBTypeSQLServer = "sqlserver"
+
+// dbTypes are the accepted --dbtype values
+var dbTypes = []string{DBTypeMySQL, DBTypeMariaDB, DBTypePostgreSQL, DBTypeOracle, DBTypeSQLServer}
+
+// ErrAborted is returned when rdslogs stops because it was told to, rather
+// than because something went wrong
+var ErrAborted = errors.New("signal triggered exit")
+
+const SourceRDS = "rds"
+const SourceCloudWatch = "cloudwatch"
 
 const LogTypeQuery = "query"
 const LogTypeAudit = "audit"
+const LogTypeError = "error"
+const LogTypeGeneral = "general"
+const LogTypeAlert = "alert"
+const LogTypeListener = "listener"
+const LogTypeAgent = "agent"
 
 // Options contains all the CLI flags
 type Options struct {
-	Region             string            `long:"region" description:"AWS region to use" default:"us-east-1"`
-	InstanceIdentifier string            `short:"i" long:"identifier" description:"RDS instance identifier"`
-	DBType             string            `long:"dbtype" description:"RDS database type. Accepted values are mysql and postgresql." default:"mysql"`
-	LogType            string            `long:"log_type" description:"Log file type. Accepted values are query and audit. Audit is currently only supported for mysql." default:"query"`
-	LogFile            string            `short:"f" long:"log_file" description:"RDS log file to retrieve"`
-	Download           bool              `short:"d" long:"download" description:"Download old logs instead of tailing the current log"`
-	DownloadDir        string            `long:"download_dir" description:"directory in to which log files are downloaded" default:"./"`
-	NumLines           int64             `long:"num_lines" description:"number of lines to request at a time from AWS. Larger number will be more efficient, smaller number will allow for longer lines" default:"10000"`
-	BackoffTimer       int64             `long:"backoff_timer" description:"how many seconds to pause when rate limited by AWS." default:"5"`
-	Output             string            `short:"o" long:"output" description:"output for the logs: stdout or honeycomb" default:"stdout"`
-	WriteKey           string            `long:"writekey" description:"Team write key, when output is honeycomb"`
-	Dataset            string            `long:"dataset" description:"Name of the dataset, when output is honeycomb"`
-	APIHost            string            `long:"api_host" description:"Hostname for the Honeycomb API server" default:"https://api.honeycomb.io/"`
-	ScrubQuery         bool              `long:"scrub_query" description:"Replaces the query field with a one-way hash of the contents"`
-	SampleRate         int               `long:"sample_rate" description:"Only send 1 / N log lines" default:"1"`
-	AddFields          map[string]string `short:"a" long:"add_field" description:"Extra fields to send in request, in the style of \"field:value\""`
-	NumParsers         int               `long:"num_parsers" default:"4" description:"Number of parsers to spin up. Currently only supported for the mysql parser."`
+	Region               string             `long:"region" description:"AWS region to use" default:"us-east-1"`
+	RoleARN              string             `long:"role_arn" description:"IAM role to assume for calls to AWS, such as one in the account the instance is in"`
+	ExternalID           string             `long:"external_id" description:"External ID to assume --role_arn with, if its trust policy requires one"`
+	WebIdentityTokenFile string             `long:"web_identity_token_file" description:"File holding an OIDC token to assume --role_arn with, as for IAM roles for Kubernetes service accounts. The AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE variables EKS sets are used without it"`
+	RoleSessionName      string             `long:"role_session_name" description:"Session name to assume --role_arn with, as shown in CloudTrail" default:"rdslogs"`
+	InstanceIdentifier   string             `short:"i" long:"identifier" description:"RDS instance identifier"`
+	DBType               string             `long:"dbtype" description:"RDS database type. Accepted values are mysql, mariadb, postgresql, oracle and sqlserver. Defaults to the instance's engine."`
+	LogType              string             `long:"log_type" description:"Log file type. mysql and mariadb accept query, audit, error and general; postgresql accepts query and audit; oracle accepts alert, listener and audit; sqlserver accepts error and agent. Defaults to query, or alert for oracle and error for sqlserver."`
+	LogFile              string             `short:"f" long:"log_file" description:"RDS log file to retrieve"`
+	LogLinePrefix        string             `long:"log_line_prefix" description:"Postgres log_line_prefix format. Defaults to the value in the instance's DB parameter group."`
+	AuditPassThrough     bool               `long:"audit_passthrough" description:"For postgresql audit logs, also send the log lines that aren't pgaudit records"`
+	SeqScanRows          int                `long:"seq_scan_rows" description:"For postgresql auto_explain plans, list Seq Scans over at least this many rows in plan_seq_scans" default:"10000"`
+	Source               string             `long:"source" description:"Where to read logs from: rds, which tails the log file with the RDS API, or cloudwatch, which reads the instance's log exports from CloudWatch Logs" default:"rds"`
+	LogGroup             string             `long:"log_group" description:"CloudWatch Logs log group to read, when source is cloudwatch. Defaults to /aws/rds/instance/<identifier>/<log type>"`
+	Input                []string           `long:"input" description:"Replay saved logs instead of reading from AWS: file:<path> (which may be a glob, and may be gzipped) or - for STDIN. May be given more than once"`
+	InputRate            int                `long:"input_rate" description:"When replaying --input, send at most this many lines per second. Defaults to as fast as possible"`
+	Download             bool               `short:"d" long:"download" description:"Download old logs instead of tailing the current log"`
+	NumLines             int64              `long:"num_lines" description:"number of lines to request at a time from AWS. Larger number will be more efficient. If lines are too long to fit, rdslogs asks for fewer until they do" default:"10000"`
+	BackoffTimer         int64              `long:"backoff_timer" description:"how many seconds to pause when rate limited by AWS." default:"5"`
+	LogListInterval      time.Duration      `long:"log_list_interval" description:"How often to list the log's files again when tailing. Every few minutes all the files are listed; in between, only the ones written to recently" default:"10s"`
+	RateLimits           map[string]float64 `long:"rate_limit" description:"Requests per second to allow for an RDS operation, shared by everything rdslogs is reading, as <operation>:<rate>. Defaults to DownloadDBLogFilePortion:5, DescribeDBLogFiles:2, DescribeDBInstances:2 and DescribeDBParameters:1; 0 is unlimited. May be given more than once"`
+	Output               string             `short:"o" long:"output" description:"output for the logs: stdout or honeycomb" default:"stdout"`
+	WriteKey             string             `long:"writekey" description:"Team write key, when output is honeycomb"`
+	WriteKeyFile         string             `long:"writekey_file" description:"File to read the team write key from instead of --writekey, so it isn't in the process's arguments"`
+	Dataset              string             `long:"dataset" description:"Name of the dataset, when output is honeycomb"`
+	APIHost              string             `long:"api_host" description:"Hostname for the Honeycomb API server" default:"https://api.honeycomb.io/"`
+	ScrubQuery           bool               `long:"scrub_query" description:"Replaces the query field with a one-way hash of the contents"`
+	SampleRate           int                `long:"sample_rate" description:"Only send 1 / N log lines" default:"1"`
+	AddFields            map[string]string  `short:"a" long:"add_field" description:"Extra fields to send in request, in the style of \"field:value\""`
+	NumParsers           int                `long:"num_parsers" default:"4" description:"Number of parsers to spin up. Currently only supported for the mysql parser."`
+	AggregateInterval    time.Duration      `long:"aggregate_interval" description:"Group events by query fingerprint and send one digest event per fingerprint per interval (eg 1m) instead of every event"`
+	AggregateRaw         bool               `long:"aggregate_send_raw" description:"When aggregating, also send the individual events alongside the digests"`
+	ShutdownTimeout      time.Duration      `long:"shutdown_timeout" description:"When stopped, how long to spend sending what's already been read before giving up and exiting" default:"30s"`
 
 	Version            bool   `short:"v" long:"version" description:"Output the current version and exit"`
 	ConfigFile         string `short:"c" long:"config" description:"config file" no-ini:"true"`
 	WriteDefaultConfig bool   `long:"write_default_config" description:"Write a default config file to STDOUT" no-ini:"true"`
 	Debug              bool   `long:"debug" description:"turn on debugging output"`
+
+	// StreamConfig is the structured --config file, if that's what it is
+	StreamConfig *Config `no-flag:"true"`
+	// Command is the command given, or "" to tail the log
+	Command string `no-flag:"true"`
+	// the flags of the commands that have their own
+	Tail            TailOptions     `no-flag:"true"`
+	DownloadOptions DownloadOptions `no-flag:"true"`
+	ListInstances   ListOptions     `no-flag:"true"`
+	ListLogs        ListOptions     `no-flag:"true"`
+	Backfill        BackfillOptions `no-flag:"true"`
+}
+
+// TailOptions are the flags of the tail command, for picking up where a
+// previous rdslogs left off and sharing the work with other replicas
+type TailOptions struct {
+	CheckpointFile string        `long:"checkpoint_file" description:"File in which to record how far through the log rdslogs has read, so it can pick up where it left off when restarted. Events read but not yet sent when rdslogs crashes are lost"`
+	LeaderElection string        `long:"leader_election" description:"Run several replicas and only stream from the elected leader: file:<path> to lock a file on shared storage, or dynamodb:<table> to take a lease in a DynamoDB table"`
+	LeaderLease    time.Duration `long:"leader_lease" description:"How long the leader's (or a worker's) lease lasts without being renewed. Standbys take over within this long of the leader going away" default:"15s"`
+	LeaderID       string        `long:"leader_id" description:"Name of this replica for leader election or sharding. Defaults to <hostname>-<pid>"`
+	Shard          string        `long:"shard" description:"Run as one of a pool of workers that split the --stream logs between them: file:<dir> to coordinate through a directory on shared storage, or dynamodb:<table> to use a DynamoDB table"`
+	Streams        []string      `long:"stream" description:"With --shard, a log to tail, as <identifier> or <identifier>:<log_type>. May be given more than once"`
+	CheckpointDir  string        `long:"checkpoint_dir" description:"With --shard, directory on shared storage in which to keep each stream's checkpoint"`
+	// DownloadDir is ignored. It's only accepted so tailing with
+	// --download_dir, which every mode used to take, still works.
+	DownloadDir string `long:"download_dir" hidden:"true" no-ini:"true"`
+}
+
+// DownloadOptions are the flags of the download command
+type DownloadOptions struct {
+	DownloadDir string `long:"download_dir" description:"directory in to which log files are downloaded" default:"./"`
 }
 
 // Usage info for --help
@@ -69,29 +136,114 @@ AWS credentials are required and can be provided via IAM roles, AWS shared
 config (~/.aws/config), AWS shared credentials (~/.aws/credentials), or
 the environment variables AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
 
+--role_arn assumes an IAM role for the calls to AWS, such as one in the
+account the instance is in, with --external_id if the role requires one, or
+with the OIDC token in --web_identity_token_file. The credentials are renewed
+before they expire.
+
+Commands list what there is to read, or read it in different ways:
+list-instances lists the RDS instances, list-logs an instance's log files,
+tail (the default) streams the log, download saves its files locally, and
+backfill sends its recent files through the parser and output. Run
+"rdslogs <command> --help" for each command's flags.
+
 Passing --download triggers Download Mode, in which rdslogs will download the
 specified logs to the directory specified by --download_dir. Logs are specified
 via the --log_file flag, which names an active log file as well as the past 24
 hours of rotated logs. (For example, specifying --log_file=foo.log will download
 foo.log as well as foo.log.0, foo.log.2, ... foo.log.23.)
 
+The database type, log type and log file default to what suits the instance's
+engine, as reported by RDS. Passing --dbtype overrides the detected engine.
+
+--input replays logs saved locally, such as those written by --download,
+through the same parsers and output without talking to AWS. --dbtype is
+required, as there's no instance to detect it from.
+
+--source=cloudwatch reads the logs the instance exports to CloudWatch Logs,
+which avoids the rate limits on the RDS log API.
+
+--checkpoint_file records how far through the log rdslogs has read (the last
+event, or the RDS log file and marker), so a restarted rdslogs picks up where
+it left off. It records what's been read rather than what's been sent, so
+delivery is at most once: events still buffered when rdslogs crashes are lost.
+
+--leader_election lets several replicas of rdslogs run at once, with only the
+elected leader streaming logs. If the leader goes away, a standby takes over
+from --checkpoint_file, which should be on storage the replicas share.
+
+--shard runs rdslogs as one of a pool of workers, which split the --stream
+logs between them and hand them out again as workers join and leave. Each
+stream picks up from its own checkpoint in --checkpoint_dir.
+
+--config may also be a YAML or JSON file (ending in .yaml, .yml or .json)
+listing several streams, each with its own instance, dbtype, log type, output,
+sampling and fields, over a block of defaults. "rdslogs validate" checks it
+without talking to AWS.
+
+Every option can also be set with an RDSLOGS_ environment variable named for
+it, such as RDSLOGS_WRITEKEY for --writekey, with several values
+comma-separated. Flags win over the environment, which wins over --config.
+
 When --output is set to "honeycomb", the --writekey and --dataset flags are
 required. Instead of being printed to STDOUT, database events from the log will
 be transmitted to Honeycomb. --scrub_query and --sample_rate also only apply to
 honeycomb output.
+
+For very busy databases, --aggregate_interval groups events by query
+fingerprint and sends a single digest event per fingerprint per interval, with
+the count and sum/min/max/p50/p95/p99 of query_time, lock_time, rows_sent and
+rows_examined (or duration, for postgresql). Add --aggregate_send_raw to send
+the individual events as well. Aggregation only applies to honeycomb output.
 `
 
+// ReadWriteKeyFile sets the write key from --writekey_file, if it's given
+func (opts *Options) ReadWriteKeyFile() error {
+	if opts.WriteKeyFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(opts.WriteKeyFile)
+	if err != nil {
+		return fmt.Errorf("unable to read the write key: %s", err)
+	}
+	opts.WriteKey = strings.TrimSpace(string(data))
+	if opts.WriteKey == "" {
+		return fmt.Errorf("write key file %s is empty", opts.WriteKeyFile)
+	}
+	return nil
+}
+
 // CLI contains handles to the provided Options + aws.RDS struct
 type CLI struct {
 	// Options is for command line options
 	Options *Options
 	// RDS is an initialized session connected to RDS
-	RDS *rds.RDS
-	// Abort carries a true message when we catch CTRL-C so we can clean up
+	RDS rdsiface.RDSAPI
+	// CloudWatchLogs is an initialized session connected to CloudWatch Logs,
+	// when reading from CloudWatch
+	CloudWatchLogs cloudwatchlogsiface.CloudWatchLogsAPI
+	// DynamoDB is an initialized session connected to DynamoDB, when electing
+	// a leader with a DynamoDB table
+	DynamoDB dynamodbiface.DynamoDBAPI
+	// StreamClients returns RDS and CloudWatch Logs clients for a stream
+	// whose region or role isn't the one in Options
+	StreamClients func(opts *Options) (rdsiface.RDSAPI, cloudwatchlogsiface.CloudWatchLogsAPI)
+	// Abort is closed when we're stopped, by a signal or by losing
+	// leadership, so we can clean up
 	Abort chan bool
+	// lost is closed, as well as Abort, when RunAsLeader loses leadership
+	lost chan struct{}
 
 	// target to which to send output
 	output publisher.Publisher
+	// how many lines to ask RDS for at a time
+	pageSize *pageSizer
+	// the files of the log being tailed
+	inventory *logInventory
+	// reloadMu guards the reloadable Options and output against Reload
+	reloadMu sync.Mutex
+	// reloads passes reloaded options on to a worker pool's streams
+	reloads chan *Options
 	// allow changing the time for tests
 	fakeNower Nower
 }
@@ -99,63 +251,58 @@ type CLI struct {
 // Stream polls the RDS log endpoint forever to effectively tail the logs and
 // spits them out to either stdout or to Honeycomb.
 func (c *CLI) Stream() error {
+	src, err := c.source()
+	if err != nil {
+		return err
+	}
+	// keep track of the log's files as we go, rather than list them all
+	// whenever we need to know
+	c.logInventory()
 	// make sure we have a valid log file from which to stream
 	latestFile, err := c.GetLatestLogFile()
 	if err != nil {
 		return err
 	}
-	// create the chosen output publisher target
-	if c.Options.Output == "stdout" {
-		c.output = &publisher.STDOUTPublisher{}
-	} else {
-		var parser parsers.Parser
-		if c.Options.DBType == DBTypeMySQL && c.Options.LogType == LogTypeQuery {
-			parser = &mysql.Parser{}
-			parser.Init(&mysql.Options{NumParsers: c.Options.NumParsers})
-		} else if c.Options.DBType == DBTypeMySQL && c.Options.LogType == LogTypeAudit {
-			parser = &csv.Parser{}
-			parser.Init(&csv.Options{
-				Fields:          "time,hostname,user,source_addr,connection_id,query_id,event_type,database,query,error_code",
-				NumParsers:      c.Options.NumParsers,
-				TimeFieldName:   "time",
-				TimeFieldFormat: "20060102 15:04:05",
-			})
-		} else if c.Options.DBType == DBTypePostgreSQL {
-			parser = &postgresql.Parser{}
-			parser.Init(&postgresql.Options{LogLinePrefix: rdsPostgresLinePrefix})
-		} else {
-			return fmt.Errorf(
-				"Unsupported (dbtype, log_type) pair (`%s`,`%s`)",
-				c.Options.DBType, c.Options.LogType)
-		}
+	closeOutput, err := c.openOutput(src)
+	if err != nil {
+		return err
+	}
+	defer closeOutput()
 
-		pub := &publisher.HoneycombPublisher{
-			Writekey:   c.Options.WriteKey,
-			Dataset:    c.Options.Dataset,
-			APIHost:    c.Options.APIHost,
-			ScrubQuery: c.Options.ScrubQuery,
-			SampleRate: c.Options.SampleRate,
-			AddFields:  c.Options.AddFields,
-			Parser:     parser,
-		}
-		defer pub.Close()
-		c.output = pub
+	c.pageSize = newPageSizer(c.Options.NumLines)
+	// note the log files as they are now, to tell when they're rotated
+	if _, err := c.detectRotation(StreamPos{logFile: latestFile}); err != nil {
+		return err
 	}
 
 	// forever, download the most recent entries
 	sPos := StreamPos{
-		logFile: LogFile{LogFileName: latestFile.LogFileName},
+		logFile: LogFile{LogFileName: src.rotation.startFile(c, latestFile)},
 	}
-	// for mysql audit logs, we always want the first logfile, which may not
-	// show up in GetLatestLogFiles if rdslogs started mid-rotation
-	if c.Options.DBType == DBTypeMySQL && c.Options.LogType == LogTypeAudit {
-		sPos.logFile.LogFileName = c.Options.LogFile
+	var cp *Checkpoint
+	if c.Options.Tail.CheckpointFile != "" {
+		cp, err = loadCheckpoint(c.Options.Tail.CheckpointFile)
+		if err != nil {
+			return fmt.Errorf("unable to read checkpoint file %s: %s", c.Options.Tail.CheckpointFile, err)
+		}
+		if pos, ok := c.resumePos(cp); ok {
+			sPos = pos
+		}
 	}
 	for {
 		// check for signal triggered exit
 		select {
 		case <-c.Abort:
-			return fmt.Errorf("signal triggered exit")
+			if c.leadershipLost() {
+				c.discardOutput()
+				return ErrAborted
+			}
+			// finish sending what we've read before recording how far we got
+			closeOutput()
+			if cp != nil {
+				c.saveStreamCheckpoint(cp, sPos)
+			}
+			return ErrAborted
 		default:
 		}
 
@@ -167,13 +314,34 @@ func (c *CLI) Stream() error {
 				c.waitFor(time.Duration(c.Options.BackoffTimer) * time.Second)
 				continue
 			}
-			if strings.HasPrefix(err.Error(), "InvalidParameterValue: This file contains binary data") {
-				logrus.Infof("binary data at marker %s, skipping 1000 in marker position\n", sPos.marker)
+			if isBinaryData(err) {
 				// skip over inaccessible data
-				newMarker, err := sPos.Add(1000)
+				skip, err := c.skipBinary(sPos)
+				if err == ErrAborted {
+					// go round to stop, without skipping anything
+					continue
+				}
+				if err != nil {
+					logrus.WithError(err).
+						Warnf("unable to find the end of binary data at marker %s, skipping 1000 in marker position", sPos.marker)
+					skip = 1000
+				}
+				newMarker, err := sPos.Add(skip)
 				if err != nil {
 					return err
 				}
+				logrus.WithFields(logrus.Fields{
+					"file":   sPos.logFile.LogFileName,
+					"marker": sPos.marker,
+					"bytes":  skip,
+				}).Warn("skipped binary data")
+				c.output.Flush()
+				c.output.Gap(publisher.Gap{
+					LogFile: sPos.logFile.LogFileName,
+					Marker:  sPos.marker,
+					Bytes:   int64(skip),
+					Reason:  "binary data",
+				})
 				sPos.marker = newMarker
 				continue
 			}
@@ -185,103 +353,98 @@ func (c *CLI) Stream() error {
 			}
 			return err
 		}
-		if resp.LogFileData != nil {
-			c.output.Write(*resp.LogFileData)
+		data := aws.StringValue(resp.LogFileData)
+		if isTruncated(data) && c.pageSize.shrink() {
+			logrus.WithField("numLines", c.pageSize.size).
+				Info("log portion was truncated, asking for fewer lines")
+			continue
 		}
-		if c.Options.DBType == DBTypeMySQL && c.Options.LogType == LogTypeAudit {
-			// The MariaDB audit plugin rotates based on size, not time. If no data
-			// is being returned, it may have been rotated, or maybe the db is just
-			// very quiet and nothing is being logged. We'll have to inspect
-			// the log sizes to be sure
-
-			// If we reset our marker, asked for logs, and got an empty marker back,
-			// we don't have anything to do but wait
-			if sPos.marker == "0" && (resp.Marker != nil && *resp.Marker == "") {
-				c.waitFor(time.Second * 5)
-				continue
+		// if the log rolled over before this portion, what's left of the old
+		// log has to go first
+		rolledOver := false
+		if r, ok := src.rotation.(rolloverRotation); ok {
+			rolledOver = r.rollover(c, sPos, resp)
+		}
+		if isTruncated(data) {
+			// a single line is bigger than a whole portion, so report it
+			// rather than parse part of it, and move on
+			logrus.WithFields(logrus.Fields{
+				"file":   sPos.logFile.LogFileName,
+				"marker": sPos.marker,
+			}).Warn("log line too long to download, sending it as truncated")
+			c.output.Flush()
+			c.output.Truncated(strings.TrimSuffix(data, truncatedSuffix), sPos.logFile.LogFileName)
+		} else {
+			c.pageSize.observe(len(data))
+			if resp.LogFileData != nil {
+				c.output.Write(data)
 			}
+		}
+		prevPos := sPos
+		sPos, err = src.rotation.next(c, sPos, resp)
+		if err != nil {
+			return err
+		}
+		if rotated(prevPos, sPos) && !rolledOver {
+			// the last line of the old log won't be finished by what we read
+			// next
+			c.output.Flush()
+		}
+		if cp != nil && (sPos.logFile.LogFileName != prevPos.logFile.LogFileName || sPos.marker != prevPos.marker) {
+			c.saveStreamCheckpoint(cp, sPos)
+		}
+	}
+}
 
-			// Two scenarios can occur during log rotation, depending on timing
-			// - the file doesn't exist because rotation is ongoing, in which case
-			// AdditionalDataPending will be false, marker will be "", and LogFileData will be nil
-			// - the file exists but it's been rotated, meaning our marker is wrong and doesn't point
-			// at a valid position - in this case, RDS will return the marker back to us with ""
-			// for logfile data
-			// In either scenario, we need to check for a new file. When we're sure there's a new file,
-			// reset the marker
-			if (resp.Marker != nil && resp.LogFileData != nil && sPos.marker == *resp.Marker) ||
-				!*resp.AdditionalDataPending && resp.LogFileData == nil {
-				newestFile, err := c.GetLatestLogFile()
-				if err != nil {
-					return err
-				}
+// rotated is true if moving from prev to next moves on to a different log,
+// either a new file or (for hourly rotated logs) a new hour's worth of it
+func rotated(prev, next StreamPos) bool {
+	if prev.logFile.LogFileName != next.logFile.LogFileName {
+		return true
+	}
+	if prev.marker == "" || next.marker == "" {
+		// we're only just starting
+		return false
+	}
+	return strings.Split(prev.marker, ":")[0] != strings.Split(next.marker, ":")[0]
+}
 
-				// If the latest log file doesn't match the first log file (i.e
-				// server_audit.log.1 exists but not server_audit.log) we're in the
-				// middle of a rotation, so let's wait
-				if newestFile.LogFileName != sPos.logFile.LogFileName {
-					logrus.WithFields(logrus.Fields{
-						"expectedFile": sPos.logFile.LogFileName,
-						"newestFile":   newestFile.LogFileName,
-					}).Info("newest file is a rotated file, we appear to be mid-rotation")
-					c.waitFor(time.Second * 5)
-					continue
-				}
+// openOutput creates the chosen output publisher target, and returns a func
+// that flushes and closes it. The func only closes it the first time it's
+// called, so it can be deferred and called early too.
+func (c *CLI) openOutput(src *logSource) (func(), error) {
+	c.reloadMu.Lock()
+	defer c.reloadMu.Unlock()
+	if c.Options.Output == "stdout" {
+		c.output = &publisher.STDOUTPublisher{}
+		return func() {}, nil
+	}
+	parser, err := src.newParser(c)
+	if err != nil {
+		return nil, err
+	}
 
-				// ok there's a server_audit.log file out there
-				// check the current position of the last read (this appears to be in bytes)
-				splitMarker := strings.Split(sPos.marker, ":")
-				if len(splitMarker) != 2 {
-					// something's wrong. marker should have been #:#
-					logrus.WithField("marker", sPos.marker).
-						Warn("marker didn't split into two pieces across a colon")
-					continue
-				}
-				offset, _ := strconv.Atoi(splitMarker[1])
-
-				// if our last position is greater in size than the current file
-				// a rotation has probably occurred and we can reset the marker
-				if int64(offset) > newestFile.Size {
-					logrus.WithFields(logrus.Fields{
-						"currentOffset": offset,
-						"newFileSize":   newestFile.Size,
-					}).Info("last marker offset exceeds newest file size, resetting marker to 0")
-					sPos.marker = "0"
-					continue
-				}
-			}
-		}
+	pub := &publisher.HoneycombPublisher{
+		Writekey:   c.Options.WriteKey,
+		Dataset:    c.Options.Dataset,
+		APIHost:    c.Options.APIHost,
+		ScrubQuery: c.Options.ScrubQuery,
+		SampleRate: c.Options.SampleRate,
+		AddFields:  c.Options.AddFields,
+		Parser:     parser,
 
-		if !*resp.AdditionalDataPending || (resp.Marker != nil && *resp.Marker == "0") {
-			if c.Options.DBType == DBTypePostgreSQL {
-				// If that's all we've got for now, see if there's a newer file to
-				// start tailing. This logic is only relevant for postgres: the
-				// newest postgres log file will be named
-				// error/postgresql.log.YYYY-MM-DD,
-				// but the newest mysql log
-				// will always be named
-				// slowquery/mysql-slowquery.log.
-				newestFile, err := c.GetLatestLogFile()
-				if err != nil {
-					return err
-				}
-				if newestFile.LogFileName != sPos.logFile.LogFileName {
-					logrus.WithFields(logrus.Fields{
-						"oldFile": sPos.logFile.LogFileName,
-						"newFile": newestFile.LogFileName}).Info("Found newer file")
-					sPos = StreamPos{logFile: LogFile{LogFileName: newestFile.LogFileName}}
-					continue
-				}
-			}
-			// Wait for a few seconds and try again.
-			c.waitFor(5 * time.Second)
-		}
-		newMarker := c.getNextMarker(sPos, resp)
-		logrus.WithFields(logrus.Fields{
-			"prevMarker": sPos.marker,
-			"newMarker":  newMarker,
-			"file":       sPos.logFile.LogFileName}).Info("Got new marker")
-		sPos.marker = newMarker
+		AggregateInterval: c.Options.AggregateInterval,
+		AggregateRaw:      c.Options.AggregateRaw,
+	}
+	c.output = pub
+	return pub.Close, nil
+}
+
+// discardOutput stops the output sending what it has buffered, when the new
+// leader is sending the same log from its checkpoint
+func (c *CLI) discardOutput() {
+	if d, ok := c.output.(interface{ Discard() }); ok {
+		d.Discard()
 	}
 }
 
@@ -316,25 +479,23 @@ func (c *CLI) getNextMarker(sPos StreamPos, resp *rds.DownloadDBLogFilePortionOu
 		}
 		return newMarkerStr
 	}
-	// we hit the end of a segment but we didn't get any data. we should try again
-	// during the 00-05 minutes past the hour time, and roll over once we get to 6
-	// minutes past the hour
-	var now time.Time
-	if c.fakeNower != nil {
-		now = c.fakeNower.Now().UTC()
-	} else {
-		now = time.Now().UTC()
+	// we hit the end of a segment but we didn't get any data. That's either
+	// because the log's been rotated and RDS is pointing us at the start of
+	// the new one, or because nothing's been logged yet and we should try
+	// again from where we were. Check the log files to see which.
+	rotated, err := c.detectRotation(sPos)
+	if err != nil {
+		logrus.WithError(err).
+			Warn("unable to check for log rotation, returning previous marker")
+		return sPos.marker
 	}
-	curMin, _ := strconv.Atoi(now.Format("04"))
-	if curMin > 5 {
+	if rotated {
 		logrus.WithField("newMarker", *resp.Marker).
-			Infof("no log data received but it's %d minutes (> 5) past "+
-				"the hour, returning resp marker", curMin)
+			Info("no log data received and the log has been rotated, returning resp marker")
 		return *resp.Marker
 	}
 	logrus.WithField("prevMarker", sPos.marker).
-		Infof("no log data received but it's %d minutes (< 5) past "+
-			"the hour, returning previous marker", curMin)
+		Info("no log data received and the log hasn't been rotated, returning previous marker")
 	// let's try again from where we did the last time.
 	return sPos.marker
 }
@@ -368,6 +529,9 @@ func (c *CLI) getRecentEntries(sPos StreamPos) (*rds.DownloadDBLogFilePortionOut
 		LogFileName:          aws.String(sPos.logFile.LogFileName),
 		NumberOfLines:        aws.Int64(c.Options.NumLines),
 	}
+	if c.pageSize != nil {
+		params.NumberOfLines = aws.Int64(c.pageSize.size)
+	}
 	// if we have a marker, download from there. otherwise get the most recent line
 	if sPos.marker != "" {
 		params.Marker = &sPos.marker
@@ -415,7 +579,7 @@ func (l *LogFile) String() string {
 
 // DownloadLogFiles returns a new copy of the logFile list because it mutates the contents.
 func (c *CLI) DownloadLogFiles(logFiles []LogFile) ([]LogFile, error) {
-	logrus.Infof("Downloading log files to %s\n", c.Options.DownloadDir)
+	logrus.Infof("Downloading log files to %s\n", c.Options.DownloadOptions.DownloadDir)
 	downloadedLogFiles := make([]LogFile, 0, len(logFiles))
 	for i := range logFiles {
 		// returned logFile has a modified Path
@@ -433,7 +597,7 @@ func (c *CLI) DownloadLogFiles(logFiles []LogFile) ([]LogFile, error) {
 // paginate it ourselves.
 func (c *CLI) downloadFile(logFile LogFile) (LogFile, error) {
 	// open the out file for writing
-	logFile.Path = path.Join(c.Options.DownloadDir, path.Base(logFile.LogFileName))
+	logFile.Path = path.Join(c.Options.DownloadOptions.DownloadDir, path.Base(logFile.LogFileName))
 	fmt.Printf("Downloading %s to %s ... ", logFile.LogFileName, logFile.Path)
 	defer fmt.Printf("done\n")
 	if err := os.MkdirAll(path.Dir(logFile.Path), os.ModePerm); err != nil {
@@ -445,6 +609,15 @@ func (c *CLI) downloadFile(logFile LogFile) (LogFile, error) {
 	}
 	defer outfile.Close()
 
+	return logFile, c.readLogFile(logFile, func(data string) error {
+		_, err := io.WriteString(outfile, data)
+		return err
+	})
+}
+
+// readLogFile reads all of a log file from the start, passing each portion of
+// it to write
+func (c *CLI) readLogFile(logFile LogFile, write func(data string) error) error {
 	resp := &rds.DownloadDBLogFilePortionOutput{
 		AdditionalDataPending: aws.Bool(true),
 		Marker:                aws.String("0"),
@@ -457,19 +630,20 @@ func (c *CLI) downloadFile(logFile LogFile) (LogFile, error) {
 		// check for signal triggered exit
 		select {
 		case <-c.Abort:
-			return logFile, fmt.Errorf("signal triggered exit")
+			return ErrAborted
 		default:
 		}
 		params.Marker = resp.Marker // support pagination
+		var err error
 		resp, err = c.RDS.DownloadDBLogFilePortion(params)
 		if err != nil {
-			return logFile, err
+			return err
 		}
-		if _, err := io.WriteString(outfile, aws.StringValue(resp.LogFileData)); err != nil {
-			return logFile, err
+		if err := write(aws.StringValue(resp.LogFileData)); err != nil {
+			return err
 		}
 	}
-	return logFile, nil
+	return nil
 }
 
 // GetLogFiles returns a list of all log files based on the Options.LogFile pattern
@@ -477,7 +651,7 @@ func (c *CLI) GetLogFiles() ([]LogFile, error) {
 	// get a list of all log files.
 	// prune the list so that the log file option is the prefix for all remaining files
 	// return the list of as-yet unread files
-	logFiles, err := c.getListRDSLogFiles()
+	logFiles, err := c.listLogFiles()
 	if err != nil {
 		return nil, err
 	}
@@ -492,6 +666,12 @@ func (c *CLI) GetLogFiles() ([]LogFile, error) {
 	// eg slow.log, slow.log.1, slow.log.2, etc.
 
 	if len(matchingLogFiles) == 0 {
+		if c.inventory != nil {
+			// the inventory only has the log's files, so list them all
+			if logFiles, err = c.getListRDSLogFiles(); err != nil {
+				return nil, err
+			}
+		}
 		errParts := []string{"No log file with the given prefix found. Available log files:"}
 
 		for _, lf := range logFiles {
@@ -520,22 +700,16 @@ func (c *CLI) GetLatestLogFile() (LogFile, error) {
 
 // Gets a list of all available RDS log files for an instance.
 func (c *CLI) getListRDSLogFiles() ([]LogFile, error) {
-	var output *rds.DescribeDBLogFilesOutput
-	var err error
-	var logFiles []LogFile
+	return c.listRDSLogFiles(&rds.DescribeDBLogFilesInput{
+		DBInstanceIdentifier: &c.Options.InstanceIdentifier,
+	})
+}
 
+// listRDSLogFiles pages through the log files DescribeDBLogFiles returns for in
+func (c *CLI) listRDSLogFiles(in *rds.DescribeDBLogFilesInput) ([]LogFile, error) {
+	var logFiles []LogFile
 	for {
-		if output == nil {
-			output, err = c.RDS.DescribeDBLogFiles(&rds.DescribeDBLogFilesInput{
-				DBInstanceIdentifier: &c.Options.InstanceIdentifier,
-			})
-			logFiles = make([]LogFile, 0, len(output.DescribeDBLogFiles))
-		} else {
-			output, err = c.RDS.DescribeDBLogFiles(&rds.DescribeDBLogFilesInput{
-				DBInstanceIdentifier: &c.Options.InstanceIdentifier,
-				Marker:               output.Marker,
-			})
-		}
+		output, err := c.RDS.DescribeDBLogFiles(in)
 		if err != nil {
 			return nil, err
 		}
@@ -552,11 +726,23 @@ func (c *CLI) getListRDSLogFiles() ([]LogFile, error) {
 		if output.Marker == nil {
 			break
 		}
+		next := *in
+		next.Marker = output.Marker
+		in = &next
 	}
 
 	return logFiles, nil
 }
 
+// listLogFiles returns the instance's log files, from the inventory of the
+// log being tailed if there is one
+func (c *CLI) listLogFiles() ([]LogFile, error) {
+	if c.inventory != nil {
+		return c.inventory.files()
+	}
+	return c.getListRDSLogFiles()
+}
+
 // ValidateRDSInstance validates that you have a valid RDS instance to talk to.
 // If an instance isn't specified and your credentials contain more than one RDS
 // instance, asks you to specify which instance you'd like to use.
@@ -606,6 +792,20 @@ func (c *CLI) getListRDSInstances() ([]string, error) {
 	return instances, nil
 }
 
+// describeInstance fetches the RDS metadata for the configured instance
+func (c *CLI) describeInstance() (*rds.DBInstance, error) {
+	out, err := c.RDS.DescribeDBInstances(&rds.DescribeDBInstancesInput{
+		DBInstanceIdentifier: aws.String(c.Options.InstanceIdentifier),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(out.DBInstances) == 0 {
+		return nil, fmt.Errorf("instance %s not found", c.Options.InstanceIdentifier)
+	}
+	return out.DBInstances[0], nil
+}
+
 func (c *CLI) waitFor(d time.Duration) {
 	select {
 	case <-c.Abort:
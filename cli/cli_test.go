@@ -1,10 +1,15 @@
 package cli
 
 import (
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/rds/rdsiface"
+
+	"github.com/honeycombio/rdslogs/publisher"
 )
 
 type FakeNower struct {
@@ -15,14 +20,93 @@ func (f *FakeNower) Now() time.Time {
 	return f.t
 }
 
+// FakeRDS serves canned responses for the RDS API calls rdslogs makes. Calls
+// it doesn't implement panic via the nil embedded interface.
+type FakeRDS struct {
+	rdsiface.RDSAPI
+	instances []*rds.DBInstance
+	// parameters by parameter group name
+	parameters map[string][]*rds.Parameter
+	logFiles   []*rds.DescribeDBLogFilesDetails
+	// portions serves DownloadDBLogFilePortion
+	portions func(*rds.DownloadDBLogFilePortionInput) (*rds.DownloadDBLogFilePortionOutput, error)
+}
+
+func (f *FakeRDS) DescribeDBInstances(in *rds.DescribeDBInstancesInput) (*rds.DescribeDBInstancesOutput, error) {
+	out := &rds.DescribeDBInstancesOutput{}
+	for _, instance := range f.instances {
+		if in == nil || in.DBInstanceIdentifier == nil ||
+			*in.DBInstanceIdentifier == *instance.DBInstanceIdentifier {
+			out.DBInstances = append(out.DBInstances, instance)
+		}
+	}
+	return out, nil
+}
+
+func (f *FakeRDS) DescribeDBParametersPages(in *rds.DescribeDBParametersInput, fn func(*rds.DescribeDBParametersOutput, bool) bool) error {
+	fn(&rds.DescribeDBParametersOutput{Parameters: f.parameters[*in.DBParameterGroupName]}, true)
+	return nil
+}
+
+func (f *FakeRDS) DescribeDBLogFiles(in *rds.DescribeDBLogFilesInput) (*rds.DescribeDBLogFilesOutput, error) {
+	out := &rds.DescribeDBLogFilesOutput{}
+	for _, lf := range f.logFiles {
+		if in.FilenameContains != nil && !strings.Contains(*lf.LogFileName, *in.FilenameContains) {
+			continue
+		}
+		if in.FileLastWritten != nil && *lf.LastWritten < *in.FileLastWritten {
+			continue
+		}
+		out.DescribeDBLogFiles = append(out.DescribeDBLogFiles, lf)
+	}
+	return out, nil
+}
+
+func (f *FakeRDS) DownloadDBLogFilePortion(in *rds.DownloadDBLogFilePortionInput) (*rds.DownloadDBLogFilePortionOutput, error) {
+	return f.portions(in)
+}
+
+// capturePublisher records what's written to it
+type capturePublisher struct {
+	blobs     []string
+	truncated []string
+	gaps      []publisher.Gap
+	// flushes are how many blobs had been written at each Flush
+	flushes []int
+}
+
+func (p *capturePublisher) Write(blob string) {
+	p.blobs = append(p.blobs, blob)
+}
+
+func (p *capturePublisher) Flush() {
+	p.flushes = append(p.flushes, len(p.blobs))
+}
+
+func (p *capturePublisher) Truncated(line, logFile string) {
+	p.truncated = append(p.truncated, line)
+}
+
+func (p *capturePublisher) Gap(gap publisher.Gap) {
+	p.gaps = append(p.gaps, gap)
+}
+
 func TestGetNextMarker(t *testing.T) {
 	// next position is legit
-	c := CLI{}
-	c.fakeNower = &FakeNower{}
-	c.fakeNower.(*FakeNower).t, _ = time.Parse(time.RFC3339, "2010-06-21T15:12:05Z")
+	fake := &FakeRDS{
+		logFiles: []*rds.DescribeDBLogFilesDetails{{
+			LogFileName: aws.String("slowquery/mysql-slowquery.log"),
+			LastWritten: aws.Int64(1000),
+			Size:        aws.Int64(2000),
+		}},
+	}
+	c := CLI{
+		Options: &Options{LogFile: "slowquery/mysql-slowquery.log"},
+		RDS:     fake,
+	}
 	startingPos := "12:1234"
 	streamPos := StreamPos{
-		logFile: LogFile{},
+		logFile: LogFile{LogFileName: "slowquery/mysql-slowquery.log"},
 		marker:  startingPos,
 	}
 	resp := rds.DownloadDBLogFilePortionOutput{}
@@ -45,28 +129,32 @@ func TestGetNextMarker(t *testing.T) {
 	if nextMarker != respPos {
 		t.Errorf("response marker %s expected, got %s", respPos, nextMarker)
 	}
-	// next position 0 and no data, not in :00-:05 time range, expect resp
+	// next position 0 and no data, log not rotated, expect startingPos
 	respPos = "0"
 	resp.Marker = &respPos
 	nextMarker = c.getNextMarker(streamPos, &resp)
 	if resp.Marker == nil {
 		t.Error("unexpected resp marker nil")
 	}
-	if nextMarker != respPos {
-		t.Errorf("response marker %s expected, got %s", respPos, nextMarker)
+	if nextMarker != startingPos {
+		t.Errorf("response marker %s expected, got %s", startingPos, nextMarker)
 	}
-	// next position 0 and no data, in :00-:05 time range, expect startingPos
-	c.fakeNower.(*FakeNower).t, _ = time.Parse(time.RFC3339, "2010-06-21T15:03:05Z")
+	// next position 0 and no data, log rotated, expect resp
+	fake.logFiles = append(fake.logFiles, &rds.DescribeDBLogFilesDetails{
+		LogFileName: aws.String("slowquery/mysql-slowquery.log.12"),
+		LastWritten: aws.Int64(1100),
+		Size:        aws.Int64(1500),
+	})
 	respPos = "0"
 	resp.Marker = &respPos
 	nextMarker = c.getNextMarker(streamPos, &resp)
 	if resp.Marker == nil {
 		t.Error("unexpected resp marker nil")
 	}
-	if nextMarker != startingPos {
-		t.Errorf("response marker %s expected, got %s", startingPos, nextMarker)
+	if nextMarker != respPos {
+		t.Errorf("response marker %s expected, got %s", respPos, nextMarker)
 	}
-	// next position 0 and have data, not in :00-:05 time range, expect start+len
+	// next position 0 and have data, expect start+len
 	respContent := "this is a slow query log entry, really."
 	expectedMarker := "12:1273" // 1234 + 39 (aka len(respContent))
 	resp.LogFileData = &respContent
@@ -79,17 +167,6 @@ func TestGetNextMarker(t *testing.T) {
 	if nextMarker != expectedMarker {
 		t.Errorf("response marker %s expected, got %s", expectedMarker, nextMarker)
 	}
-	// next position 0 and have data, in :00-:05 time range, expect start+len
-	c.fakeNower.(*FakeNower).t, _ = time.Parse(time.RFC3339, "2010-06-21T15:03:05Z")
-	respPos = "0"
-	resp.Marker = &respPos
-	nextMarker = c.getNextMarker(streamPos, &resp)
-	if resp.Marker == nil {
-		t.Error("unexpected resp marker nil")
-	}
-	if nextMarker != expectedMarker {
-		t.Errorf("response marker %s expected, got %s", expectedMarker, nextMarker)
-	}
 }
 
 func TestStreamAdd(t *testing.T) {
@@ -108,3 +185,22 @@ func TestStreamAdd(t *testing.T) {
 			lenToAdd, sumPos, expectedPos)
 	}
 }
+
+func TestRotated(t *testing.T) {
+	file := LogFile{LogFileName: "slowquery/mysql-slowquery.log"}
+	testCases := []struct {
+		prev, next StreamPos
+		expected   bool
+	}{
+		{StreamPos{file, ""}, StreamPos{file, "12:100"}, false},
+		{StreamPos{file, "12:100"}, StreamPos{file, "12:200"}, false},
+		{StreamPos{file, "12:200"}, StreamPos{file, "0"}, true},
+		{StreamPos{file, "12:200"}, StreamPos{file, "13:0"}, true},
+		{StreamPos{file, "12:200"}, StreamPos{LogFile{LogFileName: "other.log"}, ""}, true},
+	}
+	for _, tc := range testCases {
+		if r := rotated(tc.prev, tc.next); r != tc.expected {
+			t.Errorf("%v -> %v: expected rotated %v, got %v", tc.prev, tc.next, tc.expected, r)
+		}
+	}
+}
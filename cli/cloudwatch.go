@@ -0,0 +1,170 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/sirupsen/logrus"
+)
+
+// cloudwatchPollInterval is how long to wait for new events once we've read
+// everything CloudWatch Logs has for us
+const cloudwatchPollInterval = 5 * time.Second
+
+// logGroup returns the CloudWatch Logs log group RDS exports the configured
+// log to
+func (c *CLI) logGroup(src *logSource) string {
+	if c.Options.LogGroup != "" {
+		return c.Options.LogGroup
+	}
+	return fmt.Sprintf("/aws/rds/instance/%s/%s", c.Options.InstanceIdentifier, src.cloudwatchLog)
+}
+
+// StreamCloudWatch polls the instance's CloudWatch Logs log group forever,
+// sending the events to the output like Stream does. CloudWatch Logs isn't
+// subject to the DownloadDBLogFilePortion rate limits, but requires the log
+// to be exported (via the instance's "Log exports" setting).
+func (c *CLI) StreamCloudWatch() error {
+	src, err := c.source()
+	if err != nil {
+		return err
+	}
+	logGroup := c.logGroup(src)
+
+	cp := &Checkpoint{}
+	if c.Options.Tail.CheckpointFile != "" {
+		cp, err = loadCheckpoint(c.Options.Tail.CheckpointFile)
+		if err != nil {
+			return fmt.Errorf("unable to read checkpoint file %s: %s", c.Options.Tail.CheckpointFile, err)
+		}
+	}
+	if cp.LogGroup != logGroup {
+		if cp.LogGroup != "" {
+			logrus.WithFields(logrus.Fields{
+				"checkpointLogGroup": cp.LogGroup,
+				"logGroup":           logGroup,
+			}).Warn("checkpoint is for a different log group, ignoring it")
+		}
+		// start tailing from now, like Stream does
+		cp = &Checkpoint{LogGroup: logGroup, Timestamp: c.now().UnixNano() / int64(time.Millisecond)}
+	}
+
+	closeOutput, err := c.openOutput(src)
+	if err != nil {
+		return err
+	}
+	defer closeOutput()
+
+	logrus.WithFields(logrus.Fields{
+		"logGroup":  logGroup,
+		"startTime": time.Unix(0, cp.Timestamp*int64(time.Millisecond)).UTC(),
+	}).Info("Reading from CloudWatch Logs")
+
+	for {
+		// check for signal triggered exit
+		select {
+		case <-c.Abort:
+			if c.leadershipLost() {
+				c.discardOutput()
+				return ErrAborted
+			}
+			// finish sending what we've read before recording how far we got
+			closeOutput()
+			c.saveCheckpoint(cp)
+			return ErrAborted
+		default:
+		}
+		// when stopped mid-poll, go round to save the checkpoint
+		if err := c.pollCloudWatch(logGroup, cp); err != nil && err != ErrAborted {
+			return err
+		}
+		// that's everything for now; wait for more, and ask again from the
+		// newest event we've seen
+		c.waitFor(cloudwatchPollInterval)
+	}
+}
+
+// pollCloudWatch reads the events in logGroup since the checkpoint, page by
+// page, sending them to the output and moving the checkpoint along
+func (c *CLI) pollCloudWatch(logGroup string, cp *Checkpoint) error {
+	// the pages of a query have to be asked for with the same parameters, so
+	// hold the start time while the checkpoint moves on
+	startTime := cp.Timestamp
+	var nextToken *string
+	for {
+		out, err := c.CloudWatchLogs.FilterLogEvents(&cloudwatchlogs.FilterLogEventsInput{
+			LogGroupName: aws.String(logGroup),
+			StartTime:    aws.Int64(startTime),
+			NextToken:    nextToken,
+		})
+		if err != nil {
+			if strings.HasPrefix(err.Error(), "ThrottlingException") {
+				logrus.Infof("AWS Rate limit hit; sleeping for %d seconds.\n", c.Options.BackoffTimer)
+				c.waitFor(time.Duration(c.Options.BackoffTimer) * time.Second)
+				// waitFor returns straight away once we've been stopped
+				select {
+				case <-c.Abort:
+					return ErrAborted
+				default:
+				}
+				continue
+			}
+			if strings.HasPrefix(err.Error(), "ResourceNotFoundException") {
+				return fmt.Errorf("log group %s not found. Is the log exported to CloudWatch Logs? %s", logGroup, err)
+			}
+			return err
+		}
+
+		if data := cp.add(out.Events); data != "" {
+			c.output.Write(data)
+		}
+		// the output sends what it's written in the background, so this is
+		// at-most-once delivery, as --checkpoint_file's help says
+		c.saveCheckpoint(cp)
+
+		if out.NextToken == nil {
+			return nil
+		}
+		nextToken = out.NextToken
+	}
+}
+
+// add moves the checkpoint past events, and returns the events we hadn't
+// already read as lines of log
+func (cp *Checkpoint) add(events []*cloudwatchlogs.FilteredLogEvent) string {
+	seen := make(map[string]bool, len(cp.EventIDs))
+	for _, id := range cp.EventIDs {
+		seen[id] = true
+	}
+	var data strings.Builder
+	for _, ev := range events {
+		id := aws.StringValue(ev.EventId)
+		if seen[id] {
+			continue
+		}
+		ts := aws.Int64Value(ev.Timestamp)
+		if ts > cp.Timestamp {
+			cp.Timestamp = ts
+			cp.EventIDs = nil
+		}
+		if ts == cp.Timestamp {
+			cp.EventIDs = append(cp.EventIDs, id)
+		}
+		message := aws.StringValue(ev.Message)
+		data.WriteString(message)
+		if !strings.HasSuffix(message, "\n") {
+			data.WriteString("\n")
+		}
+	}
+	return data.String()
+}
+
+func (c *CLI) now() time.Time {
+	if c.fakeNower != nil {
+		return c.fakeNower.Now()
+	}
+	return time.Now()
+}
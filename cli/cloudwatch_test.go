@@ -0,0 +1,161 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+)
+
+type fakeLogEvent struct {
+	EventID   string `json:"eventId"`
+	Timestamp int64  `json:"timestamp"`
+	Message   string `json:"message"`
+}
+
+// newFakeCloudWatchLogs serves FilterLogEvents for a single log group over
+// the CloudWatch Logs JSON protocol, one event per page
+func newFakeCloudWatchLogs(t *testing.T, logGroup string, events []fakeLogEvent) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if target := r.Header.Get("X-Amz-Target"); target != "Logs_20140328.FilterLogEvents" {
+			t.Errorf("unexpected call %s", target)
+		}
+		var in struct {
+			LogGroupName string `json:"logGroupName"`
+			StartTime    int64  `json:"startTime"`
+			NextToken    string `json:"nextToken"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			t.Fatal(err)
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		if in.LogGroupName != logGroup {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"__type":  "ResourceNotFoundException",
+				"message": "The specified log group does not exist.",
+			})
+			return
+		}
+		var matching []fakeLogEvent
+		for _, ev := range events {
+			if ev.Timestamp >= in.StartTime {
+				matching = append(matching, ev)
+			}
+		}
+		page := 0
+		if in.NextToken != "" {
+			json.Unmarshal([]byte(in.NextToken), &page)
+		}
+		out := map[string]interface{}{"events": []fakeLogEvent{}}
+		if page < len(matching) {
+			out["events"] = matching[page : page+1]
+		}
+		if page+1 < len(matching) {
+			out["nextToken"] = strconv.Itoa(page + 1)
+		}
+		json.NewEncoder(w).Encode(out)
+	}))
+}
+
+func newCloudWatchTestCLI(t *testing.T, url string) *CLI {
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(url),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	return &CLI{
+		Options:        &Options{Tail: TailOptions{CheckpointFile: filepath.Join(t.TempDir(), "checkpoint")}},
+		CloudWatchLogs: cloudwatchlogs.New(sess),
+		output:         &capturePublisher{},
+	}
+}
+
+func TestPollCloudWatch(t *testing.T) {
+	logGroup := "/aws/rds/instance/db/slowquery"
+	server := newFakeCloudWatchLogs(t, logGroup, []fakeLogEvent{
+		{"1", 1000, "# Time: 1"},
+		{"2", 2000, "# Time: 2"},
+		{"3", 2000, "# Time: 3\n"},
+	})
+	defer server.Close()
+	c := newCloudWatchTestCLI(t, server.URL)
+
+	cp := &Checkpoint{LogGroup: logGroup, Timestamp: 1000}
+	if err := c.pollCloudWatch(logGroup, cp); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"# Time: 1\n", "# Time: 2\n", "# Time: 3\n"}
+	if blobs := c.output.(*capturePublisher).blobs; !reflect.DeepEqual(blobs, expected) {
+		t.Errorf("expected %q, got %q", expected, blobs)
+	}
+	if cp.Timestamp != 2000 || !reflect.DeepEqual(cp.EventIDs, []string{"2", "3"}) {
+		t.Errorf("unexpected checkpoint %+v", cp)
+	}
+
+	// polling again asks from 2000, but mustn't send events 2 and 3 again
+	if err := c.pollCloudWatch(logGroup, cp); err != nil {
+		t.Fatal(err)
+	}
+	if blobs := c.output.(*capturePublisher).blobs; len(blobs) != 3 {
+		t.Errorf("expected no new events, got %q", blobs[3:])
+	}
+
+	// and a restart picks up from the saved checkpoint
+	saved, err := loadCheckpoint(c.Options.Tail.CheckpointFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(saved, cp) {
+		t.Errorf("expected saved checkpoint %+v, got %+v", cp, saved)
+	}
+}
+
+func TestPollCloudWatchMissingLogGroup(t *testing.T) {
+	server := newFakeCloudWatchLogs(t, "/aws/rds/instance/db/slowquery", nil)
+	defer server.Close()
+	c := newCloudWatchTestCLI(t, server.URL)
+
+	err := c.pollCloudWatch("/aws/rds/instance/other/slowquery", &Checkpoint{})
+	if err == nil {
+		t.Error("expected an error for a missing log group")
+	}
+}
+
+// throttledCloudWatchLogs refuses every FilterLogEvents call as throttled,
+// until it's been called too many times
+type throttledCloudWatchLogs struct {
+	cloudwatchlogsiface.CloudWatchLogsAPI
+	calls int
+}
+
+func (f *throttledCloudWatchLogs) FilterLogEvents(in *cloudwatchlogs.FilterLogEventsInput) (*cloudwatchlogs.FilterLogEventsOutput, error) {
+	f.calls++
+	if f.calls > 100 {
+		return &cloudwatchlogs.FilterLogEventsOutput{}, nil
+	}
+	return nil, errors.New("ThrottlingException: Rate exceeded")
+}
+
+func TestPollCloudWatchStopsWhenAborted(t *testing.T) {
+	fake := &throttledCloudWatchLogs{}
+	abort := make(chan bool)
+	close(abort)
+	c := &CLI{Options: &Options{BackoffTimer: 5}, CloudWatchLogs: fake, Abort: abort}
+	if err := c.pollCloudWatch("/aws/rds/instance/db/postgresql", &Checkpoint{}); err != ErrAborted {
+		t.Errorf("expected to be aborted, got %v", err)
+	}
+	if fake.calls != 1 {
+		t.Errorf("expected to stop after being throttled once, called %d times", fake.calls)
+	}
+}
@@ -0,0 +1,271 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// StreamConfig is the settings for one stream in a structured config file,
+// or the defaults for all of them. Each setting is named for the flag it
+// stands in for, and one left unset falls back to the config's defaults, then
+// to the flag.
+type StreamConfig struct {
+	Identifier           *string           `yaml:"identifier"`
+	Region               *string           `yaml:"region"`
+	RoleARN              *string           `yaml:"role_arn"`
+	ExternalID           *string           `yaml:"external_id"`
+	WebIdentityTokenFile *string           `yaml:"web_identity_token_file"`
+	RoleSessionName      *string           `yaml:"role_session_name"`
+	DBType               *string           `yaml:"dbtype"`
+	LogType              *string           `yaml:"log_type"`
+	LogFile              *string           `yaml:"log_file"`
+	LogLinePrefix        *string           `yaml:"log_line_prefix"`
+	Source               *string           `yaml:"source"`
+	LogGroup             *string           `yaml:"log_group"`
+	Output               *string           `yaml:"output"`
+	WriteKey             *string           `yaml:"writekey"`
+	WriteKeyFile         *string           `yaml:"writekey_file"`
+	Dataset              *string           `yaml:"dataset"`
+	APIHost              *string           `yaml:"api_host"`
+	SampleRate           *int              `yaml:"sample_rate"`
+	ScrubQuery           *bool             `yaml:"scrub_query"`
+	Fields               map[string]string `yaml:"fields"`
+}
+
+// Config is a structured (YAML or JSON) config file, listing the streams to
+// tail with settings of their own, for when one set of flags can't describe
+// them all
+type Config struct {
+	Defaults StreamConfig   `yaml:"defaults"`
+	Streams  []StreamConfig `yaml:"streams"`
+
+	// the streams with their settings worked out
+	streams []shardStream
+}
+
+// IsStructuredConfig returns whether the --config file at path is a
+// structured config, rather than an INI file of flags, going by its extension
+func IsStructuredConfig(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// LoadConfig reads the structured config at path, and works out each
+// stream's options: flags or environment variables given explicitly (named
+// in set) come first, then
+// the stream's settings, then the config's defaults, then the flags' own
+// defaults in base. Every problem found is reported, each with where in the
+// file it is.
+func LoadConfig(path string, base *Options, set map[string]bool) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s: %s", path, strings.NewReplacer(
+			"yaml: ", "",
+			" in type cli.StreamConfig", "",
+			" in type cli.Config", "",
+		).Replace(err.Error()))
+	}
+	// find the line each stream starts on, to point errors at
+	var doc yaml.Node
+	yaml.Unmarshal(data, &doc)
+	lines := streamLines(&doc)
+
+	var problems []string
+	report := func(line int, where, format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("%s:%d: %s: %s", path, line, where, fmt.Sprintf(format, args...)))
+	}
+	if cfg.Defaults.Identifier != nil {
+		report(lines.defaults, "defaults", "identifier can only be set on a stream")
+	}
+	if len(cfg.Streams) == 0 {
+		report(lines.top, "streams", "at least one stream is needed")
+	}
+	if set["stream"] {
+		report(lines.top, "streams", "the config's streams can't be used with --stream")
+	}
+	seen := make(map[string]int)
+	for i, sc := range cfg.Streams {
+		line := lines.top
+		if i < len(lines.streams) {
+			line = lines.streams[i]
+		}
+		where := fmt.Sprintf("streams[%d]", i)
+		if sc.Identifier == nil || *sc.Identifier == "" {
+			report(line, where, "identifier is required")
+			continue
+		}
+		where = fmt.Sprintf("streams[%d] (%s)", i, *sc.Identifier)
+		opts := sc.options(&cfg.Defaults, base, set)
+		if err := opts.ReadWriteKeyFile(); err != nil {
+			report(line, where, "%s", err)
+		}
+		for _, problem := range validateStreamOptions(opts) {
+			report(line, where, "%s", problem)
+		}
+		s := shardStream{identifier: opts.InstanceIdentifier, logType: opts.LogType, opts: opts}
+		if first, ok := seen[s.name()]; ok {
+			report(line, where, "stream %s is already listed at streams[%d]", s.name(), first)
+			continue
+		}
+		seen[s.name()] = i
+		cfg.streams = append(cfg.streams, s)
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid config:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return cfg, nil
+}
+
+// configLines are where the parts of a config start in its file
+type configLines struct {
+	top, defaults int
+	streams       []int
+}
+
+func streamLines(doc *yaml.Node) configLines {
+	lines := configLines{top: 1, defaults: 1}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return lines
+	}
+	root := doc.Content[0]
+	lines.top = root.Line
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		switch root.Content[i].Value {
+		case "defaults":
+			lines.defaults = root.Content[i+1].Line
+		case "streams":
+			for _, item := range root.Content[i+1].Content {
+				lines.streams = append(lines.streams, item.Line)
+			}
+		}
+	}
+	return lines
+}
+
+// options works out a stream's options from the flags and the config
+func (sc *StreamConfig) options(defaults *StreamConfig, base *Options, set map[string]bool) *Options {
+	opts := *base
+	ov := reflect.ValueOf(&opts).Elem()
+	fields := make(map[string]reflect.Value, ov.NumField())
+	for i := 0; i < ov.NumField(); i++ {
+		if name := ov.Type().Field(i).Tag.Get("long"); name != "" {
+			fields[name] = ov.Field(i)
+		}
+	}
+	sv, dv := reflect.ValueOf(sc).Elem(), reflect.ValueOf(defaults).Elem()
+	for i := 0; i < sv.NumField(); i++ {
+		name := sv.Type().Field(i).Tag.Get("yaml")
+		field, ok := fields[name]
+		if !ok || set[name] || sv.Field(i).Kind() != reflect.Ptr {
+			continue
+		}
+		if v := sv.Field(i); !v.IsNil() {
+			field.Set(v.Elem())
+		} else if v := dv.Field(i); !v.IsNil() {
+			field.Set(v.Elem())
+		}
+	}
+	opts.InstanceIdentifier = *sc.Identifier
+
+	// fields add up, with the stream's over the defaults', and the flags'
+	// over both
+	opts.AddFields = make(map[string]string)
+	for _, fields := range []map[string]string{defaults.Fields, sc.Fields, base.AddFields} {
+		for k, v := range fields {
+			opts.AddFields[k] = v
+		}
+	}
+	// the stream is one of the config's, not a --stream
+	opts.Tail.Streams = nil
+	return &opts
+}
+
+// validateStreamOptions checks the settings a stream's options can take
+// from a structured config, and returns what's wrong with them
+func validateStreamOptions(opts *Options) []string {
+	var problems []string
+	if opts.Region == "" {
+		problems = append(problems, "region is required")
+	}
+	switch {
+	case opts.DBType != "" && !contains(dbTypes, opts.DBType):
+		problems = append(problems, unknownDBType(opts.DBType).Error())
+	case opts.DBType != "" && opts.LogType != "":
+		if _, err := lookupLogSource(opts.DBType, opts.LogType); err != nil {
+			problems = append(problems, fmt.Sprintf("log_type %q isn't supported for dbtype %s", opts.LogType, opts.DBType))
+		}
+	case opts.LogType != "":
+		known := false
+		for _, src := range logSources {
+			known = known || src.logType == opts.LogType
+		}
+		if !known {
+			problems = append(problems, fmt.Sprintf("log_type %q not recognized for any dbtype", opts.LogType))
+		}
+	}
+	if err := ValidateRole(opts); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if opts.Source != SourceRDS && opts.Source != SourceCloudWatch {
+		problems = append(problems, fmt.Sprintf("source %q not recognized, use rds or cloudwatch", opts.Source))
+	}
+	switch opts.Output {
+	case "stdout":
+	case "honeycomb":
+		if opts.WriteKey == "" {
+			problems = append(problems, "writekey is required when output is honeycomb")
+		}
+		if opts.Dataset == "" {
+			problems = append(problems, "dataset is required when output is honeycomb")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("output %q not recognized, use stdout or honeycomb", opts.Output))
+	}
+	if opts.SampleRate < 1 {
+		problems = append(problems, fmt.Sprintf("sample_rate must be a positive integer, not %d", opts.SampleRate))
+	}
+	return problems
+}
+
+// ValidateOptions checks options given as flags (or in an INI config) the
+// way each of a structured config's streams is checked, naming every problem
+func ValidateOptions(opts *Options) error {
+	if problems := validateStreamOptions(opts); len(problems) > 0 {
+		return fmt.Errorf("invalid options:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// StreamOptions returns each of the config's streams' options, in order
+func (cfg *Config) StreamOptions() []*Options {
+	var opts []*Options
+	for _, s := range cfg.streams {
+		opts = append(opts, s.opts)
+	}
+	return opts
+}
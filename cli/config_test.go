@@ -0,0 +1,165 @@
+package cli
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, name, config string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, "rdslogs.yaml", `
+defaults:
+  output: honeycomb
+  writekey: abc123
+  dataset: rds
+  fields:
+    env: prod
+    team: data
+streams:
+  - identifier: db-a
+    dbtype: postgresql
+    dataset: postgres
+  - identifier: db-b
+    region: eu-west-1
+    role_arn: arn:aws:iam::210987654321:role/rdslogs
+    external_id: rdslogs-prod
+    dbtype: mysql
+    log_type: audit
+    sample_rate: 10
+    fields:
+      team: payments
+`)
+	base := &Options{Region: "us-east-1", Source: SourceRDS, Output: "stdout", SampleRate: 1, Tail: TailOptions{LeaderLease: 15}}
+	cfg, err := LoadConfig(path, base, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streams := cfg.StreamOptions()
+	if len(streams) != 2 {
+		t.Fatalf("expected 2 streams, got %d", len(streams))
+	}
+	a, b := streams[0], streams[1]
+	if a.InstanceIdentifier != "db-a" || a.Region != "us-east-1" || a.DBType != DBTypePostgreSQL || a.Dataset != "postgres" || a.SampleRate != 1 {
+		t.Errorf("unexpected options for db-a %+v", a)
+	}
+	if a.Output != "honeycomb" || a.WriteKey != "abc123" || a.Tail.LeaderLease != 15 {
+		t.Errorf("expected db-a to take the defaults and flags, got %+v", a)
+	}
+	if b.Region != "eu-west-1" || b.LogType != LogTypeAudit || b.Dataset != "rds" || b.SampleRate != 10 {
+		t.Errorf("unexpected options for db-b %+v", b)
+	}
+	if a.RoleARN != "" || b.RoleARN != "arn:aws:iam::210987654321:role/rdslogs" || b.ExternalID != "rdslogs-prod" {
+		t.Errorf("expected only db-b to assume a role, got %q and %q", a.RoleARN, b.RoleARN)
+	}
+	if expected := map[string]string{"env": "prod", "team": "payments"}; !reflect.DeepEqual(b.AddFields, expected) {
+		t.Errorf("expected fields %v, got %v", expected, b.AddFields)
+	}
+	if names := []string{cfg.streams[0].name(), cfg.streams[1].name()}; !reflect.DeepEqual(names, []string{"db-a", "db-b-audit"}) {
+		t.Errorf("unexpected stream names %v", names)
+	}
+
+	// flags given explicitly win over the config
+	base.Dataset = "everything"
+	cfg, err = LoadConfig(path, base, map[string]bool{"dataset": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opts := range cfg.StreamOptions() {
+		if opts.Dataset != "everything" {
+			t.Errorf("expected --dataset to win, got %s", opts.Dataset)
+		}
+	}
+}
+
+func TestLoadConfigJSON(t *testing.T) {
+	path := writeConfig(t, "rdslogs.json", `{"streams": [{"identifier": "db-a", "log_type": "error"}]}`)
+	cfg, err := LoadConfig(path, &Options{Region: "us-east-1", Source: SourceRDS, Output: "stdout", SampleRate: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts := cfg.StreamOptions(); len(opts) != 1 || opts[0].LogType != LogTypeError {
+		t.Errorf("unexpected streams %+v", opts)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	base := &Options{Region: "us-east-1", Source: SourceRDS, Output: "stdout", SampleRate: 1}
+	testCases := []struct {
+		config   string
+		expected []string
+	}{
+		{
+			config:   "streams:\n  - identifer: db-a\n",
+			expected: []string{"line 2: field identifer not found"},
+		},
+		{
+			config:   "defaults:\n  identifier: db-a\n",
+			expected: []string{":2: defaults: identifier can only be set on a stream", ":1: streams: at least one stream is needed"},
+		},
+		{
+			config: `defaults:
+  output: honeycomb
+streams:
+  - identifier: db-a
+    dbtype: mongodb
+    writekey: abc123
+    dataset: rds
+  - dbtype: mysql
+  - identifier: db-c
+    dbtype: oracle
+    log_type: query
+    output: stdout
+    sample_rate: 0
+  - identifier: db-a
+    output: stdout
+  - identifier: db-e
+    role_arn: rdslogs
+    output: stdout
+`,
+			expected: []string{
+				`:4: streams[0] (db-a): dbtype "mongodb" not recognized`,
+				":8: streams[1]: identifier is required",
+				`:9: streams[2] (db-c): log_type "query" isn't supported for dbtype oracle`,
+				":9: streams[2] (db-c): sample_rate must be a positive integer, not 0",
+				":14: streams[3] (db-a): stream db-a is already listed at streams[0]",
+				`:16: streams[4] (db-e): role_arn "rdslogs" isn't an IAM role ARN`,
+			},
+		},
+	}
+	for i, tc := range testCases {
+		_, err := LoadConfig(writeConfig(t, "rdslogs.yaml", tc.config), base, nil)
+		if err == nil {
+			t.Errorf("case %d: expected an error", i)
+			continue
+		}
+		for _, expected := range tc.expected {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("case %d: expected %q in the error, got %s", i, expected, err)
+			}
+		}
+	}
+}
+
+func TestIsStructuredConfig(t *testing.T) {
+	for path, expected := range map[string]bool{
+		"rdslogs.yaml": true,
+		"rdslogs.YML":  true,
+		"rdslogs.json": true,
+		"rdslogs.conf": false,
+		"rdslogs":      false,
+	} {
+		if IsStructuredConfig(path) != expected {
+			t.Errorf("%s: expected %v", path, expected)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+)
+
+// credentialsExpiryWindow is how long before an assumed role's credentials
+// expire that they're refreshed, so a long-running tail doesn't sign a call
+// with credentials about to run out
+const credentialsExpiryWindow = time.Minute
+
+// ValidateRole checks the settings for assuming --role_arn
+func ValidateRole(opts *Options) error {
+	if opts.RoleARN == "" {
+		switch {
+		case opts.ExternalID != "":
+			return fmt.Errorf("external_id needs a role_arn to assume")
+		case opts.WebIdentityTokenFile != "":
+			return fmt.Errorf("web_identity_token_file needs a role_arn to assume")
+		}
+		return nil
+	}
+	if !strings.HasPrefix(opts.RoleARN, "arn:") || !strings.Contains(opts.RoleARN, ":role/") {
+		return fmt.Errorf("role_arn %q isn't an IAM role ARN, like arn:aws:iam::123456789012:role/rdslogs", opts.RoleARN)
+	}
+	if opts.ExternalID != "" && opts.WebIdentityTokenFile != "" {
+		return fmt.Errorf("external_id can't be used with web_identity_token_file, as assuming a role with a web identity doesn't take one")
+	}
+	return nil
+}
+
+// RoleKey identifies the credentials for opts' role: everything that goes in
+// to assuming it, including the region whose STS endpoint it's assumed with.
+// Options with the same key can share credentials.
+func RoleKey(opts *Options) string {
+	return strings.Join([]string{opts.RoleARN, opts.ExternalID, opts.WebIdentityTokenFile, opts.RoleSessionName, opts.Region}, "\x00")
+}
+
+// RoleCredentials returns credentials for opts' --role_arn, or nil if there's
+// no role to assume. The role is assumed with the token in
+// --web_identity_token_file if it's given, or else with sess's own
+// credentials. The credentials are kept until shortly before they expire, and
+// then the role is assumed again.
+func RoleCredentials(sess client.ConfigProvider, opts *Options) *credentials.Credentials {
+	if opts.RoleARN == "" {
+		return nil
+	}
+	svc := sts.New(sess, &aws.Config{Region: aws.String(opts.Region)})
+	return credentials.NewCredentials(roleProvider(svc, opts))
+}
+
+func roleProvider(svc stsiface.STSAPI, opts *Options) credentials.Provider {
+	if opts.WebIdentityTokenFile != "" {
+		// the token file is read again each time, as it's rotated
+		return stscreds.NewWebIdentityRoleProviderWithOptions(svc, opts.RoleARN, opts.RoleSessionName,
+			stscreds.FetchTokenPath(opts.WebIdentityTokenFile),
+			func(p *stscreds.WebIdentityRoleProvider) {
+				p.ExpiryWindow = credentialsExpiryWindow
+			})
+	}
+	p := &stscreds.AssumeRoleProvider{
+		Client:          svc,
+		RoleARN:         opts.RoleARN,
+		RoleSessionName: opts.RoleSessionName,
+		Duration:        stscreds.DefaultDuration,
+		ExpiryWindow:    credentialsExpiryWindow,
+	}
+	if opts.ExternalID != "" {
+		p.ExternalID = aws.String(opts.ExternalID)
+	}
+	return p
+}
+
+// ExplainCredentialsError adds what to do about it to err, if AWS refused
+// our credentials or a role couldn't be assumed. Other errors are returned
+// as they are.
+func ExplainCredentialsError(err error) error {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return err
+	}
+	var advice string
+	switch aerr.Code() {
+	case "ExpiredToken", "ExpiredTokenException", "RequestExpired":
+		advice = `The AWS credentials have expired. Credentials for a role rdslogs assumes
+(--role_arn, or AWS_ROLE_ARN with a web identity token) are renewed as they
+run out, but others, such as an AWS_SESSION_TOKEN or a session in
+~/.aws/credentials, have to be renewed before restarting rdslogs. For a
+long-running rdslogs, use an IAM role for the host or pod, or --role_arn.`
+	case "InvalidClientTokenId", "UnrecognizedClientException", "SignatureDoesNotMatch", "IncompleteSignature":
+		advice = `AWS doesn't recognize the credentials. Check that AWS_ACCESS_KEY_ID and
+AWS_SECRET_ACCESS_KEY, or the profile in ~/.aws/credentials, belong to a user
+or role that still exists and hasn't had its access keys rotated.`
+	case "AccessDenied":
+		if !strings.Contains(aerr.Message(), "sts:AssumeRole") {
+			return err
+		}
+		advice = `Unable to assume the role. Check that the role's trust policy lets the user
+or role rdslogs runs as assume it (with sts:AssumeRole in its IAM policy too),
+and that --external_id matches the sts:ExternalId the trust policy requires.`
+	case stscreds.ErrCodeWebIdentity:
+		advice = `Unable to assume the role with the web identity token. Check that the token
+file exists (on EKS, that the pod's service account is annotated with
+eks.amazonaws.com/role-arn), and that the role's trust policy allows
+sts:AssumeRoleWithWebIdentity from the cluster's OIDC provider for that
+service account.`
+	default:
+		return err
+	}
+	return fmt.Errorf("%s\n\n%s", err, advice)
+}
@@ -0,0 +1,138 @@
+package cli
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+)
+
+type FakeSTS struct {
+	stsiface.STSAPI
+	inputs []*sts.AssumeRoleInput
+	// lifetime is how long the credentials handed out last
+	lifetime time.Duration
+}
+
+func (f *FakeSTS) AssumeRoleWithContext(ctx aws.Context, in *sts.AssumeRoleInput, opts ...request.Option) (*sts.AssumeRoleOutput, error) {
+	f.inputs = append(f.inputs, in)
+	return &sts.AssumeRoleOutput{Credentials: &sts.Credentials{
+		AccessKeyId:     aws.String("AKIA"),
+		SecretAccessKey: aws.String("secret"),
+		SessionToken:    aws.String("token"),
+		Expiration:      aws.Time(time.Now().Add(f.lifetime)),
+	}}, nil
+}
+
+func TestRoleCredentials(t *testing.T) {
+	opts := &Options{
+		RoleARN:         "arn:aws:iam::123456789012:role/rdslogs",
+		ExternalID:      "rdslogs-prod",
+		RoleSessionName: "rdslogs",
+	}
+	svc := &FakeSTS{lifetime: time.Hour}
+	creds := credentials.NewCredentials(roleProvider(svc, opts))
+	for i := 0; i < 3; i++ {
+		if _, err := creds.Get(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(svc.inputs) != 1 {
+		t.Fatalf("expected the credentials to be kept, but the role was assumed %d times", len(svc.inputs))
+	}
+	in := svc.inputs[0]
+	if aws.StringValue(in.RoleArn) != opts.RoleARN || aws.StringValue(in.ExternalId) != "rdslogs-prod" || aws.StringValue(in.RoleSessionName) != "rdslogs" {
+		t.Errorf("unexpected AssumeRole input %+v", in)
+	}
+
+	// credentials within a minute of expiring are renewed
+	svc = &FakeSTS{lifetime: 30 * time.Second}
+	creds = credentials.NewCredentials(roleProvider(svc, opts))
+	creds.Get()
+	creds.Get()
+	if len(svc.inputs) != 2 {
+		t.Errorf("expected the role to be assumed again, but it was assumed %d times", len(svc.inputs))
+	}
+}
+
+func TestValidateRole(t *testing.T) {
+	testCases := []struct {
+		opts     Options
+		expected string
+	}{
+		{Options{}, ""},
+		{Options{RoleARN: "arn:aws:iam::123456789012:role/rdslogs", ExternalID: "x"}, ""},
+		{Options{RoleARN: "arn:aws:iam::123456789012:role/rdslogs", WebIdentityTokenFile: "/var/run/token"}, ""},
+		{Options{RoleARN: "rdslogs"}, "isn't an IAM role ARN"},
+		{Options{RoleARN: "arn:aws:iam::123456789012:user/rdslogs"}, "isn't an IAM role ARN"},
+		{Options{ExternalID: "x"}, "external_id needs a role_arn"},
+		{Options{WebIdentityTokenFile: "/var/run/token"}, "web_identity_token_file needs a role_arn"},
+		{Options{RoleARN: "arn:aws:iam::123456789012:role/rdslogs", ExternalID: "x", WebIdentityTokenFile: "/var/run/token"}, "can't be used with web_identity_token_file"},
+	}
+	for i, tc := range testCases {
+		err := ValidateRole(&tc.opts)
+		if tc.expected == "" && err != nil {
+			t.Errorf("case %d: unexpected error %s", i, err)
+		}
+		if tc.expected != "" && (err == nil || !strings.Contains(err.Error(), tc.expected)) {
+			t.Errorf("case %d: expected an error containing %q, got %v", i, tc.expected, err)
+		}
+	}
+}
+
+func TestExplainCredentialsError(t *testing.T) {
+	testCases := []struct {
+		err      error
+		expected string
+	}{
+		{awserr.New("ExpiredTokenException", "The security token included in the request is expired", nil), "credentials have expired"},
+		{awserr.New("InvalidClientTokenId", "The security token included in the request is invalid.", nil), "doesn't recognize the credentials"},
+		{awserr.New("AccessDenied", "User: arn:aws:iam::123456789012:user/rdslogs is not authorized to perform: sts:AssumeRole", nil), "Unable to assume the role"},
+		{awserr.New("WebIdentityErr", "failed fetching WebIdentity token: ", nil), "web identity token"},
+	}
+	for _, tc := range testCases {
+		err := ExplainCredentialsError(tc.err)
+		if !strings.HasPrefix(err.Error(), tc.err.Error()) || !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("expected %q explained with %q, got %s", tc.err, tc.expected, err)
+		}
+	}
+	// other errors are left alone
+	for _, err := range []error{
+		awserr.New("AccessDenied", "User is not authorized to perform: rds:DescribeDBLogFiles", nil),
+		awserr.New("DBInstanceNotFound", "DBInstance db-a not found", nil),
+		errors.New("something else"),
+	} {
+		if explained := ExplainCredentialsError(err); explained != err {
+			t.Errorf("expected %q as it was, got %s", err, explained)
+		}
+	}
+}
+
+func TestRoleKey(t *testing.T) {
+	base := Options{Region: "us-east-1", RoleARN: "arn:aws:iam::123456789012:role/rdslogs", RoleSessionName: "rdslogs"}
+	same := base
+	if RoleKey(&base) != RoleKey(&same) {
+		t.Error("expected the same role to share credentials")
+	}
+	// anything that changes how the role is assumed needs credentials of its own
+	for _, change := range []func(*Options){
+		func(o *Options) { o.RoleARN = "arn:aws:iam::210987654321:role/rdslogs" },
+		func(o *Options) { o.ExternalID = "rdslogs-prod" },
+		func(o *Options) { o.WebIdentityTokenFile = "/var/run/token" },
+		func(o *Options) { o.RoleSessionName = "orders" },
+		func(o *Options) { o.Region = "eu-west-1" },
+	} {
+		other := base
+		change(&other)
+		if RoleKey(&base) == RoleKey(&other) {
+			t.Errorf("expected %+v to need its own credentials", other)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// detectRotation checks the log files' metadata from DescribeDBLogFiles for
+// signs that the log we're reading at sPos has been rotated since we last
+// looked:
+//   - the file we're reading is gone (renamed away, and not yet recreated)
+//   - it's shrunk below the offset we've read to
+//   - another of the log's files has appeared, or been written to. Rotation
+//     renames the active file (to <name>.<hour>, or <name>.1 shifting the
+//     rest along) or starts a new dated one; otherwise only the active file
+//     changes.
+//
+// This works the same whichever way the log is rotated, and unlike going by
+// the time of day, doesn't depend on when RDS rotates or on our clock. The
+// changes come from the log's inventory, so we only list recently written
+// files to find them.
+func (c *CLI) detectRotation(sPos StreamPos) (bool, error) {
+	inv := c.logInventory()
+	changes, err := inv.changes()
+	if err != nil {
+		return false, err
+	}
+
+	current, ok := inv.snapshot[sPos.logFile.LogFileName]
+	if !ok {
+		logrus.WithField("file", sPos.logFile.LogFileName).Info("Log file is gone, it's been rotated")
+		return true, nil
+	}
+	if _, offset, ok := splitMarker(sPos.marker); ok && current.Size < offset {
+		logrus.WithFields(logrus.Fields{
+			"file":   sPos.logFile.LogFileName,
+			"offset": offset,
+			"size":   current.Size,
+		}).Info("Log file is smaller than we've read, it's been rotated")
+		return true, nil
+	}
+	for _, change := range changes {
+		if change.file.LogFileName == sPos.logFile.LogFileName {
+			if change.kind == logFileRotated {
+				logrus.WithField("file", sPos.logFile.LogFileName).Info("Log file has been replaced, it's been rotated")
+				return true, nil
+			}
+			continue
+		}
+		// old files expiring doesn't mean anything's been rotated
+		if change.kind != logFileDeleted {
+			logrus.WithFields(logrus.Fields{
+				"file":        sPos.logFile.LogFileName,
+				"rotatedFile": change.file.LogFileName,
+			}).Info("Log file has been rotated")
+			return true, nil
+		}
+	}
+	return false, nil
+}
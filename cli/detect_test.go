@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rds"
+)
+
+func TestDetectRotation(t *testing.T) {
+	logFile := func(name string, lastWritten, size int64) *rds.DescribeDBLogFilesDetails {
+		return &rds.DescribeDBLogFilesDetails{
+			LogFileName: aws.String(name),
+			LastWritten: aws.Int64(lastWritten),
+			Size:        aws.Int64(size),
+		}
+	}
+	sPos := StreamPos{logFile: LogFile{LogFileName: "audit/server_audit.log"}, marker: "1:500"}
+	testCases := []struct {
+		desc     string
+		before   []*rds.DescribeDBLogFilesDetails
+		after    []*rds.DescribeDBLogFilesDetails
+		expected bool
+	}{
+		{
+			desc:     "still being written",
+			before:   []*rds.DescribeDBLogFilesDetails{logFile("audit/server_audit.log", 1000, 600), logFile("audit/server_audit.log.1", 500, 900)},
+			after:    []*rds.DescribeDBLogFilesDetails{logFile("audit/server_audit.log", 2000, 700), logFile("audit/server_audit.log.1", 500, 900)},
+			expected: false,
+		},
+		{
+			desc:     "shrunk",
+			before:   []*rds.DescribeDBLogFilesDetails{logFile("audit/server_audit.log", 1000, 600)},
+			after:    []*rds.DescribeDBLogFilesDetails{logFile("audit/server_audit.log", 2000, 100)},
+			expected: true,
+		},
+		{
+			desc:     "gone",
+			before:   []*rds.DescribeDBLogFilesDetails{logFile("audit/server_audit.log", 1000, 600)},
+			after:    []*rds.DescribeDBLogFilesDetails{logFile("audit/server_audit.log.1", 1000, 600)},
+			expected: true,
+		},
+		{
+			// rotated, and the new file's already grown past our offset
+			desc:     "renamed",
+			before:   []*rds.DescribeDBLogFilesDetails{logFile("audit/server_audit.log", 1000, 600), logFile("audit/server_audit.log.1", 500, 900)},
+			after:    []*rds.DescribeDBLogFilesDetails{logFile("audit/server_audit.log", 2000, 800), logFile("audit/server_audit.log.1", 1500, 650)},
+			expected: true,
+		},
+	}
+	for _, tc := range testCases {
+		fake := &FakeRDS{logFiles: tc.before}
+		c := &CLI{Options: &Options{LogFile: "audit/server_audit.log"}, RDS: fake}
+		if rotated, err := c.detectRotation(sPos); err != nil || rotated {
+			t.Errorf("%s: expected the first look not to find a rotation, got %v, %v", tc.desc, rotated, err)
+		}
+		fake.logFiles = tc.after
+		rotated, err := c.detectRotation(sPos)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rotated != tc.expected {
+			t.Errorf("%s: expected rotated %v, got %v", tc.desc, tc.expected, rotated)
+		}
+	}
+}
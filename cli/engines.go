@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/sirupsen/logrus"
+)
+
+// dbTypeForEngine maps an RDS engine name to the db type whose logs it
+// writes, or "" if we don't know the engine
+func dbTypeForEngine(engine string) string {
+	switch {
+	case engine == "postgres", engine == "aurora-postgresql":
+		return DBTypePostgreSQL
+	case engine == "mysql", engine == "aurora", engine == "aurora-mysql":
+		return DBTypeMySQL
+	case engine == "mariadb":
+		return DBTypeMariaDB
+	case strings.HasPrefix(engine, "oracle-"), strings.HasPrefix(engine, "custom-oracle-"):
+		return DBTypeOracle
+	case strings.HasPrefix(engine, "sqlserver-"), strings.HasPrefix(engine, "custom-sqlserver-"):
+		return DBTypeSQLServer
+	}
+	return ""
+}
+
+// defaultLogType is the log type to read when --log_type isn't given
+func defaultLogType(dbType string) string {
+	switch dbType {
+	case DBTypeOracle:
+		return LogTypeAlert
+	case DBTypeSQLServer:
+		return LogTypeError
+	}
+	return LogTypeQuery
+}
+
+// ResolveEngineDefaults reads the instance's engine from DescribeDBInstances
+// and fills in the db type, log type and log file that weren't set
+// explicitly. It warns when --dbtype doesn't match the engine, but trusts the
+// flag.
+func (c *CLI) ResolveEngineDefaults() error {
+	instance, err := c.describeInstance()
+	if err != nil {
+		return err
+	}
+	engine := aws.StringValue(instance.Engine)
+	engineVersion := aws.StringValue(instance.EngineVersion)
+	detected := dbTypeForEngine(engine)
+	if c.Options.DBType == "" {
+		if detected == "" {
+			return fmt.Errorf("unable to detect the dbtype of %s (engine %s), use --dbtype",
+				c.Options.InstanceIdentifier, engine)
+		}
+		c.Options.DBType = detected
+	} else if detected != "" && detected != c.Options.DBType {
+		logrus.WithFields(logrus.Fields{
+			"dbtype":        c.Options.DBType,
+			"engine":        engine,
+			"engineVersion": engineVersion,
+		}).Warn("--dbtype doesn't match the instance's engine, log files and parsing may not work")
+	}
+	if c.Options.LogType == "" {
+		c.Options.LogType = defaultLogType(c.Options.DBType)
+	}
+
+	src, err := c.source()
+	if err != nil {
+		return err
+	}
+	if c.Options.LogFile == "" {
+		c.Options.LogFile = src.defaultFile(instance)
+	}
+	logrus.WithFields(logrus.Fields{
+		"engine":        engine,
+		"engineVersion": engineVersion,
+		"dbType":        c.Options.DBType,
+		"logType":       c.Options.LogType,
+		"logFile":       c.Options.LogFile,
+	}).Info("Using log file for engine")
+	return nil
+}
@@ -0,0 +1,105 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rds"
+)
+
+func TestResolveEngineDefaults(t *testing.T) {
+	fake := &FakeRDS{
+		instances: []*rds.DBInstance{{
+			DBInstanceIdentifier: aws.String("ora"),
+			Engine:               aws.String("oracle-ee"),
+			DBName:               aws.String("prod"),
+		}, {
+			DBInstanceIdentifier: aws.String("ora-default"),
+			Engine:               aws.String("oracle-se2"),
+		}},
+	}
+	testCases := []struct {
+		identifier string
+		logFile    string
+		expected   string
+	}{
+		{"ora", "", "trace/alert_PROD.log"},
+		{"ora-default", "", "trace/alert_ORCL.log"},
+		{"ora", "trace/alert_OTHER.log", "trace/alert_OTHER.log"},
+	}
+	for _, tc := range testCases {
+		c := CLI{
+			Options: &Options{
+				InstanceIdentifier: tc.identifier,
+				DBType:             DBTypeOracle,
+				LogType:            LogTypeAlert,
+				LogFile:            tc.logFile,
+			},
+			RDS: fake,
+		}
+		if err := c.ResolveEngineDefaults(); err != nil {
+			t.Fatalf("instance %s: unexpected error %s", tc.identifier, err)
+		}
+		if c.Options.LogFile != tc.expected {
+			t.Errorf("instance %s: expected log file %q, got %q", tc.identifier, tc.expected, c.Options.LogFile)
+		}
+	}
+}
+
+func TestResolveEngineDefaultsDetectsEngine(t *testing.T) {
+	fake := &FakeRDS{
+		instances: []*rds.DBInstance{{
+			DBInstanceIdentifier: aws.String("pg"),
+			Engine:               aws.String("aurora-postgresql"),
+			EngineVersion:        aws.String("14.6"),
+		}, {
+			DBInstanceIdentifier: aws.String("mssql"),
+			Engine:               aws.String("sqlserver-se"),
+		}, {
+			DBInstanceIdentifier: aws.String("db2"),
+			Engine:               aws.String("db2-se"),
+		}},
+	}
+	testCases := []struct {
+		identifier      string
+		dbType          string
+		logType         string
+		expectedDBType  string
+		expectedLogFile string
+		expectErr       bool
+	}{
+		{"pg", "", "", DBTypePostgreSQL, "error/postgresql.log", false},
+		{"mssql", "", "", DBTypeSQLServer, "log/ERROR", false},
+		{"mssql", "", LogTypeAgent, DBTypeSQLServer, "log/SQLAGENT.OUT", false},
+		// an explicit flag wins over the engine, with a warning
+		{"pg", DBTypeMySQL, "", DBTypeMySQL, "slowquery/mysql-slowquery.log", false},
+		{"pg", "", LogTypeGeneral, DBTypePostgreSQL, "", true},
+		{"db2", "", "", "", "", true},
+	}
+	for _, tc := range testCases {
+		c := CLI{
+			Options: &Options{
+				InstanceIdentifier: tc.identifier,
+				DBType:             tc.dbType,
+				LogType:            tc.logType,
+			},
+			RDS: fake,
+		}
+		err := c.ResolveEngineDefaults()
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("instance %s: expected an error", tc.identifier)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("instance %s: unexpected error %s", tc.identifier, err)
+		}
+		if c.Options.DBType != tc.expectedDBType {
+			t.Errorf("instance %s: expected dbtype %q, got %q", tc.identifier, tc.expectedDBType, c.Options.DBType)
+		}
+		if c.Options.LogFile != tc.expectedLogFile {
+			t.Errorf("instance %s: expected log file %q, got %q", tc.identifier, tc.expectedLogFile, c.Options.LogFile)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package cli
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rds"
+)
+
+const (
+	// fullListInterval is how often the inventory lists all of the log's
+	// files, rather than only the ones written recently. Only a full listing
+	// shows which files have been deleted.
+	fullListInterval = 5 * time.Minute
+	// listSlack is how far before the newest write we've seen to ask for
+	// files written since, to allow for RDS being slow to report writes
+	listSlack = time.Minute
+)
+
+// logFileChangeKind is what happened to a log file between two listings
+type logFileChangeKind string
+
+const (
+	logFileAdded   logFileChangeKind = "added"
+	logFileGrew    logFileChangeKind = "grew"
+	logFileRotated logFileChangeKind = "rotated"
+	logFileDeleted logFileChangeKind = "deleted"
+)
+
+// logFileChange is a change to one of the log's files
+type logFileChange struct {
+	kind logFileChangeKind
+	file LogFile
+}
+
+// logInventory keeps a cached list of the files of the log being tailed, so
+// we don't page through every log file on the instance each time we look for
+// rotation or a newer file. Between occasional full listings, it only asks
+// RDS for files written since the newest write it's seen, and works out what
+// changed from those.
+type logInventory struct {
+	c *CLI
+	// snapshot of the log's files by name
+	snapshot map[string]LogFile
+	// when we last listed files, and last listed all of them
+	listed, fullyListed time.Time
+	// the newest LastWritten seen, in msec since the epoch
+	newest int64
+	// changes seen since the last call to changes
+	pending []logFileChange
+}
+
+func newLogInventory(c *CLI) *logInventory {
+	return &logInventory{c: c}
+}
+
+// logInventory returns the inventory of the log being tailed, starting one if
+// need be
+func (c *CLI) logInventory() *logInventory {
+	if c.inventory == nil {
+		c.inventory = newLogInventory(c)
+	}
+	return c.inventory
+}
+
+// files returns the log's files, listing them again if the list is older
+// than --log_list_interval
+func (inv *logInventory) files() ([]LogFile, error) {
+	if err := inv.refreshIfStale(); err != nil {
+		return nil, err
+	}
+	logFiles := make([]LogFile, 0, len(inv.snapshot))
+	for _, lf := range inv.snapshot {
+		logFiles = append(logFiles, lf)
+	}
+	return logFiles, nil
+}
+
+// changes lists the log's files again if the list is older than
+// --log_list_interval, and returns what's changed since it was last called.
+// The first listing only records the files as they are.
+func (inv *logInventory) changes() ([]logFileChange, error) {
+	if err := inv.refreshIfStale(); err != nil {
+		return nil, err
+	}
+	changes := inv.pending
+	inv.pending = nil
+	return changes, nil
+}
+
+// refreshIfStale lists the log's files if they haven't been listed in the
+// last --log_list_interval
+func (inv *logInventory) refreshIfStale() error {
+	if inv.snapshot != nil && inv.c.now().Sub(inv.listed) < inv.c.Options.LogListInterval {
+		return nil
+	}
+	return inv.refresh()
+}
+
+// refresh lists the log's files, recording any changes
+func (inv *logInventory) refresh() error {
+	now := inv.c.now()
+	in := &rds.DescribeDBLogFilesInput{
+		DBInstanceIdentifier: aws.String(inv.c.Options.InstanceIdentifier),
+		FilenameContains:     aws.String(inv.c.logFileFilter()),
+	}
+	full := inv.snapshot == nil || now.Sub(inv.fullyListed) >= fullListInterval
+	if !full {
+		in.FileLastWritten = aws.Int64(inv.newest - int64(listSlack/time.Millisecond))
+	}
+	logFiles, err := inv.c.listRDSLogFiles(in)
+	if err != nil {
+		return err
+	}
+
+	first := inv.snapshot == nil
+	rotation := false
+	if first {
+		inv.snapshot = make(map[string]LogFile, len(logFiles))
+	}
+	listed := make(map[string]bool, len(logFiles))
+	for _, lf := range logFiles {
+		listed[lf.LogFileName] = true
+		if lf.LastWritten > inv.newest {
+			inv.newest = lf.LastWritten
+		}
+		before, ok := inv.snapshot[lf.LogFileName]
+		inv.snapshot[lf.LogFileName] = lf
+		if first {
+			continue
+		}
+		switch {
+		case !ok:
+			rotation = true
+			inv.pending = append(inv.pending, logFileChange{kind: logFileAdded, file: lf})
+		case lf.Size < before.Size:
+			// rotation replaced it with a new, smaller file
+			rotation = true
+			inv.pending = append(inv.pending, logFileChange{kind: logFileRotated, file: lf})
+		case lf.Size > before.Size || lf.LastWritten > before.LastWritten:
+			inv.pending = append(inv.pending, logFileChange{kind: logFileGrew, file: lf})
+		}
+	}
+	if full {
+		for name, lf := range inv.snapshot {
+			if !listed[name] {
+				delete(inv.snapshot, name)
+				inv.pending = append(inv.pending, logFileChange{kind: logFileDeleted, file: lf})
+			}
+		}
+		inv.fullyListed = now
+	}
+	inv.listed = now
+	if rotation && !full {
+		// the rotation may have removed the file being tailed, which only a
+		// full listing shows, so don't wait for the next one
+		inv.fullyListed = time.Time{}
+		return inv.refresh()
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package cli
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rds"
+)
+
+// listingRDS records the DescribeDBLogFiles calls made to a FakeRDS
+type listingRDS struct {
+	*FakeRDS
+	listings []*rds.DescribeDBLogFilesInput
+}
+
+func (l *listingRDS) DescribeDBLogFiles(in *rds.DescribeDBLogFilesInput) (*rds.DescribeDBLogFilesOutput, error) {
+	l.listings = append(l.listings, in)
+	return l.FakeRDS.DescribeDBLogFiles(in)
+}
+
+func TestLogInventory(t *testing.T) {
+	logFile := func(name string, lastWritten, size int64) *rds.DescribeDBLogFilesDetails {
+		return &rds.DescribeDBLogFilesDetails{
+			LogFileName: aws.String(name),
+			LastWritten: aws.Int64(lastWritten),
+			Size:        aws.Int64(size),
+		}
+	}
+	// LastWritten in msec, a few hours after the epoch so the slack doesn't
+	// go negative
+	hour := int64(time.Hour / time.Millisecond)
+	fake := &listingRDS{FakeRDS: &FakeRDS{logFiles: []*rds.DescribeDBLogFilesDetails{
+		logFile("error/postgresql.log.2022-05-17-09", 9*hour, 1000),
+		logFile("error/postgresql.log.2022-05-17-10", 10*hour, 500),
+		logFile("error/postgres-other.log", 10*hour, 500),
+	}}}
+	nower := &FakeNower{t: time.Unix(0, 0)}
+	c := &CLI{
+		Options:   &Options{LogFile: "error/postgresql.log", LogListInterval: 10 * time.Second},
+		RDS:       fake,
+		fakeNower: nower,
+	}
+	inv := c.logInventory()
+
+	changes, err := inv.changes()
+	if err != nil || len(changes) != 0 {
+		t.Fatalf("expected the first listing to only record the files, got %v %v", changes, err)
+	}
+	if len(inv.snapshot) != 2 {
+		t.Errorf("expected only the log's 2 files, got %v", inv.snapshot)
+	}
+
+	// the current file grows, and a new one starts
+	fake.logFiles = []*rds.DescribeDBLogFilesDetails{
+		logFile("error/postgresql.log.2022-05-17-09", 9*hour, 1000),
+		logFile("error/postgresql.log.2022-05-17-10", 11*hour, 800),
+		logFile("error/postgresql.log.2022-05-17-11", 11*hour, 10),
+	}
+	nower.t = nower.t.Add(10 * time.Second)
+	changes, err = inv.changes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []logFileChange{
+		{kind: logFileGrew, file: LogFile{LogFileName: "error/postgresql.log.2022-05-17-10", LastWritten: 11 * hour, LastWrittenTime: time.Unix(11*hour/1000, 0), Size: 800}},
+		{kind: logFileAdded, file: LogFile{LogFileName: "error/postgresql.log.2022-05-17-11", LastWritten: 11 * hour, LastWrittenTime: time.Unix(11*hour/1000, 0), Size: 10}},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected changes %v, got %v", expected, changes)
+	}
+	// only files written since the newest write we'd seen were asked for,
+	// and then, as a new file may mean others were rotated away, all of them
+	if in := fake.listings[len(fake.listings)-1]; in.FileLastWritten != nil {
+		t.Error("expected a full listing after a rotation")
+	}
+	if in := fake.listings[len(fake.listings)-2]; aws.Int64Value(in.FileLastWritten) != 10*hour-int64(listSlack/time.Millisecond) {
+		t.Errorf("expected to list files written since %d, got %v", 10*hour-int64(listSlack/time.Millisecond), in.FileLastWritten)
+	}
+
+	// the files are cached until --log_list_interval is up, however often
+	// they're asked for
+	listings := len(fake.listings)
+	if _, err := inv.files(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := inv.changes(); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.listings) != listings {
+		t.Error("expected the files to come from the cache")
+	}
+
+	// the 10:00 file is replaced with a smaller one, and the 09:00 one expires,
+	// which only a full listing shows
+	fake.logFiles = []*rds.DescribeDBLogFilesDetails{
+		logFile("error/postgresql.log.2022-05-17-10", 12*hour, 100),
+		logFile("error/postgresql.log.2022-05-17-11", 11*hour, 10),
+	}
+	nower.t = nower.t.Add(fullListInterval)
+	logFiles, err := inv.files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, lf := range logFiles {
+		names = append(names, lf.LogFileName)
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"error/postgresql.log.2022-05-17-10", "error/postgresql.log.2022-05-17-11"}) {
+		t.Errorf("unexpected files %v", names)
+	}
+	if in := fake.listings[len(fake.listings)-1]; in.FileLastWritten != nil {
+		t.Error("expected a full listing")
+	}
+	changes, _ = inv.changes()
+	var kinds []logFileChangeKind
+	for _, change := range changes {
+		kinds = append(kinds, change.kind)
+	}
+	if !reflect.DeepEqual(kinds, []logFileChangeKind{logFileRotated, logFileDeleted}) {
+		t.Errorf("expected the 10:00 file rotated and the 09:00 one deleted, got %v", changes)
+	}
+}
@@ -0,0 +1,276 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// leaderFilePrefix marks a --leader_election that locks a file
+	leaderFilePrefix = "file:"
+	// leaderDynamoDBPrefix marks a --leader_election that takes a lease in a
+	// DynamoDB table
+	leaderDynamoDBPrefix = "dynamodb:"
+)
+
+// ErrLostLeadership is returned by RunAsLeader when another replica takes
+// over while we're running
+var ErrLostLeadership = errors.New("lost leadership to another replica")
+
+// elector decides which of several replicas of rdslogs is the leader
+type elector interface {
+	// acquire tries once to become the leader, or to stay the leader if we
+	// already are, and returns whether we are
+	acquire() (bool, error)
+	// release gives up leadership, if we have it
+	release() error
+}
+
+// ValidateLeaderElection checks --leader_election names a way to elect a
+// leader that we know
+func ValidateLeaderElection(option string) error {
+	return validateCoordination("leader election", option, "file:<path>")
+}
+
+// validateCoordination checks option names a file or DynamoDB table for
+// replicas to coordinate through
+func validateCoordination(what, option, fileUsage string) error {
+	if option == "" {
+		return nil
+	}
+	for _, prefix := range []string{leaderFilePrefix, leaderDynamoDBPrefix} {
+		if strings.HasPrefix(option, prefix) && len(option) > len(prefix) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s %s not recognized, use %s or dynamodb:<table>", what, option, fileUsage)
+}
+
+// newElector creates the elector --leader_election asks for
+func (c *CLI) newElector() (elector, error) {
+	option := c.Options.Tail.LeaderElection
+	switch {
+	case strings.HasPrefix(option, leaderFilePrefix):
+		return &fileElector{path: strings.TrimPrefix(option, leaderFilePrefix)}, nil
+	case strings.HasPrefix(option, leaderDynamoDBPrefix):
+		return &dynamoDBElector{
+			c:      c,
+			table:  strings.TrimPrefix(option, leaderDynamoDBPrefix),
+			lockID: fmt.Sprintf("rdslogs/%s/%s", c.Options.InstanceIdentifier, c.Options.LogType),
+		}, nil
+	}
+	return nil, ValidateLeaderElection(option)
+}
+
+// leaderID identifies this replica in the leader lease
+func (c *CLI) leaderID() string {
+	if c.Options.Tail.LeaderID != "" {
+		return c.Options.Tail.LeaderID
+	}
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// RunAsLeader waits until this replica is elected leader, then calls run. If
+// leadership is lost while run is going, Abort is triggered for it and
+// RunAsLeader returns ErrLostLeadership once run returns, so that the
+// process exits and starts over as a standby rather than send logs the new
+// leader is also sending.
+func (c *CLI) RunAsLeader(run func() error) error {
+	e, err := c.newElector()
+	if err != nil {
+		return err
+	}
+	if c.Options.Tail.CheckpointFile == "" {
+		logrus.Warn("leader election without --checkpoint_file; a new leader starts from the end of the log")
+	}
+	// try a few times a lease, so a standby takes over soon after the
+	// leader's lease runs out
+	retry := c.Options.Tail.LeaderLease / 3
+
+	logrus.WithField("id", c.leaderID()).Info("Waiting to be elected leader")
+	for {
+		ok, err := e.acquire()
+		if err != nil {
+			logrus.WithError(err).Warn("unable to check for leadership")
+		}
+		if ok {
+			break
+		}
+		select {
+		case <-c.Abort:
+			return ErrAborted
+		case <-time.After(retry):
+		}
+	}
+	logrus.WithField("id", c.leaderID()).Info("Elected leader")
+	defer func() {
+		if err := e.release(); err != nil {
+			logrus.WithError(err).Warn("unable to give up leadership")
+		}
+	}()
+
+	// run stops on its own Abort, triggered by a signal or by losing
+	// leadership
+	abort := c.Abort
+	stop := make(chan bool)
+	lost := make(chan struct{})
+	done := make(chan struct{})
+	c.Abort, c.lost = stop, lost
+	defer func() { c.Abort, c.lost = abort, nil }()
+	go c.holdLeadership(e, abort, stop, lost, done)
+
+	err = run()
+	close(done)
+	select {
+	case <-lost:
+		return ErrLostLeadership
+	default:
+	}
+	return err
+}
+
+// leadershipLost returns whether Abort was triggered by losing leadership,
+// rather than by a signal. Another replica is then sending the log from the
+// checkpoint, so what's been read but not sent is for it to send.
+func (c *CLI) leadershipLost() bool {
+	select {
+	case <-c.lost:
+		return true
+	default:
+		return false
+	}
+}
+
+// holdLeadership renews our lease until done is closed, closing stop when
+// abort fires or leadership is lost. Renewals that fail outright are retried
+// until the lease would run out, as another replica may take over then.
+func (c *CLI) holdLeadership(e elector, abort <-chan bool, stop chan bool, lost, done chan struct{}) {
+	retry := c.Options.Tail.LeaderLease / 3
+	ticker := time.NewTicker(retry)
+	defer ticker.Stop()
+	renewed := c.now()
+	for {
+		select {
+		case <-done:
+			return
+		case <-abort:
+			close(stop)
+			return
+		case <-ticker.C:
+		}
+		ok, err := e.acquire()
+		if ok {
+			renewed = c.now()
+			continue
+		}
+		if err != nil && c.now().Sub(renewed) < c.Options.Tail.LeaderLease-retry {
+			logrus.WithError(err).Warn("unable to renew leadership, retrying")
+			continue
+		}
+		logrus.WithField("id", c.leaderID()).Warn("Lost leadership, stopping")
+		close(lost)
+		close(stop)
+		return
+	}
+}
+
+// fileElector elects whichever replica holds an flock on a file, which
+// should be on storage shared between the replicas. The lock goes when the
+// process does, so there's no lease to renew.
+type fileElector struct {
+	path string
+	f    *os.File
+}
+
+func (e *fileElector) acquire() (bool, error) {
+	if e.f != nil {
+		return true, nil
+	}
+	f, err := os.OpenFile(e.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return false, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return false, nil
+		}
+		return false, err
+	}
+	e.f = f
+	return true, nil
+}
+
+func (e *fileElector) release() error {
+	if e.f == nil {
+		return nil
+	}
+	syscall.Flock(int(e.f.Fd()), syscall.LOCK_UN)
+	err := e.f.Close()
+	e.f = nil
+	return err
+}
+
+// dynamoDBElector elects whichever replica holds an unexpired lease in a
+// DynamoDB table with a string partition key named lock_id. Taking and
+// renewing the lease are conditional writes, so only one replica can hold
+// it at a time. Lease expiry relies on the replicas' clocks roughly agreeing.
+type dynamoDBElector struct {
+	c      *CLI
+	table  string
+	lockID string
+}
+
+func (e *dynamoDBElector) acquire() (bool, error) {
+	now := e.c.now()
+	expires := now.Add(e.c.Options.Tail.LeaderLease)
+	_, err := e.c.DynamoDB.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(e.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"lock_id": {S: aws.String(e.lockID)},
+			"holder":  {S: aws.String(e.c.leaderID())},
+			"expires": {N: aws.String(strconv.FormatInt(expires.UnixNano()/int64(time.Millisecond), 10))},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(lock_id) OR expires < :now OR holder = :holder"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now":    {N: aws.String(strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10))},
+			":holder": {S: aws.String(e.c.leaderID())},
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (e *dynamoDBElector) release() error {
+	_, err := e.c.DynamoDB.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(e.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"lock_id": {S: aws.String(e.lockID)},
+		},
+		ConditionExpression: aws.String("holder = :holder"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":holder": {S: aws.String(e.c.leaderID())},
+		},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		// it's not ours any more anyway
+		return nil
+	}
+	return err
+}
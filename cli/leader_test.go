@@ -0,0 +1,225 @@
+package cli
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// fakeDynamoDB keeps leader leases in memory, checking the conditions the
+// dynamoDBElector writes with
+type fakeDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	sync.Mutex
+	// holder and expiry (in msec since the epoch) by lock_id
+	holders map[string]string
+	expires map[string]int64
+}
+
+func newFakeDynamoDB() *fakeDynamoDB {
+	return &fakeDynamoDB{holders: map[string]string{}, expires: map[string]int64{}}
+}
+
+func (f *fakeDynamoDB) PutItem(in *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	id := aws.StringValue(in.Item["lock_id"].S)
+	if in.ConditionExpression != nil {
+		now, _ := strconv.ParseInt(aws.StringValue(in.ExpressionAttributeValues[":now"].N), 10, 64)
+		holder := aws.StringValue(in.ExpressionAttributeValues[":holder"].S)
+		if current, ok := f.holders[id]; ok && f.expires[id] >= now && current != holder {
+			return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+		}
+	}
+	f.holders[id] = aws.StringValue(in.Item["holder"].S)
+	f.expires[id], _ = strconv.ParseInt(aws.StringValue(in.Item["expires"].N), 10, 64)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (f *fakeDynamoDB) DeleteItem(in *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	id := aws.StringValue(in.Key["lock_id"].S)
+	if in.ConditionExpression != nil && f.holders[id] != aws.StringValue(in.ExpressionAttributeValues[":holder"].S) {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+	delete(f.holders, id)
+	delete(f.expires, id)
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+// Scan lists the unexpired items whose lock_id starts with :prefix, all in
+// one page
+func (f *fakeDynamoDB) Scan(in *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	prefix := aws.StringValue(in.ExpressionAttributeValues[":prefix"].S)
+	now, _ := strconv.ParseInt(aws.StringValue(in.ExpressionAttributeValues[":now"].N), 10, 64)
+	out := &dynamodb.ScanOutput{}
+	for id, holder := range f.holders {
+		if strings.HasPrefix(id, prefix) && f.expires[id] >= now {
+			out.Items = append(out.Items, map[string]*dynamodb.AttributeValue{
+				"lock_id": {S: aws.String(id)},
+				"holder":  {S: aws.String(holder)},
+			})
+		}
+	}
+	return out, nil
+}
+
+// steal hands the lease for id to someone else
+func (f *fakeDynamoDB) steal(id string) {
+	f.Lock()
+	defer f.Unlock()
+	f.holders[id] = "thief"
+	f.expires[id] = time.Now().Add(time.Hour).UnixNano() / int64(time.Millisecond)
+}
+
+func newReplica(ddb *fakeDynamoDB, id string) *CLI {
+	return &CLI{
+		Options: &Options{
+			InstanceIdentifier: "db",
+			LogType:            LogTypeQuery,
+			Tail: TailOptions{
+				LeaderElection: "dynamodb:rdslogs-leader",
+				LeaderLease:    30 * time.Millisecond,
+				LeaderID:       id,
+				CheckpointFile: "unused",
+			},
+		},
+		DynamoDB: ddb,
+		Abort:    make(chan bool),
+	}
+}
+
+// runUntilAborted is a run func that reports it started and stops on Abort
+func runUntilAborted(c *CLI, started chan<- string) func() error {
+	return func() error {
+		started <- c.Options.Tail.LeaderID
+		<-c.Abort
+		return nil
+	}
+}
+
+func TestValidateLeaderElection(t *testing.T) {
+	for _, option := range []string{"", "file:/tmp/rdslogs.lock", "dynamodb:rdslogs"} {
+		if err := ValidateLeaderElection(option); err != nil {
+			t.Errorf("%q: unexpected error %s", option, err)
+		}
+	}
+	for _, option := range []string{"file:", "k8s:rdslogs", "/tmp/rdslogs.lock"} {
+		if err := ValidateLeaderElection(option); err == nil {
+			t.Errorf("%q: expected an error", option)
+		}
+	}
+}
+
+func TestFileElector(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rdslogs.lock")
+	a := &fileElector{path: path}
+	b := &fileElector{path: path}
+	if ok, err := a.acquire(); !ok || err != nil {
+		t.Fatalf("first replica wasn't elected: %v %v", ok, err)
+	}
+	if ok, err := b.acquire(); ok || err != nil {
+		t.Fatalf("second replica was elected while the first held the lock: %v %v", ok, err)
+	}
+	if ok, _ := a.acquire(); !ok {
+		t.Error("leader lost the lock it holds")
+	}
+	if err := a.release(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.acquire(); !ok || err != nil {
+		t.Errorf("second replica wasn't elected once the lock was released: %v %v", ok, err)
+	}
+	b.release()
+}
+
+func TestDynamoDBElectorLeaseExpires(t *testing.T) {
+	ddb := newFakeDynamoDB()
+	now := time.Now()
+	a := newReplica(ddb, "a")
+	a.fakeNower = &FakeNower{t: now}
+	b := newReplica(ddb, "b")
+	b.fakeNower = &FakeNower{t: now}
+	ea, _ := a.newElector()
+	eb, _ := b.newElector()
+
+	if ok, err := ea.acquire(); !ok || err != nil {
+		t.Fatalf("first replica wasn't elected: %v %v", ok, err)
+	}
+	if ok, err := eb.acquire(); ok || err != nil {
+		t.Fatalf("second replica was elected during the first's lease: %v %v", ok, err)
+	}
+	// the leader dies without releasing its lease
+	b.fakeNower = &FakeNower{t: now.Add(time.Second)}
+	if ok, err := eb.acquire(); !ok || err != nil {
+		t.Errorf("second replica wasn't elected once the lease expired: %v %v", ok, err)
+	}
+}
+
+func TestRunAsLeaderTakeover(t *testing.T) {
+	ddb := newFakeDynamoDB()
+	a := newReplica(ddb, "a")
+	b := newReplica(ddb, "b")
+	// RunAsLeader swaps in its own Abort, so hold on to the ones to signal
+	abortA, abortB := a.Abort, b.Abort
+	started := make(chan string, 2)
+	errs := make(chan error, 2)
+	go func() { errs <- a.RunAsLeader(runUntilAborted(a, started)) }()
+	if id := <-started; id != "a" {
+		t.Fatalf("expected a to lead, got %s", id)
+	}
+	go func() { errs <- b.RunAsLeader(runUntilAborted(b, started)) }()
+
+	select {
+	case id := <-started:
+		t.Fatalf("%s started while a was leading", id)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// stop the leader, and the standby should take over
+	abortA <- true
+	if err := <-errs; err != nil {
+		t.Errorf("unexpected error from a: %s", err)
+	}
+	select {
+	case id := <-started:
+		if id != "b" {
+			t.Errorf("expected b to take over, got %s", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("standby didn't take over")
+	}
+	abortB <- true
+	if err := <-errs; err != nil {
+		t.Errorf("unexpected error from b: %s", err)
+	}
+}
+
+func TestRunAsLeaderLosesLease(t *testing.T) {
+	ddb := newFakeDynamoDB()
+	a := newReplica(ddb, "a")
+	started := make(chan string, 1)
+	errs := make(chan error, 1)
+	go func() { errs <- a.RunAsLeader(runUntilAborted(a, started)) }()
+	<-started
+	ddb.steal("rdslogs/db/query")
+	select {
+	case err := <-errs:
+		if err != ErrLostLeadership {
+			t.Errorf("expected ErrLostLeadership, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("leader kept running after losing its lease")
+	}
+}
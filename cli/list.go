@@ -0,0 +1,131 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rds"
+)
+
+const (
+	// FormatTable prints a list as a table for people to read
+	FormatTable = "table"
+	// FormatJSON prints a list as JSON for scripts
+	FormatJSON = "json"
+)
+
+// ListOptions are the flags of the list-instances and list-logs commands
+type ListOptions struct {
+	Format string `long:"format" description:"How to print the list: table or json" choice:"table" choice:"json" default:"table"`
+}
+
+// Instance is an RDS instance, as list-instances describes it
+type Instance struct {
+	Identifier    string `json:"identifier"`
+	Engine        string `json:"engine"`
+	EngineVersion string `json:"engine_version"`
+	Status        string `json:"status"`
+	Region        string `json:"region"`
+	// DBType is the --dbtype rdslogs reads the instance's logs as, or ""
+	// if it doesn't know the engine
+	DBType string `json:"dbtype"`
+}
+
+// ListInstances describes the RDS instances in --region
+func (c *CLI) ListInstances() ([]Instance, error) {
+	instances := []Instance{}
+	in := &rds.DescribeDBInstancesInput{}
+	for {
+		out, err := c.RDS.DescribeDBInstances(in)
+		if err != nil {
+			return nil, err
+		}
+		for _, instance := range out.DBInstances {
+			engine := aws.StringValue(instance.Engine)
+			instances = append(instances, Instance{
+				Identifier:    aws.StringValue(instance.DBInstanceIdentifier),
+				Engine:        engine,
+				EngineVersion: aws.StringValue(instance.EngineVersion),
+				Status:        aws.StringValue(instance.DBInstanceStatus),
+				Region:        c.Options.Region,
+				DBType:        dbTypeForEngine(engine),
+			})
+		}
+		if out.Marker == nil {
+			break
+		}
+		in = &rds.DescribeDBInstancesInput{Marker: out.Marker}
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].Identifier < instances[j].Identifier })
+	return instances, nil
+}
+
+// ListLogs returns the instance's log files whose names start with
+// --log_file (all of them if it isn't given), oldest first
+func (c *CLI) ListLogs() ([]LogFile, error) {
+	logFiles, err := c.getListRDSLogFiles()
+	if err != nil {
+		return nil, err
+	}
+	var matching []LogFile
+	for _, lf := range logFiles {
+		if strings.HasPrefix(lf.LogFileName, c.Options.LogFile) {
+			matching = append(matching, lf)
+		}
+	}
+	sort.SliceStable(matching, func(i, j int) bool { return matching[i].LastWritten < matching[j].LastWritten })
+	return matching, nil
+}
+
+// PrintInstances writes instances to w in format
+func PrintInstances(w io.Writer, format string, instances []Instance) error {
+	if format == FormatJSON {
+		return printJSON(w, instances)
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "IDENTIFIER\tENGINE\tVERSION\tSTATUS\tREGION\tDBTYPE")
+	for _, i := range instances {
+		dbType := i.DBType
+		if dbType == "" {
+			dbType = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", i.Identifier, i.Engine, i.EngineVersion, i.Status, i.Region, dbType)
+	}
+	return tw.Flush()
+}
+
+// logFileJSON is a log file as list-logs prints it in JSON
+type logFileJSON struct {
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	LastWritten time.Time `json:"last_written"`
+}
+
+// PrintLogs writes logFiles to w in format
+func PrintLogs(w io.Writer, format string, logFiles []LogFile) error {
+	if format == FormatJSON {
+		out := make([]logFileJSON, 0, len(logFiles))
+		for _, lf := range logFiles {
+			out = append(out, logFileJSON{Name: lf.LogFileName, Size: lf.Size, LastWritten: lf.LastWrittenTime.UTC()})
+		}
+		return printJSON(w, out)
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSIZE\tLAST WRITTEN")
+	for _, lf := range logFiles {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", lf.LogFileName, lf.Size, lf.LastWrittenTime.UTC().Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
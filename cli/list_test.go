@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rds"
+)
+
+func TestListInstances(t *testing.T) {
+	c := &CLI{
+		Options: &Options{Region: "eu-west-1"},
+		RDS: &FakeRDS{instances: []*rds.DBInstance{
+			{DBInstanceIdentifier: aws.String("orders"), Engine: aws.String("postgres"), EngineVersion: aws.String("14.2"), DBInstanceStatus: aws.String("available")},
+			{DBInstanceIdentifier: aws.String("legacy"), Engine: aws.String("db2-se"), EngineVersion: aws.String("11.5"), DBInstanceStatus: aws.String("stopped")},
+		}},
+	}
+	instances, err := c.ListInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Instance{
+		{Identifier: "legacy", Engine: "db2-se", EngineVersion: "11.5", Status: "stopped", Region: "eu-west-1"},
+		{Identifier: "orders", Engine: "postgres", EngineVersion: "14.2", Status: "available", Region: "eu-west-1", DBType: DBTypePostgreSQL},
+	}
+	if !reflect.DeepEqual(instances, expected) {
+		t.Errorf("expected %v, got %v", expected, instances)
+	}
+
+	var table bytes.Buffer
+	if err := PrintInstances(&table, FormatTable, instances); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(table.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "IDENTIFIER") || !strings.Contains(lines[1], "stopped") {
+		t.Errorf("unexpected table\n%s", table.String())
+	}
+
+	var out bytes.Buffer
+	if err := PrintInstances(&out, FormatJSON, instances); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []Instance
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil || !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("expected the instances back from the JSON, got %v %v", decoded, err)
+	}
+}
+
+func TestListLogs(t *testing.T) {
+	c := &CLI{
+		Options: &Options{InstanceIdentifier: "orders", LogFile: "error/postgresql.log"},
+		RDS: &FakeRDS{logFiles: []*rds.DescribeDBLogFilesDetails{
+			{LogFileName: aws.String("error/postgresql.log.2022-05-17-11"), LastWritten: aws.Int64(1652788800000), Size: aws.Int64(300)},
+			{LogFileName: aws.String("error/postgresql.log.2022-05-17-10"), LastWritten: aws.Int64(1652785200000), Size: aws.Int64(200)},
+			{LogFileName: aws.String("error/postgres-upgrade.log"), LastWritten: aws.Int64(1652785200000), Size: aws.Int64(100)},
+		}},
+	}
+	logFiles, err := c.ListLogs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logFiles) != 2 || logFiles[0].LogFileName != "error/postgresql.log.2022-05-17-10" {
+		t.Fatalf("expected the log's 2 files, oldest first, got %v", logFiles)
+	}
+
+	var out bytes.Buffer
+	if err := PrintLogs(&out, FormatJSON, logFiles); err != nil {
+		t.Fatal(err)
+	}
+	expected := `[
+  {
+    "name": "error/postgresql.log.2022-05-17-10",
+    "size": 200,
+    "last_written": "2022-05-17T11:00:00Z"
+  },
+  {
+    "name": "error/postgresql.log.2022-05-17-11",
+    "size": 300,
+    "last_written": "2022-05-17T12:00:00Z"
+  }
+]
+`
+	if out.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out.String())
+	}
+
+	c.Options.LogFile = ""
+	if logFiles, err := c.ListLogs(); err != nil || len(logFiles) != 3 {
+		t.Errorf("expected all 3 files without --log_file, got %v %v", logFiles, err)
+	}
+}
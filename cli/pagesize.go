@@ -0,0 +1,50 @@
+package cli
+
+import "strings"
+
+const (
+	// portionLimit is the most data DownloadDBLogFilePortion returns at once
+	portionLimit = 1024 * 1024
+	// truncatedSuffix is what RDS ends a portion with when the lines asked for
+	// didn't fit in portionLimit
+	truncatedSuffix = "[Your log message was truncated]"
+)
+
+// pageSizer adapts the number of lines asked for per DownloadDBLogFilePortion
+// call. Long lines can make --num_lines lines overflow the portion limit, in
+// which case RDS truncates the portion, so we ask for fewer lines until they
+// fit and more again once lines are short.
+type pageSizer struct {
+	size int64
+	max  int64
+}
+
+func newPageSizer(max int64) *pageSizer {
+	return &pageSizer{size: max, max: max}
+}
+
+// shrink halves the page size after a truncated portion. It returns false if
+// we're already asking for a single line, and so can't do any better.
+func (p *pageSizer) shrink() bool {
+	if p.size <= 1 {
+		return false
+	}
+	p.size /= 2
+	return true
+}
+
+// observe grows the page size back towards the maximum after a portion that
+// used less than a quarter of the limit
+func (p *pageSizer) observe(dataLen int) {
+	if p.size < p.max && dataLen < portionLimit/4 {
+		p.size *= 2
+		if p.size > p.max {
+			p.size = p.max
+		}
+	}
+}
+
+// isTruncated is true if RDS couldn't fit the lines asked for in the portion
+func isTruncated(data string) bool {
+	return strings.HasSuffix(data, truncatedSuffix) || len(data) >= portionLimit
+}
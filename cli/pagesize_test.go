@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPageSizer(t *testing.T) {
+	p := newPageSizer(10000)
+	for _, expected := range []int64{5000, 2500, 1250} {
+		if !p.shrink() {
+			t.Fatal("expected to be able to shrink")
+		}
+		if p.size != expected {
+			t.Errorf("expected page size %d, got %d", expected, p.size)
+		}
+	}
+	// a busy portion doesn't grow the page
+	p.observe(portionLimit / 2)
+	if p.size != 1250 {
+		t.Errorf("expected page size to stay at 1250, got %d", p.size)
+	}
+	// short lines grow it back, but no further than the max
+	for i := 0; i < 5; i++ {
+		p.observe(100)
+	}
+	if p.size != 10000 {
+		t.Errorf("expected page size to grow back to 10000, got %d", p.size)
+	}
+
+	p = newPageSizer(1)
+	if p.shrink() {
+		t.Error("expected not to be able to shrink below one line")
+	}
+}
+
+func TestIsTruncated(t *testing.T) {
+	testCases := []struct {
+		data     string
+		expected bool
+	}{
+		{"", false},
+		{"select 1;\n", false},
+		{"select 1;\nselect " + truncatedSuffix, true},
+		{strings.Repeat("x", portionLimit), true},
+	}
+	for _, tc := range testCases {
+		if truncated := isTruncated(tc.data); truncated != tc.expected {
+			t.Errorf("expected truncated %v for %.40q", tc.expected, tc.data)
+		}
+	}
+}
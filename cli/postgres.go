@@ -0,0 +1,65 @@
+package cli
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/sirupsen/logrus"
+)
+
+// getPostgresLinePrefix returns the log_line_prefix to use when parsing
+// postgres logs: the --log_line_prefix flag if given, otherwise the value set in
+// the instance's DB parameter group, otherwise the RDS default. When replaying
+// saved logs, there's no instance to look the parameter up from.
+func (c *CLI) getPostgresLinePrefix() string {
+	if c.Options.LogLinePrefix != "" {
+		return c.Options.LogLinePrefix
+	}
+	if len(c.Options.Input) > 0 {
+		// replaying logs, we've no instance to ask
+		return rdsPostgresLinePrefix
+	}
+	prefix, err := c.lookupPostgresLinePrefix()
+	if err != nil {
+		logrus.WithError(err).
+			Warnf("unable to read log_line_prefix from the DB parameter group, assuming the RDS default %q", rdsPostgresLinePrefix)
+		return rdsPostgresLinePrefix
+	}
+	if prefix == "" {
+		return rdsPostgresLinePrefix
+	}
+	logrus.WithField("log_line_prefix", prefix).Info("Using log_line_prefix from DB parameter group")
+	return prefix
+}
+
+// lookupPostgresLinePrefix finds the user-set log_line_prefix in the instance's
+// DB parameter groups. It returns "" if no parameter group overrides it.
+func (c *CLI) lookupPostgresLinePrefix() (string, error) {
+	instance, err := c.describeInstance()
+	if err != nil {
+		return "", err
+	}
+	var prefix string
+	for _, group := range instance.DBParameterGroups {
+		err := c.RDS.DescribeDBParametersPages(&rds.DescribeDBParametersInput{
+			DBParameterGroupName: group.DBParameterGroupName,
+			// parameters left at the engine default aren't interesting: that's
+			// rdsPostgresLinePrefix
+			Source: aws.String("user"),
+		}, func(out *rds.DescribeDBParametersOutput, lastPage bool) bool {
+			for _, param := range out.Parameters {
+				if aws.StringValue(param.ParameterName) == "log_line_prefix" {
+					prefix = aws.StringValue(param.ParameterValue)
+					return false
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return "", err
+		}
+		if prefix != "" {
+			return prefix, nil
+		}
+	}
+	return "", nil
+}
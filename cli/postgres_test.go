@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rds"
+)
+
+func TestGetPostgresLinePrefix(t *testing.T) {
+	fake := &FakeRDS{
+		instances: []*rds.DBInstance{{
+			DBInstanceIdentifier: aws.String("pg"),
+			DBParameterGroups: []*rds.DBParameterGroupStatus{
+				{DBParameterGroupName: aws.String("custom")},
+			},
+		}, {
+			DBInstanceIdentifier: aws.String("pg-default"),
+			DBParameterGroups: []*rds.DBParameterGroupStatus{
+				{DBParameterGroupName: aws.String("default.postgres14")},
+			},
+		}},
+		parameters: map[string][]*rds.Parameter{
+			"custom": {
+				{ParameterName: aws.String("log_min_duration_statement"), ParameterValue: aws.String("0")},
+				{ParameterName: aws.String("log_line_prefix"), ParameterValue: aws.String("%m [%p] %u@%d ")},
+			},
+		},
+	}
+	testCases := []struct {
+		identifier string
+		flag       string
+		expected   string
+	}{
+		{"pg", "", "%m [%p] %u@%d "},
+		{"pg", "%t:%u", "%t:%u"},
+		{"pg-default", "", rdsPostgresLinePrefix},
+		// lookup fails, fall back to the default
+		{"missing", "", rdsPostgresLinePrefix},
+	}
+	for _, tc := range testCases {
+		c := CLI{
+			Options: &Options{InstanceIdentifier: tc.identifier, LogLinePrefix: tc.flag},
+			RDS:     fake,
+		}
+		if prefix := c.getPostgresLinePrefix(); prefix != tc.expected {
+			t.Errorf("instance %s, flag %q: expected prefix %q, got %q", tc.identifier, tc.flag, tc.expected, prefix)
+		}
+	}
+}
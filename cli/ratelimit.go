@@ -0,0 +1,175 @@
+package cli
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/rds/rdsiface"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// rateRecovery is how much of its budget a throttled operation's rate
+	// climbs back by each second
+	rateRecovery = 0.05
+	// minRateFraction is the least of its budget throttling can push an
+	// operation's rate down to
+	minRateFraction = 0.05
+)
+
+// DefaultRateLimits are the requests per second allowed for each RDS
+// operation unless --rate_limit says otherwise. AWS doesn't publish the
+// limits, so these are kept well under what we've seen throttled.
+var DefaultRateLimits = map[string]float64{
+	"DownloadDBLogFilePortion": 5,
+	"DescribeDBLogFiles":       2,
+	"DescribeDBInstances":      2,
+	"DescribeDBParameters":     1,
+}
+
+// limiter is a token bucket allowing requests at up to limit per second. The
+// rate it allows halves each time AWS throttles us, and climbs back towards
+// limit over time.
+type limiter struct {
+	sync.Mutex
+	limit  float64
+	rate   float64
+	tokens float64
+	last   time.Time
+
+	// allow changing the time for tests
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+func newLimiter(limit float64) *limiter {
+	return &limiter{limit: limit, rate: limit, tokens: 1, now: time.Now, sleep: time.Sleep}
+}
+
+// wait blocks until a request is allowed. Callers take a token even if there
+// isn't one yet, and sleep until it would have arrived, so concurrent callers
+// queue up in turn.
+func (l *limiter) wait() {
+	l.Lock()
+	now := l.now()
+	if !l.last.IsZero() {
+		elapsed := now.Sub(l.last).Seconds()
+		l.rate = math.Min(l.limit, l.rate+l.limit*rateRecovery*elapsed)
+		l.tokens = math.Min(math.Max(1, l.rate), l.tokens+elapsed*l.rate)
+	}
+	l.last = now
+	l.tokens--
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.Unlock()
+	if delay > 0 {
+		l.sleep(delay)
+	}
+}
+
+// throttled slows the limiter down after AWS throttled a request, and
+// returns the new rate
+func (l *limiter) throttled() float64 {
+	l.Lock()
+	defer l.Unlock()
+	l.rate = math.Max(l.rate/2, l.limit*minRateFraction)
+	if l.tokens > 0 {
+		l.tokens = 0
+	}
+	return l.rate
+}
+
+// rateLimitedRDS wraps an RDS client, pacing the calls rdslogs makes to stay
+// within a budget for each operation. One is shared by everything talking to
+// RDS, so several streams and downloads split the budget rather than each
+// spending all of it.
+type rateLimitedRDS struct {
+	rdsiface.RDSAPI
+	limiters map[string]*limiter
+}
+
+// NewRateLimitedRDS wraps api with limits on the requests per second for each
+// operation, on top of DefaultRateLimits. A limit of 0 lets an operation go
+// unlimited.
+func NewRateLimitedRDS(api rdsiface.RDSAPI, limits map[string]float64) (rdsiface.RDSAPI, error) {
+	r := &rateLimitedRDS{RDSAPI: api, limiters: make(map[string]*limiter)}
+	for op, limit := range DefaultRateLimits {
+		r.limiters[op] = newLimiter(limit)
+	}
+	for op, limit := range limits {
+		if _, ok := DefaultRateLimits[op]; !ok {
+			var ops []string
+			for op := range DefaultRateLimits {
+				ops = append(ops, op)
+			}
+			sort.Strings(ops)
+			return nil, fmt.Errorf("rate limit for unknown operation %s, use one of %s", op, strings.Join(ops, ", "))
+		}
+		if limit < 0 {
+			return nil, fmt.Errorf("rate limit for %s must not be negative", op)
+		}
+		if limit == 0 {
+			delete(r.limiters, op)
+			continue
+		}
+		r.limiters[op] = newLimiter(limit)
+	}
+	return r, nil
+}
+
+// call makes a request for op once its limiter allows it, and slows the
+// limiter down if AWS throttled it anyway
+func (r *rateLimitedRDS) call(op string, fn func() error) error {
+	l := r.limiters[op]
+	if l == nil {
+		return fn()
+	}
+	l.wait()
+	err := fn()
+	if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == "Throttling" || aerr.Code() == "ThrottlingException") {
+		rate := l.throttled()
+		logrus.WithFields(logrus.Fields{
+			"operation": op,
+			"rate":      rate,
+		}).Info("AWS rate limit hit; slowing down")
+	}
+	return err
+}
+
+func (r *rateLimitedRDS) DownloadDBLogFilePortion(in *rds.DownloadDBLogFilePortionInput) (out *rds.DownloadDBLogFilePortionOutput, err error) {
+	err = r.call("DownloadDBLogFilePortion", func() error {
+		out, err = r.RDSAPI.DownloadDBLogFilePortion(in)
+		return err
+	})
+	return out, err
+}
+
+func (r *rateLimitedRDS) DescribeDBLogFiles(in *rds.DescribeDBLogFilesInput) (out *rds.DescribeDBLogFilesOutput, err error) {
+	err = r.call("DescribeDBLogFiles", func() error {
+		out, err = r.RDSAPI.DescribeDBLogFiles(in)
+		return err
+	})
+	return out, err
+}
+
+func (r *rateLimitedRDS) DescribeDBInstances(in *rds.DescribeDBInstancesInput) (out *rds.DescribeDBInstancesOutput, err error) {
+	err = r.call("DescribeDBInstances", func() error {
+		out, err = r.RDSAPI.DescribeDBInstances(in)
+		return err
+	})
+	return out, err
+}
+
+func (r *rateLimitedRDS) DescribeDBParametersPages(in *rds.DescribeDBParametersInput, fn func(*rds.DescribeDBParametersOutput, bool) bool) error {
+	return r.call("DescribeDBParameters", func() error {
+		return r.RDSAPI.DescribeDBParametersPages(in, fn)
+	})
+}
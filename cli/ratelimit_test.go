@@ -0,0 +1,105 @@
+package cli
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/rds"
+)
+
+// fakeClock is a clock that sleeping moves forward
+type fakeClock struct {
+	t     time.Time
+	slept time.Duration
+}
+
+func (f *fakeClock) now() time.Time { return f.t }
+func (f *fakeClock) sleep(d time.Duration) {
+	f.t = f.t.Add(d)
+	f.slept += d
+}
+
+func newTestLimiter(limit float64) (*limiter, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	l := newLimiter(limit)
+	l.now = clock.now
+	l.sleep = clock.sleep
+	return l, clock
+}
+
+func TestLimiterPaces(t *testing.T) {
+	l, clock := newTestLimiter(5)
+	for i := 0; i < 11; i++ {
+		l.wait()
+	}
+	// the first request goes straight away, then one every 200ms
+	if clock.slept != 2*time.Second {
+		t.Errorf("expected 11 requests at 5/s to take 2s, took %s", clock.slept)
+	}
+}
+
+func TestLimiterAdapts(t *testing.T) {
+	l, clock := newTestLimiter(4)
+	l.wait()
+	if rate := l.throttled(); rate != 2 {
+		t.Errorf("expected the rate to halve to 2, got %v", rate)
+	}
+	for i := 0; i < 10; i++ {
+		l.throttled()
+	}
+	if l.rate != 4*minRateFraction {
+		t.Errorf("expected the rate to bottom out at %v, got %v", 4*minRateFraction, l.rate)
+	}
+
+	// and it climbs back over time
+	clock.sleep(10 * time.Second)
+	l.wait()
+	if l.rate <= 4*minRateFraction || l.rate >= 4 {
+		t.Errorf("expected the rate to be recovering after 10s, got %v", l.rate)
+	}
+	clock.sleep(time.Minute)
+	l.wait()
+	if l.rate != 4 {
+		t.Errorf("expected the rate to have recovered to 4, got %v", l.rate)
+	}
+}
+
+func TestRateLimitedRDS(t *testing.T) {
+	throttle := true
+	fake := &FakeRDS{portions: func(*rds.DownloadDBLogFilePortionInput) (*rds.DownloadDBLogFilePortionOutput, error) {
+		if throttle {
+			return nil, awserr.New("Throttling", "Rate exceeded", nil)
+		}
+		return &rds.DownloadDBLogFilePortionOutput{}, nil
+	}}
+	api, err := NewRateLimitedRDS(fake, map[string]float64{"DescribeDBInstances": 0, "DownloadDBLogFilePortion": 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := api.(*rateLimitedRDS)
+	if _, ok := r.limiters["DescribeDBInstances"]; ok {
+		t.Error("expected DescribeDBInstances to be unlimited")
+	}
+	if l := r.limiters["DescribeDBLogFiles"]; l == nil || l.limit != DefaultRateLimits["DescribeDBLogFiles"] {
+		t.Error("expected DescribeDBLogFiles to keep its default limit")
+	}
+
+	if _, err := r.DownloadDBLogFilePortion(&rds.DownloadDBLogFilePortionInput{}); err == nil {
+		t.Fatal("expected the throttling error to be passed on")
+	}
+	if rate := r.limiters["DownloadDBLogFilePortion"].rate; rate != 5 {
+		t.Errorf("expected throttling to halve the rate to 5, got %v", rate)
+	}
+	throttle = false
+	if _, err := r.DownloadDBLogFilePortion(&rds.DownloadDBLogFilePortionInput{}); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := NewRateLimitedRDS(fake, map[string]float64{"DeleteDBInstance": 1}); err == nil {
+		t.Error("expected an error for an unknown operation")
+	}
+	if _, err := NewRateLimitedRDS(fake, map[string]float64{"DescribeDBLogFiles": -1}); err == nil {
+		t.Error("expected an error for a negative limit")
+	}
+}
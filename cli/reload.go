@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"reflect"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/honeycombio/rdslogs/publisher"
+)
+
+// reloadable are the options, by flag name, that Reload applies to a running
+// rdslogs. Changing any of the others needs a restart.
+var reloadable = map[string]bool{
+	"sample_rate": true,
+	"scrub_query": true,
+	"add_field":   true,
+	"debug":       true,
+	"stream":      true,
+	// these only matter when starting up
+	"config":               true,
+	"version":              true,
+	"write_default_config": true,
+}
+
+// restartRequired returns the flag names of the options that differ between
+// current and next which Reload can't apply
+func restartRequired(current, next *Options) []string {
+	return changedOptions(reflect.ValueOf(current).Elem(), reflect.ValueOf(next).Elem())
+}
+
+// changedOptions returns the flag names of the options that differ between
+// the structs cv and nv which Reload can't apply, including those of the
+// commands that have flags of their own
+func changedOptions(cv, nv reflect.Value) []string {
+	var names []string
+	for i := 0; i < cv.NumField(); i++ {
+		field := cv.Type().Field(i)
+		if field.Type.Kind() == reflect.Struct && field.Tag.Get("no-flag") != "" {
+			names = append(names, changedOptions(cv.Field(i), nv.Field(i))...)
+			continue
+		}
+		name := field.Tag.Get("long")
+		// the structured config's streams are compared one by one as they're
+		// reloaded
+		if name == "" || reloadable[name] {
+			continue
+		}
+		if !reflect.DeepEqual(cv.Field(i).Interface(), nv.Field(i).Interface()) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Reload applies the reloadable settings in opts, re-read from the config
+// file, without losing our place in the log. Changes to other settings since
+// started, the options as rdslogs was started with (before the engine's
+// defaults were filled in), are logged as errors and ignored.
+func (c *CLI) Reload(started, opts *Options) {
+	for _, name := range restartRequired(started, opts) {
+		logrus.WithField("option", name).
+			Error("option changed, but it can't be changed without restarting rdslogs; keeping the old value")
+	}
+	if opts.SampleRate < 1 {
+		logrus.WithField("sample_rate", opts.SampleRate).
+			Error("sample rate must be a positive integer; keeping the old value")
+		opts.SampleRate = c.Options.SampleRate
+	}
+	if opts.Debug {
+		logrus.SetLevel(logrus.DebugLevel)
+	} else {
+		logrus.SetLevel(logrus.InfoLevel)
+	}
+	c.applyReload(opts)
+	c.reloadMu.Lock()
+	reloads := c.reloads
+	c.reloadMu.Unlock()
+	if reloads != nil {
+		// pass it on to the worker pool's streams
+		reloads <- opts
+	}
+	logrus.WithFields(logrus.Fields{
+		"sample_rate": opts.SampleRate,
+		"scrub_query": opts.ScrubQuery,
+		"add_field":   opts.AddFields,
+	}).Info("Reloaded configuration")
+}
+
+// applyReload takes on the reloadable settings in opts, and passes them on
+// to the output
+func (c *CLI) applyReload(opts *Options) {
+	c.reloadMu.Lock()
+	c.Options.SampleRate = opts.SampleRate
+	c.Options.ScrubQuery = opts.ScrubQuery
+	c.Options.AddFields = opts.AddFields
+	c.Options.Debug = opts.Debug
+	c.Options.Tail.Streams = opts.Tail.Streams
+	output := c.output
+	c.reloadMu.Unlock()
+
+	if hp, ok := output.(*publisher.HoneycombPublisher); ok {
+		hp.Reconfigure(opts.ScrubQuery, opts.SampleRate, opts.AddFields)
+	}
+}
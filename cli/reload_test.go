@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/honeycombio/rdslogs/publisher"
+)
+
+func TestRestartRequired(t *testing.T) {
+	started := &Options{InstanceIdentifier: "db", SampleRate: 1, ConfigFile: "rdslogs.conf"}
+	next := &Options{InstanceIdentifier: "db", SampleRate: 10, ScrubQuery: true, AddFields: map[string]string{"env": "prod"}, ConfigFile: "rdslogs.conf"}
+	if names := restartRequired(started, next); len(names) != 0 {
+		t.Errorf("expected everything to be reloadable, got %v", names)
+	}
+	next.InstanceIdentifier = "other-db"
+	next.Dataset = "rds"
+	if names := restartRequired(started, next); !reflect.DeepEqual(names, []string{"identifier", "dataset"}) {
+		t.Errorf("expected identifier and dataset to need a restart, got %v", names)
+	}
+	// so do the tail command's, other than the streams
+	next = &Options{InstanceIdentifier: "db", SampleRate: 1, ConfigFile: "rdslogs.conf", Tail: TailOptions{Shard: "file:/shared/pool", Streams: []string{"db-2"}}}
+	if names := restartRequired(started, next); !reflect.DeepEqual(names, []string{"shard"}) {
+		t.Errorf("expected shard to need a restart, got %v", names)
+	}
+}
+
+func TestReload(t *testing.T) {
+	pub := &publisher.HoneycombPublisher{SampleRate: 1}
+	started := &Options{InstanceIdentifier: "db", SampleRate: 1}
+	opts := *started
+	c := &CLI{Options: &opts, output: pub}
+
+	c.Reload(started, &Options{InstanceIdentifier: "other-db", SampleRate: 20, ScrubQuery: true, AddFields: map[string]string{"env": "prod"}})
+	if pub.SampleRate != 20 || !pub.ScrubQuery || pub.AddFields["env"] != "prod" {
+		t.Errorf("expected the output to be reconfigured, got %+v", pub)
+	}
+	if c.Options.SampleRate != 20 {
+		t.Errorf("expected sample rate 20, got %d", c.Options.SampleRate)
+	}
+	if c.Options.InstanceIdentifier != "db" {
+		t.Errorf("expected the identifier to need a restart, got %s", c.Options.InstanceIdentifier)
+	}
+
+	// a bad sample rate keeps the old one
+	c.Reload(started, &Options{InstanceIdentifier: "db", SampleRate: 0})
+	if pub.SampleRate != 20 {
+		t.Errorf("expected sample rate to stay 20, got %d", pub.SampleRate)
+	}
+}
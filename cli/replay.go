@@ -0,0 +1,191 @@
+package cli
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// InputStdin is the --input that reads from STDIN
+	InputStdin = "-"
+	// inputFilePrefix marks an --input that names files
+	inputFilePrefix = "file:"
+
+	// replayBatchLines is how many lines to hand the output at a time when
+	// replaying as fast as we can
+	replayBatchLines = 1000
+	// replayTick is how often to hand the output a batch of lines when
+	// replaying at --input_rate
+	replayTick = 100 * time.Millisecond
+)
+
+// Replay reads logs from the --input files or STDIN instead of from RDS, and
+// sends them through the parser and output like Stream does. It doesn't talk
+// to AWS at all, so --dbtype has to be given.
+func (c *CLI) Replay() error {
+	if c.Options.DBType == "" {
+		return fmt.Errorf("--dbtype is required with --input")
+	}
+	if c.Options.LogType == "" {
+		c.Options.LogType = defaultLogType(c.Options.DBType)
+	}
+	src, err := c.source()
+	if err != nil {
+		return err
+	}
+	// check all the inputs exist before we start sending anything
+	var names []string
+	for _, input := range c.Options.Input {
+		matches, err := expandInput(input)
+		if err != nil {
+			return err
+		}
+		names = append(names, matches...)
+	}
+
+	closeOutput, err := c.openOutput(src)
+	if err != nil {
+		return err
+	}
+	defer closeOutput()
+
+	for _, name := range names {
+		logrus.WithField("input", name).Info("Replaying log")
+		r, err := openInput(name)
+		if err != nil {
+			return err
+		}
+		err = c.replay(r)
+		r.Close()
+		if err == ErrAborted {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("error reading %s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// expandInput turns an --input in to the names of the files to read, in order
+func expandInput(input string) ([]string, error) {
+	if input == InputStdin {
+		return []string{InputStdin}, nil
+	}
+	if !strings.HasPrefix(input, inputFilePrefix) {
+		return nil, fmt.Errorf("input %s not recognized, use file:<path> or %s for STDIN", input, InputStdin)
+	}
+	pattern := strings.TrimPrefix(input, inputFilePrefix)
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no files match %s", pattern)
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// openInput opens a file, or STDIN, decompressing it if it's gzipped
+func openInput(name string) (io.ReadCloser, error) {
+	var f io.ReadCloser = os.Stdin
+	if name != InputStdin {
+		var err error
+		f, err = os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	br := bufio.NewReader(f)
+	// sniff the gzip magic number rather than trusting the file name, so
+	// gzipped STDIN works too
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &readCloser{Reader: gz, closers: []io.Closer{gz, f}}, nil
+	}
+	return &readCloser{Reader: br, closers: []io.Closer{f}}, nil
+}
+
+// readCloser closes all the layers of a wrapped reader
+type readCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *readCloser) Close() error {
+	var err error
+	for _, c := range r.closers {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// replay sends the lines in r to the output, pacing them at --input_rate
+// lines per second if it's set
+func (c *CLI) replay(r io.Reader) error {
+	batchLines := replayBatchLines
+	var tick <-chan time.Time
+	if c.Options.InputRate > 0 {
+		batchLines = int(float64(c.Options.InputRate) * replayTick.Seconds())
+		if batchLines < 1 {
+			batchLines = 1
+		}
+		ticker := time.NewTicker(time.Duration(float64(time.Second) * float64(batchLines) / float64(c.Options.InputRate)))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	br := bufio.NewReader(r)
+	var batch strings.Builder
+	var n int
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			batch.WriteString(line)
+			if !strings.HasSuffix(line, "\n") {
+				batch.WriteString("\n")
+			}
+			n++
+		}
+		if n >= batchLines || (err != nil && n > 0) {
+			if tick != nil {
+				select {
+				case <-tick:
+				case <-c.Abort:
+					return ErrAborted
+				}
+			} else {
+				select {
+				case <-c.Abort:
+					return ErrAborted
+				default:
+				}
+			}
+			c.output.Write(batch.String())
+			batch.Reset()
+			n = 0
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
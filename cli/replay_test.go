@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReplay(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "mysql-slowquery.log")
+	if err := os.WriteFile(plain, []byte("line 1\nline 2\nline 3"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	zipped := filepath.Join(dir, "mysql-slowquery.log.1.gz")
+	f, err := os.Create(zipped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	gz.Write([]byte("line 4\nline 5\n"))
+	gz.Close()
+	f.Close()
+
+	names, err := expandInput("file:" + filepath.Join(dir, "mysql-slowquery.log*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{plain, zipped}) {
+		t.Errorf("unexpected inputs %v", names)
+	}
+
+	out := &capturePublisher{}
+	c := &CLI{Options: &Options{}, output: out}
+	for _, name := range names {
+		r, err := openInput(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.replay(r); err != nil {
+			t.Fatal(err)
+		}
+		r.Close()
+	}
+	expected := "line 1\nline 2\nline 3\nline 4\nline 5\n"
+	if got := strings.Join(out.blobs, ""); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestReplayRate(t *testing.T) {
+	out := &capturePublisher{}
+	c := &CLI{Options: &Options{InputRate: 20}, output: out}
+	if err := c.replay(strings.NewReader("1\n2\n3\n4\n5\n6\n")); err != nil {
+		t.Fatal(err)
+	}
+	// 20 lines per second is 2 lines per 100ms tick
+	if len(out.blobs) != 3 || out.blobs[0] != "1\n2\n" {
+		t.Errorf("expected 3 batches of 2 lines, got %q", out.blobs)
+	}
+}
+
+func TestExpandInputErrors(t *testing.T) {
+	for _, input := range []string{"s3://bucket/log", "file:/nonexistent/*.log"} {
+		if _, err := expandInput(input); err == nil {
+			t.Errorf("expected an error for input %s", input)
+		}
+	}
+}
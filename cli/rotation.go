@@ -0,0 +1,190 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/sirupsen/logrus"
+)
+
+// rotation describes how RDS rotates a log file, and so how Stream follows it
+// from one file to the next.
+type rotation interface {
+	// startFile returns the file to start tailing, given the most recently
+	// written file matching --log_file
+	startFile(c *CLI, latest LogFile) string
+	// next returns the position to read from after reading resp at sPos. It
+	// waits before returning if there's nothing more to read yet.
+	next(c *CLI, sPos StreamPos, resp *rds.DownloadDBLogFilePortionOutput) (StreamPos, error)
+}
+
+// rolloverRotation is a rotation that can tell from a portion of the log
+// that the log rolled over before it, so Stream can read the rest of the old
+// log before writing the portion
+type rolloverRotation interface {
+	// rollover reads what's left of the log before resp, if resp comes from
+	// after a rollover, and returns whether it did
+	rollover(c *CLI, sPos StreamPos, resp *rds.DownloadDBLogFilePortionOutput) bool
+}
+
+// hourlyRotation is for logs like the mysql slow query log, where the active
+// file keeps its name and is renamed to <name>.N every hour. The marker RDS
+// returns is "hour:offset", and wraps to "0" when the hour rolls over.
+type hourlyRotation struct{}
+
+func (hourlyRotation) startFile(c *CLI, latest LogFile) string {
+	return latest.LogFileName
+}
+
+// rollover catches up on the old hour when resp is already the new hour's
+func (hourlyRotation) rollover(c *CLI, sPos StreamPos, resp *rds.DownloadDBLogFilePortionOutput) bool {
+	hour, ok := rolledOverHour(sPos, resp)
+	if !ok {
+		return false
+	}
+	c.catchUp(sPos, fmt.Sprintf("%s.%s", sPos.logFile.LogFileName, hour))
+	// the old hour's last line won't be finished by the new hour's
+	c.output.Flush()
+	return true
+}
+
+func (hourlyRotation) next(c *CLI, sPos StreamPos, resp *rds.DownloadDBLogFilePortionOutput) (StreamPos, error) {
+	next := c.advance(sPos, resp)
+	if _, ok := rolledOverHour(sPos, resp); ok {
+		// rollover has already read the rest of the old hour
+		return next, nil
+	}
+	if hour, _, ok := splitMarker(sPos.marker); ok && rotated(sPos, next) {
+		// the hour we were reading has been renamed to <file>.<hour>, so
+		// read the rest of it before starting on the new hour
+		c.catchUp(sPos, fmt.Sprintf("%s.%s", sPos.logFile.LogFileName, hour))
+	}
+	return next, nil
+}
+
+// rolledOverHour returns the hour sPos was in, if resp is from a later hour
+func rolledOverHour(sPos StreamPos, resp *rds.DownloadDBLogFilePortionOutput) (string, bool) {
+	hour, _, ok := splitMarker(sPos.marker)
+	if !ok {
+		return "", false
+	}
+	newHour, _, ok := splitMarker(aws.StringValue(resp.Marker))
+	return hour, ok && newHour != hour
+}
+
+// renamingRotation is a rotation that renames the active file when it
+// rotates it, so the log's files are listed by what both names have in common
+type renamingRotation interface {
+	// rotatedFile returns the name the file called name is rotated to
+	rotatedFile(name string) string
+}
+
+// sizeRotation is for logs like the MariaDB audit plugin's, where the active
+// file keeps its name and is renamed when it grows too big or the server
+// restarts.
+type sizeRotation struct {
+	// rotatedName returns the name the file called name is rotated to, for
+	// logs that aren't rotated to <name>.1
+	rotatedName func(name string) string
+}
+
+func (r sizeRotation) rotatedFile(name string) string {
+	if r.rotatedName != nil {
+		return r.rotatedName(name)
+	}
+	return name + ".1"
+}
+
+func (sizeRotation) startFile(c *CLI, latest LogFile) string {
+	// we always want the first logfile, which may not show up as the latest
+	// file if rdslogs started mid-rotation
+	return c.Options.LogFile
+}
+
+func (r sizeRotation) next(c *CLI, sPos StreamPos, resp *rds.DownloadDBLogFilePortionOutput) (StreamPos, error) {
+	// If no data is being returned, the log may have been rotated, or maybe
+	// the db is just very quiet and nothing is being logged. We'll have to
+	// inspect the log sizes to be sure
+
+	// If we reset our marker, asked for logs, and got an empty marker back,
+	// we don't have anything to do but wait
+	if sPos.marker == "0" && (resp.Marker != nil && *resp.Marker == "") {
+		c.waitFor(time.Second * 5)
+		return sPos, nil
+	}
+
+	// Two scenarios can occur during log rotation, depending on timing
+	// - the file doesn't exist because rotation is ongoing, in which case
+	// AdditionalDataPending will be false, marker will be "", and LogFileData will be nil
+	// - the file exists but it's been rotated, meaning our marker is wrong and doesn't point
+	// at a valid position - in this case, RDS will return the marker back to us with ""
+	// for logfile data
+	// In either scenario, we need to check whether the file has been
+	// rotated. When we're sure it has, reset the marker
+	if (resp.Marker != nil && resp.LogFileData != nil && sPos.marker == *resp.Marker) ||
+		!aws.BoolValue(resp.AdditionalDataPending) && resp.LogFileData == nil {
+		rotated, err := c.detectRotation(sPos)
+		if err != nil {
+			return sPos, err
+		}
+		if rotated {
+			// what we hadn't read of the old file is now in the rotated file
+			c.catchUp(sPos, r.rotatedFile(sPos.logFile.LogFileName))
+			logrus.WithField("file", sPos.logFile.LogFileName).
+				Info("log rotated, resetting marker to 0")
+			sPos.marker = "0"
+			return sPos, nil
+		}
+	}
+	return c.advance(sPos, resp), nil
+}
+
+// newestFileRotation is for logs like postgres', where each rotation starts
+// a new file named for the time it was created (error/postgresql.log.YYYY-MM-DD-HH).
+// We start with the most recently written one, and follow them in order from
+// there.
+type newestFileRotation struct{}
+
+func (newestFileRotation) startFile(c *CLI, latest LogFile) string {
+	return latest.LogFileName
+}
+
+func (newestFileRotation) next(c *CLI, sPos StreamPos, resp *rds.DownloadDBLogFilePortionOutput) (StreamPos, error) {
+	if !aws.BoolValue(resp.AdditionalDataPending) || (resp.Marker != nil && *resp.Marker == "0") {
+		// If that's all we've got for now, see if there's a newer file to
+		// start tailing. We've read everything in this one, so start the
+		// next one from the beginning.
+		nextFile, gone, err := c.nextLogFile(sPos.logFile.LogFileName)
+		if err != nil {
+			return sPos, err
+		}
+		if gone {
+			c.reportGap(sPos, "log file removed before it was read")
+		}
+		if nextFile.LogFileName != "" {
+			logrus.WithFields(logrus.Fields{
+				"oldFile": sPos.logFile.LogFileName,
+				"newFile": nextFile.LogFileName}).Info("Found newer file")
+			return StreamPos{logFile: LogFile{LogFileName: nextFile.LogFileName}, marker: "0"}, nil
+		}
+	}
+	return c.advance(sPos, resp), nil
+}
+
+// advance moves sPos past what was read in resp, waiting first if there's
+// nothing more to read for now
+func (c *CLI) advance(sPos StreamPos, resp *rds.DownloadDBLogFilePortionOutput) StreamPos {
+	if !aws.BoolValue(resp.AdditionalDataPending) || (resp.Marker != nil && *resp.Marker == "0") {
+		// Wait for a few seconds and try again.
+		c.waitFor(5 * time.Second)
+	}
+	newMarker := c.getNextMarker(sPos, resp)
+	logrus.WithFields(logrus.Fields{
+		"prevMarker": sPos.marker,
+		"newMarker":  newMarker,
+		"file":       sPos.logFile.LogFileName}).Info("Got new marker")
+	sPos.marker = newMarker
+	return sPos
+}
@@ -0,0 +1,280 @@
+package cli
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rds"
+)
+
+func TestSizeRotationResetsMarker(t *testing.T) {
+	fake := &FakeRDS{
+		logFiles: []*rds.DescribeDBLogFilesDetails{{
+			LogFileName: aws.String("audit/server_audit.log"),
+			LastWritten: aws.Int64(1000),
+			Size:        aws.Int64(100),
+		}},
+	}
+	var caughtUp []string
+	fake.portions = func(in *rds.DownloadDBLogFilePortionInput) (*rds.DownloadDBLogFilePortionOutput, error) {
+		caughtUp = append(caughtUp, *in.LogFileName+" "+*in.Marker)
+		return &rds.DownloadDBLogFilePortionOutput{
+			AdditionalDataPending: aws.Bool(false),
+			LogFileData:           aws.String("the end of the old file\n"),
+			Marker:                aws.String("1:5024"),
+		}, nil
+	}
+	out := &capturePublisher{}
+	c := &CLI{
+		Options: &Options{LogFile: "audit/server_audit.log"},
+		RDS:     fake,
+		output:  out,
+	}
+	sPos := StreamPos{
+		logFile: LogFile{LogFileName: "audit/server_audit.log"},
+		marker:  "1:5000",
+	}
+	// the file's been rotated out from under us, so RDS hands our marker back
+	resp := &rds.DownloadDBLogFilePortionOutput{
+		AdditionalDataPending: aws.Bool(false),
+		LogFileData:           aws.String(""),
+		Marker:                aws.String("1:5000"),
+	}
+	next, err := sizeRotation{}.next(c, sPos, resp)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if next.marker != "0" {
+		t.Errorf("expected marker to be reset to 0, got %s", next.marker)
+	}
+	// and the rest of the old file is read from where we'd got to
+	if len(caughtUp) != 1 || caughtUp[0] != "audit/server_audit.log.1 1:5000" {
+		t.Errorf("expected to catch up on the rotated file, read %q", caughtUp)
+	}
+	if len(out.blobs) != 1 || out.blobs[0] != "the end of the old file\n" {
+		t.Errorf("unexpected output %q", out.blobs)
+	}
+}
+
+func TestSQLServerAgentRotation(t *testing.T) {
+	src, err := lookupLogSource(DBTypeSQLServer, LogTypeAgent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// RDS rotates SQLAGENT.OUT to SQLAGENT.1
+	fake := &FakeRDS{
+		logFiles: []*rds.DescribeDBLogFilesDetails{{
+			LogFileName: aws.String("log/SQLAGENT.OUT"),
+			LastWritten: aws.Int64(1000),
+			Size:        aws.Int64(6000),
+		}},
+	}
+	var caughtUp []string
+	fake.portions = func(in *rds.DownloadDBLogFilePortionInput) (*rds.DownloadDBLogFilePortionOutput, error) {
+		caughtUp = append(caughtUp, *in.LogFileName+" "+*in.Marker)
+		return &rds.DownloadDBLogFilePortionOutput{
+			AdditionalDataPending: aws.Bool(false),
+			LogFileData:           aws.String("the end of the old file\n"),
+			Marker:                aws.String("1:5024"),
+		}, nil
+	}
+	c := &CLI{
+		Options: &Options{DBType: DBTypeSQLServer, LogType: LogTypeAgent, LogFile: "log/SQLAGENT.OUT"},
+		RDS:     fake,
+		output:  &capturePublisher{},
+	}
+	sPos := StreamPos{logFile: LogFile{LogFileName: "log/SQLAGENT.OUT"}, marker: "1:5000"}
+	resp := &rds.DownloadDBLogFilePortionOutput{
+		AdditionalDataPending: aws.Bool(false),
+		LogFileData:           aws.String(""),
+		Marker:                aws.String("1:5000"),
+	}
+	if err := c.logInventory().refresh(); err != nil {
+		t.Fatal(err)
+	}
+	// then the agent log is rotated, which the listing has to find
+	fake.logFiles = append(fake.logFiles, &rds.DescribeDBLogFilesDetails{
+		LogFileName: aws.String("log/SQLAGENT.1"),
+		LastWritten: aws.Int64(2000),
+		Size:        aws.Int64(5024),
+	})
+	next, err := src.rotation.next(c, sPos, resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.marker != "0" {
+		t.Errorf("expected the marker to be reset to 0, got %s", next.marker)
+	}
+	if len(caughtUp) != 1 || caughtUp[0] != "log/SQLAGENT.1 1:5000" {
+		t.Errorf("expected to catch up on log/SQLAGENT.1, read %q", caughtUp)
+	}
+}
+
+func TestNewestFileRotationFollowsNewFile(t *testing.T) {
+	fake := &FakeRDS{
+		logFiles: []*rds.DescribeDBLogFilesDetails{{
+			LogFileName: aws.String("error/postgresql.log.2022-05-17-10"),
+			LastWritten: aws.Int64(1000),
+			Size:        aws.Int64(100),
+		}, {
+			LogFileName: aws.String("error/postgresql.log.2022-05-17-11"),
+			LastWritten: aws.Int64(2000),
+			Size:        aws.Int64(10),
+		}},
+	}
+	c := &CLI{
+		Options: &Options{LogFile: "error/postgresql.log"},
+		RDS:     fake,
+	}
+	sPos := StreamPos{
+		logFile: LogFile{LogFileName: "error/postgresql.log.2022-05-17-10"},
+		marker:  "10:100",
+	}
+	resp := &rds.DownloadDBLogFilePortionOutput{
+		AdditionalDataPending: aws.Bool(false),
+		Marker:                aws.String("10:100"),
+	}
+	next, err := newestFileRotation{}.next(c, sPos, resp)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if next.logFile.LogFileName != "error/postgresql.log.2022-05-17-11" || next.marker != "0" {
+		t.Errorf("expected to start reading the newer file, got %s at marker %q",
+			next.logFile.LogFileName, next.marker)
+	}
+}
+
+func TestNewestFileRotationDoesNotSkipFiles(t *testing.T) {
+	fake := &FakeRDS{}
+	for i, hour := range []string{"10", "11", "12"} {
+		fake.logFiles = append(fake.logFiles, &rds.DescribeDBLogFilesDetails{
+			LogFileName: aws.String("error/postgresql.log.2022-05-17-" + hour),
+			LastWritten: aws.Int64(int64(i+1) * 1000),
+			Size:        aws.Int64(100),
+		})
+	}
+	c := &CLI{
+		Options: &Options{LogFile: "error/postgresql.log"},
+		RDS:     fake,
+	}
+	sPos := StreamPos{
+		logFile: LogFile{LogFileName: "error/postgresql.log.2022-05-17-10"},
+		marker:  "10:100",
+	}
+	resp := &rds.DownloadDBLogFilePortionOutput{
+		AdditionalDataPending: aws.Bool(false),
+		Marker:                aws.String("10:100"),
+	}
+	next, err := newestFileRotation{}.next(c, sPos, resp)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	// we fell behind, but read the 11 o'clock file before the 12 o'clock one
+	if next.logFile.LogFileName != "error/postgresql.log.2022-05-17-11" {
+		t.Errorf("expected the next file in order, got %s", next.logFile.LogFileName)
+	}
+}
+
+func TestNewestFileRotationReportsRemovedFile(t *testing.T) {
+	fake := &FakeRDS{
+		logFiles: []*rds.DescribeDBLogFilesDetails{{
+			LogFileName: aws.String("error/postgresql.log.2022-05-17-12"),
+			LastWritten: aws.Int64(3000),
+			Size:        aws.Int64(100),
+		}},
+	}
+	out := &capturePublisher{}
+	c := &CLI{
+		Options: &Options{LogFile: "error/postgresql.log"},
+		RDS:     fake,
+		output:  out,
+	}
+	sPos := StreamPos{
+		logFile: LogFile{LogFileName: "error/postgresql.log.2022-05-17-10"},
+		marker:  "10:100",
+	}
+	resp := &rds.DownloadDBLogFilePortionOutput{
+		AdditionalDataPending: aws.Bool(false),
+		Marker:                aws.String("10:100"),
+	}
+	next, err := newestFileRotation{}.next(c, sPos, resp)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if next.logFile.LogFileName != "error/postgresql.log.2022-05-17-12" {
+		t.Errorf("expected the oldest remaining file, got %s", next.logFile.LogFileName)
+	}
+	if len(out.gaps) != 1 || out.gaps[0].LogFile != "error/postgresql.log.2022-05-17-10" {
+		t.Errorf("expected a gap for the removed file, got %+v", out.gaps)
+	}
+}
+
+func TestHourlyRotationCatchesUp(t *testing.T) {
+	fake := &FakeRDS{
+		logFiles: []*rds.DescribeDBLogFilesDetails{{
+			LogFileName: aws.String("slowquery/mysql-slowquery.log.12"),
+			LastWritten: aws.Int64(1000),
+			Size:        aws.Int64(2000),
+		}},
+	}
+	var caughtUp []string
+	fake.portions = func(in *rds.DownloadDBLogFilePortionInput) (*rds.DownloadDBLogFilePortionOutput, error) {
+		caughtUp = append(caughtUp, *in.LogFileName+" "+*in.Marker)
+		if *in.Marker == "12:1000" {
+			return &rds.DownloadDBLogFilePortionOutput{
+				AdditionalDataPending: aws.Bool(true),
+				LogFileData:           aws.String("some\n"),
+				Marker:                aws.String("12:1500"),
+			}, nil
+		}
+		return &rds.DownloadDBLogFilePortionOutput{
+			AdditionalDataPending: aws.Bool(false),
+			LogFileData:           aws.String("more\n"),
+			Marker:                aws.String("12:2000"),
+		}, nil
+	}
+	out := &capturePublisher{}
+	c := &CLI{
+		Options: &Options{LogFile: "slowquery/mysql-slowquery.log"},
+		RDS:     fake,
+		output:  out,
+	}
+	sPos := StreamPos{
+		logFile: LogFile{LogFileName: "slowquery/mysql-slowquery.log"},
+		marker:  "12:1000",
+	}
+	// the hour's rolled over, and we're reading the new one. Stream reads
+	// the rest of the old hour before writing the new hour's portion.
+	resp := &rds.DownloadDBLogFilePortionOutput{
+		AdditionalDataPending: aws.Bool(true),
+		LogFileData:           aws.String("new hour\n"),
+		Marker:                aws.String("13:9"),
+	}
+	if !(hourlyRotation{}).rollover(c, sPos, resp) {
+		t.Fatal("expected the rollover to be noticed")
+	}
+	out.Write(*resp.LogFileData)
+	next, err := hourlyRotation{}.next(c, sPos, resp)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if next.marker != "13:9" {
+		t.Errorf("expected to carry on in the new hour, got marker %s", next.marker)
+	}
+	expected := []string{"slowquery/mysql-slowquery.log.12 12:1000", "slowquery/mysql-slowquery.log.12 12:1500"}
+	if !reflect.DeepEqual(caughtUp, expected) {
+		t.Errorf("expected to read %q, read %q", expected, caughtUp)
+	}
+	if len(out.gaps) != 0 {
+		t.Errorf("expected no gaps, got %+v", out.gaps)
+	}
+	// the old hour comes out before the new one, with its last line flushed
+	// first
+	if expected := []string{"some\n", "more\n", "new hour\n"}; !reflect.DeepEqual(out.blobs, expected) {
+		t.Errorf("expected output %q, got %q", expected, out.blobs)
+	}
+	if expected := []int{2}; !reflect.DeepEqual(out.flushes, expected) {
+		t.Errorf("expected a flush after the old hour, got flushes at %v", out.flushes)
+	}
+}
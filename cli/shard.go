@@ -0,0 +1,495 @@
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/sirupsen/logrus"
+)
+
+// workerLockPrefix marks the lock_id of a worker's heartbeat in a DynamoDB
+// pool table, keeping them apart from the stream leases
+const workerLockPrefix = "rdslogs-worker/"
+
+// shardStream is one of the logs a pool of workers splits between them
+type shardStream struct {
+	identifier string
+	logType    string
+	// opts are the stream's own options, for streams from a structured
+	// config, or nil for a --stream
+	opts *Options
+}
+
+// name identifies the stream in the pool, and names its lock and checkpoint
+func (s shardStream) name() string {
+	if s.logType == "" {
+		return s.identifier
+	}
+	return s.identifier + "-" + s.logType
+}
+
+// parseStreams turns the --stream options in to the streams to split up
+func parseStreams(options []string) ([]shardStream, error) {
+	var streams []shardStream
+	seen := make(map[string]bool)
+	for _, option := range options {
+		parts := strings.SplitN(option, ":", 2)
+		s := shardStream{identifier: parts[0]}
+		if len(parts) == 2 {
+			s.logType = parts[1]
+		}
+		if s.identifier == "" {
+			return nil, fmt.Errorf("stream %q has no instance identifier", option)
+		}
+		if seen[s.name()] {
+			return nil, fmt.Errorf("stream %s given more than once", s.name())
+		}
+		seen[s.name()] = true
+		streams = append(streams, s)
+	}
+	if len(streams) == 0 {
+		return nil, fmt.Errorf("--shard needs at least one --stream to tail")
+	}
+	return streams, nil
+}
+
+// ValidateShard checks --shard names a way to coordinate workers that we know
+func ValidateShard(option string) error {
+	return validateCoordination("shard", option, "file:<dir>")
+}
+
+// owner picks which of workers should tail stream, by rendezvous hashing:
+// each stream goes to the worker it hashes highest with. When a worker
+// joins or leaves, only the streams it gains or loses move.
+func owner(stream string, workers []string) string {
+	var best string
+	var bestHash uint64
+	for _, w := range workers {
+		// FNV spreads short names like ours poorly, so use sha256
+		sum256 := sha256.Sum256([]byte(w + "\x00" + stream))
+		if sum := binary.BigEndian.Uint64(sum256[:8]); best == "" || sum > bestHash || (sum == bestHash && w < best) {
+			best, bestHash = w, sum
+		}
+	}
+	return best
+}
+
+// pool tracks the workers sharing out the streams
+type pool interface {
+	// heartbeat records that this worker is still alive
+	heartbeat() error
+	// workers lists the workers that are alive
+	workers() ([]string, error)
+	// leave takes this worker out of the pool
+	leave() error
+	// leaderElection is the --leader_election a worker takes a stream's lease
+	// with, so no two workers tail it at once while the streams move
+	leaderElection(s shardStream) string
+}
+
+// newPool creates the pool --shard asks for
+func (c *CLI) newPool() (pool, error) {
+	option := c.Options.Tail.Shard
+	switch {
+	case strings.HasPrefix(option, leaderFilePrefix):
+		p := &filePool{c: c, dir: strings.TrimPrefix(option, leaderFilePrefix)}
+		for _, dir := range []string{p.workersDir(), p.streamsDir()} {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return nil, err
+			}
+		}
+		return p, nil
+	case strings.HasPrefix(option, leaderDynamoDBPrefix):
+		return &dynamoDBPool{c: c, table: strings.TrimPrefix(option, leaderDynamoDBPrefix)}, nil
+	}
+	return nil, ValidateShard(option)
+}
+
+// streams returns the streams to split up: the structured config's if there
+// is one, or else the --stream options
+func (opts *Options) streams() ([]shardStream, error) {
+	if opts.StreamConfig != nil {
+		return opts.StreamConfig.streams, nil
+	}
+	return parseStreams(opts.Tail.Streams)
+}
+
+// runningStream is a stream this worker is tailing
+type runningStream struct {
+	s shardStream
+	c *CLI
+	// the stream's options as the config gave them, before the worker's
+	// leader and checkpoint settings and the engine's defaults were filled in
+	started Options
+	abort   chan bool
+	done    chan struct{}
+	err     error
+}
+
+func (r *runningStream) finished() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *runningStream) stop() {
+	close(r.abort)
+	<-r.done
+}
+
+// RunShard runs this process as one of a pool of workers which split the
+// --stream logs between them, each tailing its share. Every few seconds it
+// checks which workers are alive and hands the streams out again, so streams
+// move when workers join or leave. Each stream is tailed under a lease on it,
+// like RunAsLeader, and picks up from its own checkpoint in --checkpoint_dir.
+func (c *CLI) RunShard() error {
+	p, err := c.newPool()
+	if err != nil {
+		return err
+	}
+	logrus.WithField("id", c.leaderID()).Info("Joining the worker pool")
+	return c.runStreams(p)
+}
+
+// RunStreams tails all of a structured config's streams in this process,
+// each with a CLI of its own. A stream that fails is started again.
+func (c *CLI) RunStreams() error {
+	return c.runStreams(&localPool{c: c})
+}
+
+// runStreams tails the streams p hands this worker, until aborted
+func (c *CLI) runStreams(p pool) error {
+	streams, err := c.Options.streams()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := p.leave(); err != nil {
+			logrus.WithError(err).Warn("unable to leave the worker pool")
+		}
+	}()
+
+	reloads := make(chan *Options)
+	c.reloadMu.Lock()
+	c.reloads = reloads
+	c.reloadMu.Unlock()
+	defer func() {
+		c.reloadMu.Lock()
+		c.reloads = nil
+		c.reloadMu.Unlock()
+	}()
+
+	running := make(map[string]*runningStream)
+	defer func() {
+		for _, r := range running {
+			r.stop()
+		}
+	}()
+
+	logrus.WithField("streams", len(streams)).Info("Tailing streams")
+	for {
+		if err := p.heartbeat(); err != nil {
+			logrus.WithError(err).Warn("unable to record worker heartbeat")
+		}
+		workers, err := p.workers()
+		if err != nil {
+			logrus.WithError(err).Warn("unable to list workers")
+		} else {
+			c.rebalance(p, streams, workers, running)
+		}
+		select {
+		case <-c.Abort:
+			return ErrAborted
+		case opts := <-reloads:
+			if reloaded, err := opts.streams(); err != nil {
+				logrus.WithError(err).Error("keeping the old streams")
+			} else {
+				streams = reloaded
+			}
+			for _, r := range running {
+				r.reload(streams, opts)
+			}
+		case <-time.After(c.Options.Tail.LeaderLease / 3):
+		}
+	}
+}
+
+// reload applies the reloadable settings in opts, or in the stream's entry in
+// the reloaded streams, to a running stream
+func (r *runningStream) reload(streams []shardStream, opts *Options) {
+	if r.s.opts == nil {
+		streamOpts := *opts
+		streamOpts.AddFields = streamFields(r.s, opts.AddFields)
+		r.c.applyReload(&streamOpts)
+		return
+	}
+	for _, s := range streams {
+		if s.name() != r.s.name() || s.opts == nil {
+			continue
+		}
+		for _, name := range restartRequired(&r.started, s.opts) {
+			logrus.WithFields(logrus.Fields{
+				"stream": s.name(),
+				"option": name,
+			}).Error("option changed, but it can't be changed without restarting rdslogs; keeping the old value")
+		}
+		streamOpts := *s.opts
+		streamOpts.AddFields = streamFields(s, s.opts.AddFields)
+		r.c.applyReload(&streamOpts)
+	}
+}
+
+// rebalance starts the streams that are ours and stops the ones that aren't
+// any more, given the workers that are alive
+func (c *CLI) rebalance(p pool, streams []shardStream, workers []string, running map[string]*runningStream) {
+	self := c.leaderID()
+	found := false
+	for _, w := range workers {
+		found = found || w == self
+	}
+	if !found {
+		// our heartbeat hasn't shown up yet
+		workers = append(workers, self)
+	}
+	for _, s := range streams {
+		name := s.name()
+		r, ok := running[name]
+		if ok && r.finished() {
+			if r.err != nil {
+				logrus.WithError(ExplainCredentialsError(r.err)).WithField("stream", name).Warn("stream stopped")
+			}
+			delete(running, name)
+			ok = false
+		}
+		mine := owner(name, workers) == self
+		switch {
+		case mine && !ok:
+			logrus.WithField("stream", name).Info("Taking stream")
+			running[name] = c.startStream(p, s)
+		case !mine && ok:
+			logrus.WithField("stream", name).Info("Handing stream to another worker")
+			r.stop()
+			delete(running, name)
+		}
+	}
+	// and stop the streams that have been taken out of the list
+	listed := make(map[string]bool, len(streams))
+	for _, s := range streams {
+		listed[s.name()] = true
+	}
+	for name, r := range running {
+		if !listed[name] {
+			logrus.WithField("stream", name).Info("Stopping stream that's no longer listed")
+			r.stop()
+			delete(running, name)
+		}
+	}
+}
+
+// startStream tails s in the background, with a CLI of its own
+func (c *CLI) startStream(p pool, s shardStream) *runningStream {
+	r := &runningStream{s: s, abort: make(chan bool), done: make(chan struct{})}
+	sc := c.streamCLI(p, s, r.abort)
+	r.c = sc
+	if s.opts != nil {
+		r.started = *s.opts
+	}
+	go func() {
+		defer close(r.done)
+		if err := sc.ResolveEngineDefaults(); err != nil {
+			r.err = err
+			return
+		}
+		stream := sc.Stream
+		if sc.Options.Source == SourceCloudWatch {
+			stream = sc.StreamCloudWatch
+		}
+		if sc.Options.Tail.LeaderElection == "" {
+			r.err = stream()
+			return
+		}
+		r.err = sc.RunAsLeader(stream)
+	}()
+	return r
+}
+
+// streamCLI makes a CLI for tailing one stream, with the stream's own options
+// if it has them, or else the options the worker was given
+func (c *CLI) streamCLI(p pool, s shardStream, abort chan bool) *CLI {
+	c.reloadMu.Lock()
+	opts := *c.Options
+	if s.opts != nil {
+		opts = *s.opts
+	} else {
+		opts.InstanceIdentifier = s.identifier
+		if s.logType != "" {
+			opts.LogType = s.logType
+		}
+		// each instance's log file follows from its own engine
+		opts.LogFile = ""
+	}
+	opts.AddFields = streamFields(s, opts.AddFields)
+	c.reloadMu.Unlock()
+	opts.Tail.CheckpointFile = ""
+	if c.Options.Tail.CheckpointDir != "" {
+		opts.Tail.CheckpointFile = filepath.Join(c.Options.Tail.CheckpointDir, s.name()+".json")
+	}
+	opts.Tail.LeaderElection = p.leaderElection(s)
+	opts.Tail.LeaderID = c.leaderID()
+	sc := &CLI{
+		Options:        &opts,
+		RDS:            c.RDS,
+		CloudWatchLogs: c.CloudWatchLogs,
+		DynamoDB:       c.DynamoDB,
+		StreamClients:  c.StreamClients,
+		Abort:          abort,
+		fakeNower:      c.fakeNower,
+	}
+	// a stream in another region or account needs clients of its own
+	differs := opts.Region != c.Options.Region || RoleKey(&opts) != RoleKey(c.Options)
+	if differs && c.StreamClients != nil {
+		sc.RDS, sc.CloudWatchLogs = c.StreamClients(&opts)
+	}
+	return sc
+}
+
+// streamFields are the fields to add to a stream's events: fields, and the
+// instance, to tell the streams' events apart when they share a dataset
+func streamFields(s shardStream, fields map[string]string) map[string]string {
+	streamFields := map[string]string{"rds_instance": s.identifier}
+	for k, v := range fields {
+		streamFields[k] = v
+	}
+	return streamFields
+}
+
+// localPool is a pool of just this process, which tails every stream itself
+// without taking leases on them
+type localPool struct {
+	c *CLI
+}
+
+func (p *localPool) heartbeat() error                    { return nil }
+func (p *localPool) workers() ([]string, error)          { return []string{p.c.leaderID()}, nil }
+func (p *localPool) leave() error                        { return nil }
+func (p *localPool) leaderElection(s shardStream) string { return "" }
+
+// filePool keeps track of workers with heartbeat files in a directory on
+// storage the workers share, and leases streams with file locks
+type filePool struct {
+	c   *CLI
+	dir string
+}
+
+func (p *filePool) workersDir() string { return filepath.Join(p.dir, "workers") }
+func (p *filePool) streamsDir() string { return filepath.Join(p.dir, "streams") }
+
+func (p *filePool) heartbeat() error {
+	path := filepath.Join(p.workersDir(), p.c.leaderID())
+	if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+		return err
+	}
+	// use our clock rather than the file server's, as workers() does
+	now := p.c.now()
+	return os.Chtimes(path, now, now)
+}
+
+func (p *filePool) workers() ([]string, error) {
+	entries, err := ioutil.ReadDir(p.workersDir())
+	if err != nil {
+		return nil, err
+	}
+	var workers []string
+	for _, e := range entries {
+		if p.c.now().Sub(e.ModTime()) < p.c.Options.Tail.LeaderLease {
+			workers = append(workers, e.Name())
+		}
+	}
+	return workers, nil
+}
+
+func (p *filePool) leave() error {
+	return os.Remove(filepath.Join(p.workersDir(), p.c.leaderID()))
+}
+
+func (p *filePool) leaderElection(s shardStream) string {
+	return leaderFilePrefix + filepath.Join(p.streamsDir(), s.name()+".lock")
+}
+
+// dynamoDBPool keeps track of workers with heartbeat items in the DynamoDB
+// table used for leader election, and leases streams in the same table
+type dynamoDBPool struct {
+	c     *CLI
+	table string
+}
+
+func (p *dynamoDBPool) heartbeat() error {
+	expires := p.c.now().Add(p.c.Options.Tail.LeaderLease)
+	_, err := p.c.DynamoDB.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(p.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"lock_id": {S: aws.String(workerLockPrefix + p.c.leaderID())},
+			"holder":  {S: aws.String(p.c.leaderID())},
+			"expires": {N: aws.String(strconv.FormatInt(expires.UnixNano()/int64(time.Millisecond), 10))},
+		},
+	})
+	return err
+}
+
+func (p *dynamoDBPool) workers() ([]string, error) {
+	now := p.c.now().UnixNano() / int64(time.Millisecond)
+	var workers []string
+	var startKey map[string]*dynamodb.AttributeValue
+	for {
+		out, err := p.c.DynamoDB.Scan(&dynamodb.ScanInput{
+			TableName:        aws.String(p.table),
+			FilterExpression: aws.String("begins_with(lock_id, :prefix) AND expires >= :now"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":prefix": {S: aws.String(workerLockPrefix)},
+				":now":    {N: aws.String(strconv.FormatInt(now, 10))},
+			},
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range out.Items {
+			if holder := item["holder"]; holder != nil {
+				workers = append(workers, aws.StringValue(holder.S))
+			}
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			break
+		}
+		startKey = out.LastEvaluatedKey
+	}
+	sort.Strings(workers)
+	return workers, nil
+}
+
+func (p *dynamoDBPool) leave() error {
+	_, err := p.c.DynamoDB.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(p.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"lock_id": {S: aws.String(workerLockPrefix + p.c.leaderID())},
+		},
+	})
+	return err
+}
+
+func (p *dynamoDBPool) leaderElection(s shardStream) string {
+	return leaderDynamoDBPrefix + p.table
+}
@@ -0,0 +1,203 @@
+package cli
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/aws/aws-sdk-go/service/rds/rdsiface"
+)
+
+func TestParseStreams(t *testing.T) {
+	streams, err := parseStreams([]string{"db-1", "db-2:audit"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []shardStream{{identifier: "db-1"}, {identifier: "db-2", logType: "audit"}}
+	if !reflect.DeepEqual(streams, expected) {
+		t.Errorf("expected %v, got %v", expected, streams)
+	}
+	for _, options := range [][]string{nil, {":query"}, {"db-1", "db-1"}} {
+		if _, err := parseStreams(options); err == nil {
+			t.Errorf("%v: expected an error", options)
+		}
+	}
+}
+
+func TestOwner(t *testing.T) {
+	var streams []string
+	for i := 0; i < 300; i++ {
+		streams = append(streams, fmt.Sprintf("db-%d", i))
+	}
+	workers := []string{"a", "b", "c"}
+	before := make(map[string]string)
+	counts := make(map[string]int)
+	for _, s := range streams {
+		before[s] = owner(s, workers)
+		counts[before[s]]++
+	}
+	for _, w := range workers {
+		if counts[w] < 50 {
+			t.Errorf("worker %s only got %d of %d streams", w, counts[w], len(streams))
+		}
+	}
+
+	// when a worker joins, streams only move to it
+	for _, s := range streams {
+		if after := owner(s, append(workers, "d")); after != before[s] && after != "d" {
+			t.Errorf("%s moved from %s to %s", s, before[s], after)
+		}
+	}
+	// when a worker leaves, only its streams move
+	for _, s := range streams {
+		if after := owner(s, []string{"a", "b"}); after != before[s] && before[s] != "c" {
+			t.Errorf("%s moved from %s to %s", s, before[s], after)
+		}
+	}
+}
+
+func testPoolMembership(t *testing.T, newWorker func(id string) *CLI) {
+	now := time.Now()
+	a := newWorker("a")
+	a.fakeNower = &FakeNower{t: now}
+	b := newWorker("b")
+	b.fakeNower = &FakeNower{t: now}
+	pa, err := a.newPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pb, err := b.newPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pa.heartbeat()
+	pb.heartbeat()
+	if workers, err := pa.workers(); err != nil || !reflect.DeepEqual(workers, []string{"a", "b"}) {
+		t.Errorf("expected workers a and b, got %v %v", workers, err)
+	}
+
+	// b stops heartbeating, and drops out once its lease is up
+	a.fakeNower = &FakeNower{t: now.Add(time.Minute)}
+	pa.heartbeat()
+	if workers, err := pa.workers(); err != nil || !reflect.DeepEqual(workers, []string{"a"}) {
+		t.Errorf("expected worker a, got %v %v", workers, err)
+	}
+
+	// a leaves
+	if err := pa.leave(); err != nil {
+		t.Fatal(err)
+	}
+	if workers, err := pb.workers(); err != nil || len(workers) != 1 || workers[0] != "b" {
+		t.Errorf("expected worker b, got %v %v", workers, err)
+	}
+}
+
+func TestFilePool(t *testing.T) {
+	dir := t.TempDir()
+	testPoolMembership(t, func(id string) *CLI {
+		return &CLI{Options: &Options{Tail: TailOptions{Shard: "file:" + dir, LeaderLease: 15 * time.Second, LeaderID: id}}}
+	})
+}
+
+func TestDynamoDBPool(t *testing.T) {
+	ddb := newFakeDynamoDB()
+	testPoolMembership(t, func(id string) *CLI {
+		return &CLI{Options: &Options{Tail: TailOptions{Shard: "dynamodb:rdslogs", LeaderLease: 15 * time.Second, LeaderID: id}}, DynamoDB: ddb}
+	})
+}
+
+func TestStreamCLI(t *testing.T) {
+	c := &CLI{Options: &Options{
+		LogType:   LogTypeQuery,
+		LogFile:   "slowquery/mysql-slowquery.log",
+		Tail:      TailOptions{CheckpointDir: "/shared/checkpoints", LeaderID: "a"},
+		AddFields: map[string]string{"env": "prod"},
+	}}
+	p := &dynamoDBPool{c: c, table: "rdslogs"}
+	sc := c.streamCLI(p, shardStream{identifier: "db-2", logType: "audit"}, make(chan bool))
+	if sc.Options.InstanceIdentifier != "db-2" || sc.Options.LogType != "audit" || sc.Options.LogFile != "" {
+		t.Errorf("unexpected stream options %+v", sc.Options)
+	}
+	if sc.Options.Tail.CheckpointFile != "/shared/checkpoints/db-2-audit.json" {
+		t.Errorf("unexpected checkpoint file %s", sc.Options.Tail.CheckpointFile)
+	}
+	if sc.Options.Tail.LeaderElection != "dynamodb:rdslogs" {
+		t.Errorf("unexpected leader election %s", sc.Options.Tail.LeaderElection)
+	}
+	expected := map[string]string{"env": "prod", "rds_instance": "db-2"}
+	if !reflect.DeepEqual(sc.Options.AddFields, expected) {
+		t.Errorf("expected fields %v, got %v", expected, sc.Options.AddFields)
+	}
+	if len(c.Options.AddFields) != 1 {
+		t.Error("stream's fields leaked back in to the worker's options")
+	}
+}
+
+func TestStreamCLIConfig(t *testing.T) {
+	c := &CLI{
+		Options: &Options{Region: "us-east-1", Tail: TailOptions{CheckpointDir: "/shared/checkpoints", LeaderID: "a"}},
+		RDS:     &FakeRDS{},
+		StreamClients: func(opts *Options) (rdsiface.RDSAPI, cloudwatchlogsiface.CloudWatchLogsAPI) {
+			return &FakeRDS{}, nil
+		},
+	}
+	streamOpts := &Options{
+		Region:             "eu-west-1",
+		InstanceIdentifier: "db-2",
+		LogFile:            "error/postgresql.log",
+		Dataset:            "postgres",
+		AddFields:          map[string]string{"team": "data"},
+	}
+	sc := c.streamCLI(&localPool{c: c}, shardStream{identifier: "db-2", opts: streamOpts}, make(chan bool))
+	if sc.Options.LogFile != "error/postgresql.log" || sc.Options.Dataset != "postgres" {
+		t.Errorf("expected the stream's own options, got %+v", sc.Options)
+	}
+	if sc.Options.Tail.CheckpointFile != "/shared/checkpoints/db-2.json" || sc.Options.Tail.LeaderElection != "" {
+		t.Errorf("unexpected checkpoint file %s or leader election %s", sc.Options.Tail.CheckpointFile, sc.Options.Tail.LeaderElection)
+	}
+	if sc.RDS == c.RDS {
+		t.Error("expected a client for the stream's region")
+	}
+	if expected := map[string]string{"team": "data", "rds_instance": "db-2"}; !reflect.DeepEqual(sc.Options.AddFields, expected) {
+		t.Errorf("expected fields %v, got %v", expected, sc.Options.AddFields)
+	}
+
+	// a stream in another account needs its own clients too, while one in
+	// the same region and account shares ours
+	sc = c.streamCLI(&localPool{c: c}, shardStream{identifier: "db-3", opts: &Options{
+		Region:             "us-east-1",
+		RoleARN:            "arn:aws:iam::123456789012:role/rdslogs",
+		InstanceIdentifier: "db-3",
+	}}, make(chan bool))
+	if sc.RDS == c.RDS {
+		t.Error("expected a client for the stream's role")
+	}
+	sc = c.streamCLI(&localPool{c: c}, shardStream{identifier: "db-4", opts: &Options{Region: "us-east-1", InstanceIdentifier: "db-4"}}, make(chan bool))
+	if sc.RDS != c.RDS {
+		t.Error("expected the stream to share our client")
+	}
+}
+
+func TestReloadUnchangedStream(t *testing.T) {
+	c := &CLI{
+		Options: &Options{Region: "us-east-1", Tail: TailOptions{CheckpointDir: "/shared/checkpoints", LeaderID: "a"}},
+		RDS:     &FakeRDS{},
+	}
+	config := func() *Options {
+		return &Options{Region: "us-east-1", InstanceIdentifier: "db-2", SampleRate: 1}
+	}
+	p := &dynamoDBPool{c: c, table: "rdslogs"}
+	r := c.startStream(p, shardStream{identifier: "db-2", opts: config()})
+	// there's no such instance, so it stops straight away
+	<-r.done
+	if r.c.Options.Tail.LeaderElection == "" || r.c.Options.Tail.CheckpointFile == "" {
+		t.Fatalf("expected the worker's leader and checkpoint settings, got %+v", r.c.Options.Tail)
+	}
+	// the leader and checkpoint settings the worker filled in aren't in the
+	// reloaded config, and don't count as changes to it
+	if names := restartRequired(&r.started, config()); len(names) != 0 {
+		t.Errorf("expected nothing to need a restart, got %v", names)
+	}
+}
@@ -0,0 +1,267 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/honeycombio/honeytail/parsers"
+	"github.com/honeycombio/honeytail/parsers/csv"
+	"github.com/honeycombio/honeytail/parsers/mysql"
+
+	"github.com/honeycombio/rdslogs/parsers/mysqlerror"
+	"github.com/honeycombio/rdslogs/parsers/mysqlgeneral"
+	"github.com/honeycombio/rdslogs/parsers/oracle"
+	"github.com/honeycombio/rdslogs/parsers/pgaudit"
+	"github.com/honeycombio/rdslogs/parsers/pgquery"
+	"github.com/honeycombio/rdslogs/parsers/sqlserver"
+)
+
+// logSource describes a log rdslogs knows how to read: which (dbtype,
+// log_type) pairs it serves, where RDS writes it, how to parse it and how it's
+// rotated. Adding support for a new log means adding a logSource to
+// logSources.
+type logSource struct {
+	dbTypes []string
+	logType string
+	// defaultFile returns the log file RDS writes to for the instance
+	defaultFile func(instance *rds.DBInstance) string
+	// cloudwatchLog is the name RDS exports the log to CloudWatch Logs under,
+	// as in /aws/rds/instance/<identifier>/<cloudwatchLog>
+	cloudwatchLog string
+	// newParser returns an initialized parser for the log
+	newParser func(c *CLI) (parsers.Parser, error)
+	// rotation decides which file Stream starts from and how it follows the
+	// log when RDS rotates it
+	rotation rotation
+}
+
+// staticFile is a defaultFile for logs whose name doesn't depend on the
+// instance
+func staticFile(name string) func(*rds.DBInstance) string {
+	return func(*rds.DBInstance) string { return name }
+}
+
+var mysqlFamily = []string{DBTypeMySQL, DBTypeMariaDB}
+
+var logSources = []logSource{
+	{
+		dbTypes:       mysqlFamily,
+		logType:       LogTypeQuery,
+		defaultFile:   staticFile("slowquery/mysql-slowquery.log"),
+		cloudwatchLog: "slowquery",
+		newParser: func(c *CLI) (parsers.Parser, error) {
+			parser := &mysql.Parser{}
+			return parser, parser.Init(&mysql.Options{NumParsers: c.Options.NumParsers})
+		},
+		rotation: hourlyRotation{},
+	},
+	{
+		dbTypes: mysqlFamily,
+		logType: LogTypeAudit,
+		// MariaDB ships the audit plugin itself, but RDS writes it to the
+		// same place as the MySQL option group's copy
+		defaultFile:   staticFile("audit/server_audit.log"),
+		cloudwatchLog: "audit",
+		newParser: func(c *CLI) (parsers.Parser, error) {
+			parser := &csv.Parser{}
+			return parser, parser.Init(&csv.Options{
+				Fields:          "time,hostname,user,source_addr,connection_id,query_id,event_type,database,query,error_code",
+				NumParsers:      c.Options.NumParsers,
+				TimeFieldName:   "time",
+				TimeFieldFormat: "20060102 15:04:05",
+			})
+		},
+		rotation: sizeRotation{},
+	},
+	{
+		dbTypes: mysqlFamily,
+		logType: LogTypeError,
+		// like the slow query log, the error and general logs are rotated
+		// hourly to <name>.N, so we can always tail the same file name
+		defaultFile:   staticFile("error/mysql-error-running.log"),
+		cloudwatchLog: "error",
+		newParser: func(c *CLI) (parsers.Parser, error) {
+			parser := &mysqlerror.Parser{}
+			return parser, parser.Init(&mysqlerror.Options{})
+		},
+		rotation: hourlyRotation{},
+	},
+	{
+		dbTypes:       mysqlFamily,
+		logType:       LogTypeGeneral,
+		defaultFile:   staticFile("general/mysql-general.log"),
+		cloudwatchLog: "general",
+		newParser: func(c *CLI) (parsers.Parser, error) {
+			parser := &mysqlgeneral.Parser{}
+			return parser, parser.Init(&mysqlgeneral.Options{})
+		},
+		rotation: hourlyRotation{},
+	},
+	{
+		dbTypes:       []string{DBTypePostgreSQL},
+		logType:       LogTypeQuery,
+		defaultFile:   staticFile("error/postgresql.log"),
+		cloudwatchLog: "postgresql",
+		newParser: func(c *CLI) (parsers.Parser, error) {
+			parser := &pgquery.Parser{}
+			prefix := c.getPostgresLinePrefix()
+			if err := parser.Init(&pgquery.Options{
+				LogLinePrefix: prefix,
+				SeqScanRows:   c.Options.SeqScanRows,
+			}); err != nil {
+				return nil, fmt.Errorf("unable to parse log_line_prefix %q: %s", prefix, err)
+			}
+			return parser, nil
+		},
+		rotation: newestFileRotation{},
+	},
+	{
+		dbTypes: []string{DBTypePostgreSQL},
+		logType: LogTypeAudit,
+		// pgaudit writes in to the regular postgres log
+		defaultFile:   staticFile("error/postgresql.log"),
+		cloudwatchLog: "postgresql",
+		newParser: func(c *CLI) (parsers.Parser, error) {
+			parser := &pgaudit.Parser{}
+			prefix := c.getPostgresLinePrefix()
+			if err := parser.Init(&pgaudit.Options{
+				LogLinePrefix: prefix,
+				PassThrough:   c.Options.AuditPassThrough,
+			}); err != nil {
+				return nil, fmt.Errorf("unable to parse log_line_prefix %q: %s", prefix, err)
+			}
+			return parser, nil
+		},
+		rotation: newestFileRotation{},
+	},
+	{
+		dbTypes: []string{DBTypeOracle},
+		logType: LogTypeAlert,
+		defaultFile: func(instance *rds.DBInstance) string {
+			// RDS uses the database name as the SID, and defaults it to ORCL
+			sid := strings.ToUpper(aws.StringValue(instance.DBName))
+			if sid == "" {
+				sid = "ORCL"
+			}
+			return fmt.Sprintf("trace/alert_%s.log", sid)
+		},
+		cloudwatchLog: "alert",
+		newParser: func(c *CLI) (parsers.Parser, error) {
+			parser := &oracle.AlertParser{}
+			return parser, parser.Init(&oracle.AlertOptions{})
+		},
+		rotation: sizeRotation{},
+	},
+	{
+		dbTypes:       []string{DBTypeOracle},
+		logType:       LogTypeListener,
+		defaultFile:   staticFile("trace/listener.log"),
+		cloudwatchLog: "listener",
+		newParser: func(c *CLI) (parsers.Parser, error) {
+			parser := &oracle.ListenerParser{}
+			return parser, parser.Init(&oracle.ListenerOptions{})
+		},
+		rotation: sizeRotation{},
+	},
+	{
+		dbTypes:       []string{DBTypeOracle},
+		logType:       LogTypeAudit,
+		defaultFile:   staticFile("audit/"),
+		cloudwatchLog: "audit",
+		newParser: func(c *CLI) (parsers.Parser, error) {
+			parser := &oracle.AuditParser{}
+			return parser, parser.Init(&oracle.AuditOptions{})
+		},
+		// each session writes a file of its own
+		rotation: newestFileRotation{},
+	},
+	{
+		dbTypes:       []string{DBTypeSQLServer},
+		logType:       LogTypeError,
+		defaultFile:   staticFile("log/ERROR"),
+		cloudwatchLog: "error",
+		newParser: func(c *CLI) (parsers.Parser, error) {
+			parser := &sqlserver.ErrorParser{}
+			return parser, parser.Init(&sqlserver.ErrorOptions{})
+		},
+		// ERROR is rotated to ERROR.1 on restart or sp_cycle_errorlog
+		rotation: sizeRotation{},
+	},
+	{
+		dbTypes:       []string{DBTypeSQLServer},
+		logType:       LogTypeAgent,
+		defaultFile:   staticFile("log/SQLAGENT.OUT"),
+		cloudwatchLog: "agent",
+		newParser: func(c *CLI) (parsers.Parser, error) {
+			parser := &sqlserver.AgentParser{}
+			return parser, parser.Init(&sqlserver.AgentOptions{})
+		},
+		// SQLAGENT.OUT is rotated to SQLAGENT.1, not SQLAGENT.OUT.1
+		rotation: sizeRotation{rotatedName: func(name string) string {
+			return strings.TrimSuffix(name, ".OUT") + ".1"
+		}},
+	},
+}
+
+// lookupLogSource finds the logSource for a (dbtype, log_type) pair
+func lookupLogSource(dbType, logType string) (*logSource, error) {
+	for i := range logSources {
+		src := &logSources[i]
+		if src.logType != logType {
+			continue
+		}
+		for _, t := range src.dbTypes {
+			if t == dbType {
+				return src, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf(
+		"Unsupported (dbtype, log_type) pair (`%s`,`%s`)",
+		dbType, logType)
+}
+
+// ValidateLogType checks that dbType is one we know, and has a log_type of
+// logType when both are given, so a bad dbtype or pair is caught before
+// talking to RDS
+func ValidateLogType(dbType, logType string) error {
+	if dbType != "" && !contains(dbTypes, dbType) {
+		return unknownDBType(dbType)
+	}
+	if dbType == "" || logType == "" {
+		return nil
+	}
+	_, err := lookupLogSource(dbType, logType)
+	return err
+}
+
+// logFileFilter returns what the names of the log's files, current and
+// rotated, have in common, to list them by
+func (c *CLI) logFileFilter() string {
+	name := c.Options.LogFile
+	src, err := c.source()
+	if err != nil {
+		return name
+	}
+	r, ok := src.rotation.(renamingRotation)
+	if !ok {
+		return name
+	}
+	rotated := r.rotatedFile(name)
+	i := 0
+	for i < len(name) && i < len(rotated) && name[i] == rotated[i] {
+		i++
+	}
+	return name[:i]
+}
+
+func unknownDBType(dbType string) error {
+	return fmt.Errorf("dbtype %q not recognized, use one of %s", dbType, strings.Join(dbTypes, ", "))
+}
+
+// source returns the logSource for the configured dbtype and log_type
+func (c *CLI) source() (*logSource, error) {
+	return lookupLogSource(c.Options.DBType, c.Options.LogType)
+}
@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/rds"
+)
+
+func TestLogSources(t *testing.T) {
+	c := &CLI{Options: &Options{NumParsers: 1, LogLinePrefix: rdsPostgresLinePrefix}}
+	for _, src := range logSources {
+		for _, dbType := range src.dbTypes {
+			found, err := lookupLogSource(dbType, src.logType)
+			if err != nil {
+				t.Errorf("%s %s: %s", dbType, src.logType, err)
+				continue
+			}
+			if found.logType != src.logType {
+				t.Errorf("%s %s: looked up %s source", dbType, src.logType, found.logType)
+			}
+		}
+		if src.defaultFile(&rds.DBInstance{}) == "" {
+			t.Errorf("%v %s: no default file", src.dbTypes, src.logType)
+		}
+		if _, err := src.newParser(c); err != nil {
+			t.Errorf("%v %s: unable to create parser: %s", src.dbTypes, src.logType, err)
+		}
+	}
+}
+
+func TestLookupLogSource(t *testing.T) {
+	testCases := []struct {
+		dbType   string
+		logType  string
+		rotation rotation
+	}{
+		{DBTypeMySQL, LogTypeQuery, hourlyRotation{}},
+		{DBTypeMariaDB, LogTypeAudit, sizeRotation{}},
+		{DBTypePostgreSQL, LogTypeQuery, newestFileRotation{}},
+		{DBTypeOracle, LogTypeAlert, sizeRotation{}},
+		{DBTypeOracle, LogTypeAudit, newestFileRotation{}},
+		{DBTypeSQLServer, LogTypeError, sizeRotation{}},
+		{DBTypeSQLServer, LogTypeAgent, sizeRotation{}},
+	}
+	for _, tc := range testCases {
+		src, err := lookupLogSource(tc.dbType, tc.logType)
+		if err != nil {
+			t.Errorf("%s %s: %s", tc.dbType, tc.logType, err)
+			continue
+		}
+		if reflect.TypeOf(src.rotation) != reflect.TypeOf(tc.rotation) {
+			t.Errorf("%s %s: expected rotation %T, got %T", tc.dbType, tc.logType, tc.rotation, src.rotation)
+		}
+	}
+	if _, err := lookupLogSource(DBTypePostgreSQL, LogTypeGeneral); err == nil {
+		t.Error("expected an error for an unsupported pair")
+	}
+}
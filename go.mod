@@ -6,8 +6,10 @@ require (
 	github.com/aws/aws-sdk-go v1.44.9
 	github.com/honeycombio/honeytail v1.6.2
 	github.com/honeycombio/libhoney-go v1.15.8
+	github.com/honeycombio/mysqltools v0.0.1
 	github.com/jessevdk/go-flags v1.5.0
 	github.com/sirupsen/logrus v1.8.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -16,7 +18,6 @@ require (
 	github.com/facebookgo/limitgroup v0.0.0-20150612190941-6abd8d71ec01 // indirect
 	github.com/facebookgo/muster v0.0.0-20150708232844-fd3d7953fd52 // indirect
 	github.com/go-sql-driver/mysql v1.6.0 // indirect
-	github.com/honeycombio/mysqltools v0.0.1 // indirect
 	github.com/honeycombio/sqlparser v0.0.0-20210924214121-0662550abc08 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
 	github.com/klauspost/compress v1.15.3 // indirect
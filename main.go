@@ -2,18 +2,27 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"os/signal"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/rds/rdsiface"
 	flag "github.com/jessevdk/go-flags"
 	"github.com/sirupsen/logrus"
 
@@ -24,6 +33,21 @@ import (
 // BuildID is set by Travis CI
 var BuildID string
 
+// envPrefix starts the names of the environment variables that set options,
+// as in RDSLOGS_WRITEKEY for --writekey
+const envPrefix = "RDSLOGS_"
+
+// exit codes, so whatever runs rdslogs can tell a clean stop from a failure
+const (
+	// exitOK is for finishing, or stopping cleanly when signalled
+	exitOK = 0
+	// exitFailure is for stopping because something went wrong
+	exitFailure = 1
+	// exitShutdownTimeout is for giving up on sending what had been read
+	// when signalled, after --shutdown_timeout or a second signal
+	exitShutdownTimeout = 2
+)
+
 func main() {
 	options, err := parseFlags()
 	if err != nil {
@@ -37,40 +61,96 @@ func main() {
 		sig := <-sigs
 		fmt.Fprintf(os.Stderr, "Aborting! Caught Signal \"%s\"\n", sig)
 		fmt.Fprintf(os.Stderr, "Cleaning up...\n")
+		// closing abort tells everything reading logs to stop, send what
+		// it's read and save its checkpoint
+		close(abort)
 		select {
-		case abort <- true:
-			close(abort)
-		case <-time.After(10 * time.Second):
+		case sig := <-sigs:
+			fmt.Fprintf(os.Stderr, "Caught Signal \"%s\" again, exiting without cleaning up\n", sig)
+		case <-time.After(options.ShutdownTimeout):
 			fmt.Fprintf(os.Stderr, "Taking too long... Aborting.\n")
-			os.Exit(1)
 		}
+		os.Exit(exitShutdownTimeout)
 	}()
 
 	session, err := session.NewSession()
 	if err != nil {
 		log.Fatal(err)
 	}
+	// with --role_arn, every call is made as the role
+	roleCreds := roleCredentials(session)
+	awsConfig := &aws.Config{
+		Region:      aws.String(options.Region),
+		Credentials: roleCreds(options),
+	}
+	// all our calls to RDS share the one budget
+	rdsClient, err := cli.NewRateLimitedRDS(rds.New(session, awsConfig), options.RateLimits)
+	if err != nil {
+		log.Fatal(err)
+	}
 	c := &cli.CLI{
-		Options: options,
-		RDS: rds.New(session, &aws.Config{
-			Region: aws.String(options.Region),
-		}),
-		Abort: abort,
+		Options:        options,
+		RDS:            rdsClient,
+		CloudWatchLogs: cloudwatchlogs.New(session, awsConfig),
+		DynamoDB:       dynamodb.New(session, awsConfig),
+		StreamClients:  streamClients(session, roleCreds, options.RateLimits),
+		Abort:          abort,
 	}
 
 	if options.Debug {
 		logrus.SetLevel(logrus.DebugLevel)
 	}
 
-	// if sending output to Honeycomb, make sure we have a write key and dataset
-	if options.Output == "honeycomb" {
+	// re-read the config file on SIGHUP. started keeps the options as they
+	// were before the engine's defaults are filled in, to compare with.
+	started := *options
+	hups := make(chan os.Signal, 1)
+	signal.Notify(hups, syscall.SIGHUP)
+	go func() {
+		for range hups {
+			if options.ConfigFile == "" {
+				logrus.Warn("Caught SIGHUP, but there's no --config to reload")
+				continue
+			}
+			reloaded, err := parseFlags()
+			if err != nil {
+				logrus.WithError(err).Error("unable to reload config, keeping the current one")
+				continue
+			}
+			c.Reload(&started, reloaded)
+		}
+	}()
+
+	switch options.Command {
+	case "list-instances":
+		instances, err := c.ListInstances()
+		if err != nil {
+			fatalAWS(err)
+		}
+		finish(cli.PrintInstances(os.Stdout, options.ListInstances.Format, instances))
+		return
+	case "list-logs":
+		validateInstance(c)
+		logFiles, err := c.ListLogs()
+		if err != nil {
+			fatalAWS(err)
+		}
+		finish(cli.PrintLogs(os.Stdout, options.ListLogs.Format, logFiles))
+		return
+	}
+
+	libhoney.UserAgentAddition = fmt.Sprintf("rdslogs/%s", BuildID)
+	// if sending output to Honeycomb, make sure we have a write key and
+	// dataset. A structured config's streams were checked when it was read.
+	if options.StreamConfig != nil {
+		fmt.Fprintf(os.Stderr, "Sending output where each stream in %s says\n", options.ConfigFile)
+	} else if options.Output == "honeycomb" {
 		if options.WriteKey == "" || options.Dataset == "" {
 			log.Fatal("writekey and dataset flags required when output is 'honeycomb'.\nuse --help for usage info.")
 		}
 		if options.SampleRate < 1 {
 			log.Fatal("Sample rate must be a positive integer.\nuse --help for usage info.")
 		}
-		libhoney.UserAgentAddition = fmt.Sprintf("rdslogs/%s", BuildID)
 		fmt.Fprintln(os.Stderr, "Sending output to Honeycomb")
 	} else if options.Output == "stdout" {
 		fmt.Fprintln(os.Stderr, "Sending output to STDOUT")
@@ -79,28 +159,127 @@ func main() {
 		log.Fatal("output target not recognized. use --help for usage info")
 	}
 
-	// make sure we can talk to an RDS instance.
-	err = c.ValidateRDSInstance()
-	if err == credentials.ErrNoValidProvidersFoundInChain {
-		log.Fatal(awsCredsFailureMsg())
+	if len(options.Input) > 0 {
+		fmt.Fprintln(os.Stderr, "Running in replay mode - reading saved logs")
+		finish(c.Replay())
+		return
 	}
-	if err != nil {
+
+	if options.Tail.Shard != "" {
+		fmt.Fprintln(os.Stderr, "Running in worker pool mode - streaming our share of the logs")
+		finish(c.RunShard())
+		return
+	}
+
+	if options.StreamConfig != nil {
+		fmt.Fprintln(os.Stderr, "Running in tail mode - streaming logs for each stream in the config")
+		finish(c.RunStreams())
+		return
+	}
+
+	validateInstance(c)
+	if err := c.ResolveEngineDefaults(); err != nil {
 		log.Fatal(err)
 	}
 
+	stream := c.Stream
+	if options.Source == cli.SourceCloudWatch {
+		stream = c.StreamCloudWatch
+	}
 	if options.Download {
 		fmt.Fprintln(os.Stderr, "Running in download mode - downloading old logs")
 		err = c.Download()
+	} else if options.Command == "backfill" {
+		fmt.Fprintf(os.Stderr, "Running in backfill mode - sending logs from the last %s\n", options.Backfill.Since)
+		err = c.Backfill(options.Backfill.Since)
+	} else if options.Tail.LeaderElection != "" {
+		fmt.Fprintf(os.Stderr, "Running in tail mode - streaming logs from %s when elected leader\n", options.Source)
+		err = c.RunAsLeader(stream)
+	} else if options.Source == cli.SourceCloudWatch {
+		fmt.Fprintln(os.Stderr, "Running in tail mode - streaming logs from CloudWatch Logs")
+		err = stream()
 	} else {
 		fmt.Fprintln(os.Stderr, "Running in tail mode - streaming logs from RDS")
-		err = c.Stream()
+		err = stream()
 	}
-	if err != nil {
-		log.Fatal(err)
+	finish(err)
+}
+
+// validateInstance makes sure we can talk to the RDS instance, or exits
+func validateInstance(c *cli.CLI) {
+	if err := c.ValidateRDSInstance(); err != nil {
+		fatalAWS(err)
+	}
+}
+
+// fatalAWS exits with an error from calling AWS, saying what to do about it
+// if it's a problem with the credentials
+func fatalAWS(err error) {
+	if err == credentials.ErrNoValidProvidersFoundInChain {
+		log.Fatal(awsCredsFailureMsg())
+	}
+	log.Fatal(cli.ExplainCredentialsError(err))
+}
+
+// finish exits with the exit code for how running ended
+func finish(err error) {
+	switch {
+	case err == cli.ErrAborted:
+		fmt.Fprintln(os.Stderr, "Stopped")
+		os.Exit(exitOK)
+	case err == credentials.ErrNoValidProvidersFoundInChain:
+		log.Print(awsCredsFailureMsg())
+		os.Exit(exitFailure)
+	case err != nil:
+		log.Print(cli.ExplainCredentialsError(err))
+		os.Exit(exitFailure)
 	}
 	fmt.Fprintln(os.Stderr, "OK")
 }
 
+// roleCredentials returns a func that gives the credentials to call AWS with
+// for options' role, or nil for the session's own. Each role is assumed once
+// for each way of assuming it, and its credentials shared by everything
+// calling AWS as it.
+func roleCredentials(sess *session.Session) func(*cli.Options) *credentials.Credentials {
+	var mu sync.Mutex
+	roles := make(map[string]*credentials.Credentials)
+	return func(opts *cli.Options) *credentials.Credentials {
+		if opts.RoleARN == "" {
+			return nil
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		key := cli.RoleKey(opts)
+		if _, ok := roles[key]; !ok {
+			roles[key] = cli.RoleCredentials(sess, opts)
+		}
+		return roles[key]
+	}
+}
+
+// streamClients returns a func that makes RDS and CloudWatch Logs clients for
+// a stream's region and role, once each. Each region and role's RDS calls get
+// a budget of their own.
+func streamClients(sess *session.Session, roleCreds func(*cli.Options) *credentials.Credentials, rateLimits map[string]float64) func(*cli.Options) (rdsiface.RDSAPI, cloudwatchlogsiface.CloudWatchLogsAPI) {
+	var mu sync.Mutex
+	rdsClients := make(map[string]rdsiface.RDSAPI)
+	cloudWatchClients := make(map[string]cloudwatchlogsiface.CloudWatchLogsAPI)
+	return func(opts *cli.Options) (rdsiface.RDSAPI, cloudwatchlogsiface.CloudWatchLogsAPI) {
+		creds := roleCreds(opts)
+		mu.Lock()
+		defer mu.Unlock()
+		key := cli.RoleKey(opts)
+		if _, ok := rdsClients[key]; !ok {
+			cfg := &aws.Config{Region: aws.String(opts.Region), Credentials: creds}
+			// the limits were already checked making the first client
+			rdsClients[key], _ = cli.NewRateLimitedRDS(rds.New(sess, cfg), rateLimits)
+			cloudWatchClients[key] = cloudwatchlogs.New(sess, cfg)
+		}
+		return rdsClients[key], cloudWatchClients[key]
+	}
+}
+
 // getVersion returns the internal version ID
 func getVersion() string {
 	if BuildID == "" {
@@ -111,15 +290,31 @@ func getVersion() string {
 
 // parse all the flags, exit if anything's amiss
 func parseFlags() (*cli.Options, error) {
+	return parseArgs(os.Args[1:])
+}
+
+// parseArgs reads the options from args, then the RDSLOGS_* environment
+// variables, then the config file, each filling in what the ones before
+// didn't set
+func parseArgs(args []string) (*cli.Options, error) {
 	var options cli.Options
-	flagParser := flag.NewParser(&options, flag.Default)
-	flagParser.Usage = cli.Usage
+	// with no command, the log is tailed (or downloaded, with --download)
+	defaultCmd := defaultCommand(args)
+	if defaultCmd != "" {
+		args = append([]string{defaultCmd}, args...)
+	}
+	flagParser := newParser(&options)
 
 	// parse flags and check for extra command line args
-	if extraArgs, err := flagParser.Parse(); err != nil || len(extraArgs) != 0 {
+	if extraArgs, err := flagParser.ParseArgs(args); err != nil || len(extraArgs) != 0 {
 		if err != nil {
 			if err.(*flag.Error).Type == flag.ErrHelp {
-				// user specified --help
+				// user specified --help. A command's help leaves out the
+				// general usage, to focus on its own flags.
+				if flagParser.Active != nil {
+					flagParser.Usage = "[OPTIONS]"
+				}
+				flagParser.WriteHelp(os.Stdout)
 				os.Exit(0)
 			}
 			fmt.Fprintln(os.Stderr, "Failed to parse the command line. Run with --help for more info")
@@ -127,11 +322,16 @@ func parseFlags() (*cli.Options, error) {
 		}
 		return nil, fmt.Errorf("Unexpected extra arguments: %s\n", strings.Join(extraArgs, " "))
 	}
+	if flagParser.Active != nil && defaultCmd == "" {
+		options.Command = flagParser.Active.Name
+	}
+	if options.Tail.DownloadDir != "" {
+		logrus.Warn("--download_dir only applies to downloading, and is ignored when tailing. It's deprecated without --download or the download command")
+	}
 
 	// if all we want is the config file, just write it in and exit
 	if options.WriteDefaultConfig {
-		ip := flag.NewIniParser(flagParser)
-		ip.Write(os.Stdout, flag.IniIncludeDefaults|flag.IniCommentDefaults|flag.IniIncludeComments)
+		writeDefaultConfig(os.Stdout, flagParser)
 		os.Exit(0)
 	}
 
@@ -140,38 +340,334 @@ func parseFlags() (*cli.Options, error) {
 		fmt.Println("Version:", getVersion())
 		os.Exit(0)
 	}
+	// options given as flags or environment variables win over the config
+	// file
+	set := setFlags(flagParser)
+	if err := applyEnv(flagParser, set); err != nil {
+		return nil, err
+	}
+	if options.ConfigFile == "" {
+		options.ConfigFile = os.Getenv(envPrefix + "CONFIG")
+	}
+
 	// read the config file if specified
-	if options.ConfigFile != "" {
-		ini := flag.NewIniParser(flagParser)
-		ini.ParseAsDefaults = true
-		if err := ini.ParseFile(options.ConfigFile); err != nil {
+	if options.ConfigFile != "" && cli.IsStructuredConfig(options.ConfigFile) {
+		cfg, err := cli.LoadConfig(options.ConfigFile, &options, set)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf("config file %s doesn't exist", options.ConfigFile)
+			}
+			return nil, err
+		}
+		options.StreamConfig = cfg
+	} else if options.ConfigFile != "" {
+		if err := parseIniConfig(flagParser, options.ConfigFile); err != nil {
 			if os.IsNotExist(err) {
 				return nil, fmt.Errorf("config file %s doesn't exist", options.ConfigFile)
 			}
 			return nil, err
 		}
 	}
+	if err := options.ReadWriteKeyFile(); err != nil {
+		return nil, err
+	}
 
-	if options.DBType == cli.DBTypeMySQL && options.LogType == cli.LogTypeQuery {
-		if options.LogFile == "" {
-			options.LogFile = "slowquery/mysql-slowquery.log"
+	switch options.Command {
+	case "download":
+		options.Download = true
+	case "tail":
+		if options.Download {
+			return nil, fmt.Errorf("--download can't be used with the tail command")
 		}
-	} else if options.DBType == cli.DBTypeMySQL && options.LogType == cli.LogTypeAudit {
-		if options.LogFile == "" {
-			options.LogFile = "audit/server_audit.log"
+	case "backfill", "list-instances", "list-logs":
+		if options.Download || len(options.Input) > 0 || options.Tail.Shard != "" || options.Tail.LeaderElection != "" {
+			return nil, fmt.Errorf("%s can't be used with --download, --input, --shard or --leader_election", options.Command)
 		}
-	} else if options.DBType == cli.DBTypePostgreSQL && options.LogType == cli.LogTypeQuery {
-		if options.LogFile == "" {
-			options.LogFile = "error/postgresql.log"
+		if options.Command == "backfill" && options.Source == cli.SourceCloudWatch {
+			return nil, fmt.Errorf("backfill only reads from rds, not cloudwatch")
+		}
+		if options.Command != "list-instances" && options.StreamConfig != nil {
+			return nil, fmt.Errorf("%s reads one --identifier, not the streams in %s", options.Command, options.ConfigFile)
+		}
+	}
+
+	if options.Source != cli.SourceRDS && options.Source != cli.SourceCloudWatch {
+		return nil, fmt.Errorf("source %s not recognized, use rds or cloudwatch", options.Source)
+	}
+	// a structured config's streams may set their own, and were checked as
+	// it was read. validate checks them below, with everything else.
+	if options.StreamConfig == nil && options.Command != "validate" {
+		if err := cli.ValidateLogType(options.DBType, options.LogType); err != nil {
+			return nil, err
 		}
-	} else {
-		return nil, fmt.Errorf(
-			"Unsupported (dbtype, log_type) pair (`%s`,`%s`)",
-			options.DBType, options.LogType)
 	}
+	if options.Download && len(options.Input) > 0 {
+		return nil, fmt.Errorf("--download and --input can't be used together")
+	}
+	if options.Download && options.Source == cli.SourceCloudWatch {
+		return nil, fmt.Errorf("--download only reads from rds, not cloudwatch")
+	}
+	if err := cli.ValidateRole(&options); err != nil {
+		return nil, err
+	}
+	if err := cli.ValidateLeaderElection(options.Tail.LeaderElection); err != nil {
+		return nil, err
+	}
+	if err := cli.ValidateShard(options.Tail.Shard); err != nil {
+		return nil, err
+	}
+	if (options.Tail.LeaderElection != "" || options.Tail.Shard != "") && options.Tail.LeaderLease <= 0 {
+		return nil, fmt.Errorf("--leader_lease must be positive")
+	}
+	if options.Tail.Shard != "" {
+		if options.Tail.LeaderElection != "" {
+			return nil, fmt.Errorf("--shard and --leader_election can't be used together; each sharded stream already has a leader")
+		}
+		if options.Download || len(options.Input) > 0 {
+			return nil, fmt.Errorf("--shard only applies to tailing logs")
+		}
+		if len(options.Tail.Streams) == 0 && options.StreamConfig == nil {
+			return nil, fmt.Errorf("--shard needs at least one --stream to tail")
+		}
+	}
+	if options.StreamConfig != nil {
+		if options.Download || len(options.Input) > 0 {
+			return nil, fmt.Errorf("the streams in %s can only be tailed, not used with --download or --input", options.ConfigFile)
+		}
+		if options.Tail.LeaderElection != "" {
+			return nil, fmt.Errorf("--leader_election can't be used with the streams in %s; use --shard to run several replicas", options.ConfigFile)
+		}
+	}
+
+	if flagParser.Active != nil && flagParser.Active.Name == "validate" {
+		// a structured config's streams were checked as it was read
+		if options.StreamConfig == nil {
+			if err := cli.ValidateOptions(&options); err != nil {
+				return nil, err
+			}
+		}
+		printConfig(&options)
+		os.Exit(0)
+	}
+
+	// the db type, log type and log file default to what suits the
+	// instance's engine, which we look up once we can talk to RDS
 	return &options, nil
 }
 
+// newParser returns the parser for rdslogs' flags and commands, which reads
+// them in to options
+func newParser(options *cli.Options) *flag.Parser {
+	// help is printed by parseArgs, and errors by whatever called it
+	flagParser := flag.NewParser(options, flag.HelpFlag|flag.PassDoubleDash)
+	flagParser.Usage = cli.Usage
+	flagParser.SubcommandsOptional = true
+	flagParser.AddCommand("tail", "Stream the log (the default)",
+		"Stream the log as it's written, to STDOUT or Honeycomb. This is what rdslogs does when no command is given",
+		&options.Tail)
+	flagParser.AddCommand("download", "Download the log's files",
+		"Save the log's current and rotated files in --download_dir, like --download",
+		&options.DownloadOptions)
+	flagParser.AddCommand("backfill", "Send the log's recent files",
+		"Read the log's files written to in the last --since, oldest first, and send them through the parser and output like tail does, to fill in what was written before tailing started",
+		&options.Backfill)
+	flagParser.AddCommand("list-instances", "List the RDS instances",
+		"List the RDS instances in --region, with their engine, version and status, and the dbtype rdslogs reads them as",
+		&options.ListInstances)
+	flagParser.AddCommand("list-logs", "List an instance's log files",
+		"List the --identifier instance's log files, or only those starting with --log_file, with their size and when they were last written",
+		&options.ListLogs)
+	flagParser.AddCommand("validate", "Check the flags and config file",
+		"Check the flags and --config file, including each stream in a structured config, without talking to AWS",
+		&struct{}{})
+	return flagParser
+}
+
+// writeDefaultConfig writes an INI config file with every option commented
+// out at its default, as rdslogs.conf is
+func writeDefaultConfig(w io.Writer, flagParser *flag.Parser) {
+	ip := flag.NewIniParser(flagParser)
+	ip.Write(w, flag.IniIncludeDefaults|flag.IniCommentDefaults|flag.IniIncludeComments)
+}
+
+// defaultCommand returns the command to run when args don't give one: tail,
+// or download with --download. That way the tail and download flags, which
+// belong to those commands, can still be given without naming the command.
+// It returns "" if args give a command, or ask for help.
+func defaultCommand(args []string) string {
+	var probe cli.Options
+	flagParser := newParser(&probe)
+	// the command's own flags aren't known until it's found
+	flagParser.Options |= flag.IgnoreUnknown
+	if _, err := flagParser.ParseArgs(args); err != nil {
+		if flagsErr, ok := err.(*flag.Error); ok && flagsErr.Type == flag.ErrHelp {
+			return ""
+		}
+	}
+	switch {
+	case flagParser.Active != nil:
+		return ""
+	case probe.Download:
+		return "download"
+	}
+	return "tail"
+}
+
+// setFlags returns the names of the flags given on the command line, which
+// take precedence over a structured config
+func setFlags(flagParser *flag.Parser) map[string]bool {
+	set := make(map[string]bool)
+	eachOption(flagParser, func(section string, opt *flag.Option) {
+		if opt.IsSet() && !opt.IsSetDefault() {
+			set[opt.LongName] = true
+		}
+	})
+	return set
+}
+
+// eachOption calls f with each of the options, global or belonging to a
+// command, and the config file section it's read from
+func eachOption(flagParser *flag.Parser, f func(section string, opt *flag.Option)) {
+	for _, group := range flagParser.Groups() {
+		for _, opt := range group.Options() {
+			f(group.ShortDescription, opt)
+		}
+	}
+	for _, cmd := range flagParser.Commands() {
+		for _, opt := range cmd.Options() {
+			f(cmd.Name, opt)
+		}
+	}
+}
+
+// applyEnv sets the options given in RDSLOGS_* environment variables, other
+// than those in set, which were given as flags, and adds them to set. It goes
+// before the config file, which only fills in options that haven't been set.
+// Options that take several values take them comma-separated.
+func applyEnv(flagParser *flag.Parser, set map[string]bool) error {
+	var err error
+	eachOption(flagParser, func(section string, opt *flag.Option) {
+		name := envPrefix + strings.ToUpper(opt.LongName)
+		value, ok := os.LookupEnv(name)
+		if err != nil || !ok || set[opt.LongName] || opt.Field().Tag.Get("no-ini") != "" {
+			return
+		}
+		values := []string{value}
+		if kind := opt.Field().Type.Kind(); kind == reflect.Slice || kind == reflect.Map {
+			values = strings.Split(value, ",")
+		}
+		// set it the way the config file would, so the config file then
+		// leaves it alone
+		ini := fmt.Sprintf("[%s]\n", section)
+		for _, v := range values {
+			ini += fmt.Sprintf("%s = %s\n", opt.LongName, strconv.Quote(strings.TrimSpace(v)))
+		}
+		ip := flag.NewIniParser(flagParser)
+		if err = ip.Parse(strings.NewReader(ini)); err != nil {
+			if iniErr, ok := err.(*flag.IniError); ok {
+				err = fmt.Errorf("%s", iniErr.Message)
+			}
+			err = fmt.Errorf("%s: %s", name, err)
+			return
+		}
+		set[opt.LongName] = true
+	})
+	return err
+}
+
+// parseIniConfig fills in the options that haven't been set from the INI
+// config file at path. The tail and download commands' options are read from
+// sections of their own, but may also be in the global section, as they
+// were before those commands had options of their own.
+func parseIniConfig(flagParser *flag.Parser, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	commandOptions := make(map[string]string)
+	for _, name := range []string{"tail", "download"} {
+		// as with the other options, by flag or field name
+		for _, opt := range flagParser.Find(name).Options() {
+			commandOptions[opt.LongName] = name
+			commandOptions[opt.Field().Name] = name
+		}
+	}
+	// take the commands' options out of the global section, blanking them
+	// so the lines of the rest stay where they are
+	type movedLine struct {
+		lineNumber uint
+		line       string
+	}
+	moved := make(map[string][]movedLine)
+	lines := strings.Split(string(data), "\n")
+	section := ""
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != "" && !strings.EqualFold(section, "Application Options") {
+			continue
+		}
+		name := strings.TrimSpace(strings.SplitN(line, "=", 2)[0])
+		if cmd, ok := commandOptions[name]; ok && strings.Contains(line, "=") {
+			moved[cmd] = append(moved[cmd], movedLine{uint(i + 1), line})
+			lines[i] = ""
+		}
+	}
+
+	ip := flag.NewIniParser(flagParser)
+	ip.ParseAsDefaults = true
+	if err := ip.Parse(strings.NewReader(strings.Join(lines, "\n"))); err != nil {
+		if iniErr, ok := err.(*flag.IniError); ok {
+			iniErr.File = path
+		}
+		return err
+	}
+	for _, cmd := range []string{"tail", "download"} {
+		if len(moved[cmd]) == 0 {
+			continue
+		}
+		ini := fmt.Sprintf("[%s]\n", cmd)
+		for _, m := range moved[cmd] {
+			ini += m.line + "\n"
+		}
+		if err := ip.Parse(strings.NewReader(ini)); err != nil {
+			if iniErr, ok := err.(*flag.IniError); ok {
+				// the section header is the first line
+				iniErr.File = path
+				iniErr.LineNumber = moved[cmd][iniErr.LineNumber-2].lineNumber
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// printConfig reports, for the validate command, what rdslogs would read
+func printConfig(options *cli.Options) {
+	fmt.Println("Config OK")
+	if options.StreamConfig == nil {
+		return
+	}
+	for _, opts := range options.StreamConfig.StreamOptions() {
+		dbType, logType := opts.DBType, opts.LogType
+		if dbType == "" {
+			dbType = "detected"
+		}
+		if logType == "" {
+			logType = "default"
+		}
+		output := opts.Output
+		if output == "honeycomb" {
+			output += " dataset " + opts.Dataset
+		}
+		fmt.Printf("  %s in %s: dbtype %s, log_type %s, from %s to %s\n",
+			opts.InstanceIdentifier, opts.Region, dbType, logType, opts.Source, output)
+	}
+}
+
 func awsCredsFailureMsg() string {
 	// check for AWS binary
 	_, err := exec.LookPath("aws")
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/rdslogs/cli"
+)
+
+func TestParseArgsPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "rdslogs.conf")
+	ioutil.WriteFile(config, []byte("[Application Options]\ndataset = from-config\nsample_rate = 5\nwritekey = from-config\n"), 0644)
+	t.Setenv("RDSLOGS_CONFIG", config)
+	t.Setenv("RDSLOGS_DATASET", "from-env")
+	t.Setenv("RDSLOGS_SAMPLE_RATE", "3")
+	t.Setenv("RDSLOGS_ADD_FIELD", "env:prod, team:data")
+	t.Setenv("RDSLOGS_DEBUG", "true")
+
+	options, err := parseArgs([]string{"--sample_rate=7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.SampleRate != 7 {
+		t.Errorf("expected the flag to win, got sample rate %d", options.SampleRate)
+	}
+	if options.Dataset != "from-env" {
+		t.Errorf("expected the environment to beat the config, got dataset %s", options.Dataset)
+	}
+	if options.WriteKey != "from-config" {
+		t.Errorf("expected the write key from the config, got %s", options.WriteKey)
+	}
+	if options.Region != "us-east-1" || !options.Debug {
+		t.Errorf("expected the default region and debug on, got %s %v", options.Region, options.Debug)
+	}
+	if expected := map[string]string{"env": "prod", "team": "data"}; !reflect.DeepEqual(options.AddFields, expected) {
+		t.Errorf("expected fields %v, got %v", expected, options.AddFields)
+	}
+
+	t.Setenv("RDSLOGS_SAMPLE_RATE", "lots")
+	if _, err := parseArgs(nil); err == nil || !strings.Contains(err.Error(), "RDSLOGS_SAMPLE_RATE") {
+		t.Errorf("expected an error naming the variable, got %v", err)
+	}
+}
+
+func TestParseArgsStructuredConfig(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "rdslogs.yaml")
+	ioutil.WriteFile(config, []byte("defaults:\n  dataset: from-config\nstreams:\n  - identifier: db-a\n    sample_rate: 5\n"), 0644)
+	t.Setenv("RDSLOGS_DATASET", "from-env")
+
+	options, err := parseArgs([]string{"--config", config})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := options.StreamConfig.StreamOptions()
+	if len(opts) != 1 || opts[0].Dataset != "from-env" || opts[0].SampleRate != 5 {
+		t.Errorf("expected the environment to beat the config, got %+v", opts)
+	}
+}
+
+func TestWriteKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "writekey")
+	ioutil.WriteFile(path, []byte("abc123\n"), 0600)
+	t.Setenv("RDSLOGS_WRITEKEY_FILE", path)
+
+	options, err := parseArgs([]string{"--output=honeycomb", "--dataset=rds"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.WriteKey != "abc123" {
+		t.Errorf("expected the write key from the file, got %q", options.WriteKey)
+	}
+
+	t.Setenv("RDSLOGS_WRITEKEY_FILE", filepath.Join(t.TempDir(), "missing"))
+	if _, err := parseArgs(nil); err == nil {
+		t.Error("expected an error for a missing write key file")
+	}
+}
+
+func TestParseArgsCommands(t *testing.T) {
+	options, err := parseArgs([]string{"download", "--identifier=db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.Command != "download" || !options.Download {
+		t.Errorf("expected the download command to download, got %q %v", options.Command, options.Download)
+	}
+
+	options, err = parseArgs([]string{"--identifier=db", "backfill", "--since=2h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.Command != "backfill" || options.Backfill.Since != 2*time.Hour {
+		t.Errorf("expected to backfill 2h, got %q %s", options.Command, options.Backfill.Since)
+	}
+
+	options, err = parseArgs([]string{"list-logs", "--format=json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.ListLogs.Format != "json" {
+		t.Errorf("expected json, got %s", options.ListLogs.Format)
+	}
+
+	for _, args := range [][]string{
+		{"tail", "--download"},
+		{"backfill", "--source=cloudwatch"},
+		{"list-instances", "--shard=file:/tmp/pool"},
+		{"list-logs", "--format=xml"},
+	} {
+		if _, err := parseArgs(args); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestParseArgsRole(t *testing.T) {
+	t.Setenv("RDSLOGS_ROLE_ARN", "arn:aws:iam::123456789012:role/rdslogs")
+	options, err := parseArgs([]string{"--identifier=db", "--external_id=rdslogs-prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.RoleARN != "arn:aws:iam::123456789012:role/rdslogs" || options.ExternalID != "rdslogs-prod" || options.RoleSessionName != "rdslogs" {
+		t.Errorf("unexpected role %q, external id %q and session name %q", options.RoleARN, options.ExternalID, options.RoleSessionName)
+	}
+
+	if _, err := parseArgs([]string{"--identifier=db", "--role_arn=rdslogs"}); err == nil {
+		t.Error("expected a role that isn't an ARN to be refused")
+	}
+}
+
+func TestParseArgsValidate(t *testing.T) {
+	_, err := parseArgs([]string{"validate", "--output=honeycomb", "--sample_rate=0", "--dbtype=bogus"})
+	if err == nil {
+		t.Fatal("expected validate to find problems")
+	}
+	for _, expected := range []string{
+		`dbtype "bogus" not recognized`,
+		"writekey is required",
+		"dataset is required",
+		"sample_rate must be a positive integer",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in the error, got %s", expected, err)
+		}
+	}
+}
+
+func TestParseArgsLogType(t *testing.T) {
+	// a bad pair is caught before talking to RDS
+	if _, err := parseArgs([]string{"--identifier=db", "--dbtype=mysql", "--log_type=alert"}); err == nil || !strings.Contains(err.Error(), "Unsupported (dbtype, log_type) pair") {
+		t.Errorf("expected the pair to be refused, got %v", err)
+	}
+	// as is an unknown dbtype, with or without a log type
+	if _, err := parseArgs([]string{"--identifier=db", "--dbtype=bogus"}); err == nil || !strings.Contains(err.Error(), `dbtype "bogus" not recognized`) {
+		t.Errorf("expected the dbtype to be refused, got %v", err)
+	}
+	if _, err := parseArgs([]string{"--identifier=db", "--dbtype=oracle", "--log_type=alert"}); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+}
+
+func TestParseArgsCommandOptions(t *testing.T) {
+	// the tail and download flags can still be given without the command
+	options, err := parseArgs([]string{"--identifier=db", "--checkpoint_file=/tmp/checkpoint", "--leader_lease=30s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.Command != "" || options.Tail.CheckpointFile != "/tmp/checkpoint" || options.Tail.LeaderLease != 30*time.Second {
+		t.Errorf("expected to tail with a checkpoint, got %q %+v", options.Command, options.Tail)
+	}
+	options, err = parseArgs([]string{"-d", "--download_dir=/tmp/logs", "--identifier=db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !options.Download || options.DownloadOptions.DownloadDir != "/tmp/logs" {
+		t.Errorf("expected to download to /tmp/logs, got %v %+v", options.Download, options.DownloadOptions)
+	}
+	// --download_dir without --download is still accepted when tailing,
+	// and ignored as it always was
+	options, err = parseArgs([]string{"-i", "db", "--download_dir=/tmp/logs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.Download || options.Command != "" {
+		t.Errorf("expected to tail, got %q %v", options.Command, options.Download)
+	}
+	if _, err := parseArgs([]string{"backfill", "--checkpoint_file=/tmp/checkpoint"}); err == nil {
+		t.Error("expected backfill to refuse a tail flag")
+	}
+
+	// in a config file they may have a section of their own, or be in the
+	// global one as before
+	config := filepath.Join(t.TempDir(), "rdslogs.conf")
+	ioutil.WriteFile(config, []byte("[Application Options]\nidentifier = db\nleader_lease = soon\n"), 0644)
+	if _, err := parseArgs([]string{"--config", config}); err == nil || !strings.Contains(err.Error(), "rdslogs.conf:3") {
+		t.Errorf("expected an error pointing at line 3, got %v", err)
+	}
+	ioutil.WriteFile(config, []byte("[Application Options]\nidentifier = db\ncheckpoint_file = from-config\nDownloadDir = /tmp/logs\n\n[tail]\nleader_id = replica-1\n"), 0644)
+	t.Setenv("RDSLOGS_LEADER_LEASE", "45s")
+	options, err = parseArgs([]string{"--config", config})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.Tail.CheckpointFile != "from-config" || options.Tail.LeaderID != "replica-1" || options.Tail.LeaderLease != 45*time.Second {
+		t.Errorf("unexpected tail options %+v", options.Tail)
+	}
+	if options.DownloadOptions.DownloadDir != "/tmp/logs" {
+		t.Errorf("expected the download directory from the config, got %s", options.DownloadOptions.DownloadDir)
+	}
+}
+
+func TestDefaultConfigFile(t *testing.T) {
+	// rdslogs.conf is written by --write_default_config, and should be
+	// written again when the options change
+	var options cli.Options
+	flagParser := newParser(&options)
+	// which fills in the defaults
+	if _, err := flagParser.ParseArgs(nil); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	writeDefaultConfig(&buf, flagParser)
+	packaged, err := ioutil.ReadFile("rdslogs.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != string(packaged) {
+		t.Error("rdslogs.conf is out of date; regenerate it with rdslogs --write_default_config")
+	}
+}
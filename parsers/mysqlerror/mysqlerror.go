@@ -0,0 +1,127 @@
+// Package mysqlerror parses the MySQL error log (on RDS,
+// error/mysql-error-running.log).
+//
+// MySQL 8.0 writes entries like:
+//
+// 2022-05-17T10:12:03.123456Z 12 [Warning] [MY-010055] [Server] IP address '10.0.0.1' could not be resolved
+// |<-------timestamp------->|thread|severity|error_code|subsystem|<--------------message------------->|
+//
+// while 5.7 and earlier leave out the error code and subsystem, and 5.6 uses a
+// space instead of the T in the timestamp. MariaDB follows 5.6, but pads the
+// hour with a space ("2022-05-17  9:12:03"). Some messages, notably the InnoDB
+// deadlock report written with innodb_print_all_deadlocks, continue on
+// following lines that have no header; these are appended to the message.
+package mysqlerror
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+	"github.com/sirupsen/logrus"
+)
+
+var headerRegex = &parsers.ExtRegexp{Regexp: regexp.MustCompile(
+	`^(?P<timestamp>\d{4}-\d{2}-\d{2}(?:T|\s+)\d{1,2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:?\d{2})?)\s+` +
+		`(?P<thread_id>\d+)\s+\[(?P<severity>[A-Za-z]+)\]\s+` +
+		`(?:\[(?P<error_code>MY-\d+)\]\s+)?(?:\[(?P<subsystem>[A-Za-z]+)\]\s+)?`)}
+
+// 5.7 and earlier don't tag the subsystem, but InnoDB prefixes its messages
+var innodbRegex = regexp.MustCompile(`^InnoDB: `)
+
+// categories pick out the kinds of error log messages people go looking for
+var categories = []struct {
+	name string
+	re   *regexp.Regexp
+}{
+	{"deadlock", regexp.MustCompile(`(?i)deadlock`)},
+	{"aborted_connection", regexp.MustCompile(`^Aborted connection`)},
+	{"crash_recovery", regexp.MustCompile(`(?i)crash recovery`)},
+}
+
+var timestampFormats = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05",
+}
+
+type Options struct{}
+
+type Parser struct{}
+
+func (p *Parser) Init(options interface{}) error {
+	return nil
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	var groupedLines []string
+	for line := range lines {
+		if prefixRegex != nil {
+			line = strings.TrimPrefix(line, prefixRegex.FindString(line))
+		}
+		if headerRegex.MatchString(line) && len(groupedLines) > 0 {
+			send <- handleEvent(groupedLines)
+			groupedLines = make([]string, 0, 1)
+		}
+		groupedLines = append(groupedLines, line)
+	}
+	if len(groupedLines) > 0 {
+		send <- handleEvent(groupedLines)
+	}
+}
+
+// handleEvent builds an event out of a header line and its continuation lines.
+// Lines we couldn't find a header for are sent with just a message.
+func handleEvent(rawEvent []string) event.Event {
+	ev := event.Event{Data: make(map[string]interface{})}
+	header, fields := headerRegex.FindStringSubmatchMap(rawEvent[0])
+	message := strings.Join(append([]string{rawEvent[0][len(header):]}, rawEvent[1:]...), "\n")
+	ev.Data["message"] = message
+	if header == "" {
+		logrus.WithField("line", rawEvent[0]).Debug("didn't find error log header")
+		return ev
+	}
+
+	for k, v := range fields {
+		if v == "" {
+			continue
+		}
+		switch k {
+		case "timestamp":
+			ev.Timestamp = parseTimestamp(v)
+		case "thread_id":
+			if typed, err := strconv.Atoi(v); err == nil {
+				ev.Data[k] = typed
+			} else {
+				ev.Data[k] = v
+			}
+		default:
+			ev.Data[k] = v
+		}
+	}
+	if _, ok := ev.Data["subsystem"]; !ok && innodbRegex.MatchString(message) {
+		ev.Data["subsystem"] = "InnoDB"
+	}
+	for _, category := range categories {
+		if category.re.MatchString(message) {
+			ev.Data["category"] = category.name
+			break
+		}
+	}
+	return ev
+}
+
+func parseTimestamp(v string) time.Time {
+	// collapse MariaDB's space padding
+	v = strings.Join(strings.Fields(v), " ")
+	for _, format := range timestampFormats {
+		if t, err := time.Parse(format, v); err == nil {
+			return t
+		}
+	}
+	logrus.WithField("timestamp", v).Debug("Error parsing error log timestamp")
+	return time.Time{}
+}
@@ -0,0 +1,90 @@
+package mysqlerror
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+func TestProcessLines(t *testing.T) {
+	lines := []string{
+		"2022-05-17T10:12:03.123456Z 12 [Warning] [MY-010055] [Server] IP address '10.0.0.1' could not be resolved",
+		"2022-05-17T10:12:04.000000Z 31 [Note] [MY-012468] [InnoDB] Transactions deadlock detected, dumping detailed information.",
+		"*** (1) TRANSACTION:",
+		"TRANSACTION 1234, ACTIVE 0 sec starting index read",
+		"2022-05-17T10:12:05.000000Z 44 [Note] Aborted connection 44 to db: 'app' user: 'app' host: '10.0.0.2' (Got timeout reading communication packets)",
+		"2022-05-17 10:12:06 0 [Note] InnoDB: Starting crash recovery.",
+		"2022-05-17  9:12:07 0 [ERROR] mysqld: Table 'foo' is marked as crashed",
+	}
+	linesCh := make(chan string, len(lines))
+	for _, line := range lines {
+		linesCh <- line
+	}
+	close(linesCh)
+	send := make(chan event.Event, len(lines))
+	p := &Parser{}
+	p.Init(&Options{})
+	p.ProcessLines(linesCh, send, nil)
+	close(send)
+	var events []event.Event
+	for ev := range send {
+		events = append(events, ev)
+	}
+	if len(events) != 5 {
+		t.Fatalf("expected 5 events, got %d: %v", len(events), events)
+	}
+
+	expected := []map[string]interface{}{
+		{
+			"thread_id":  12,
+			"severity":   "Warning",
+			"error_code": "MY-010055",
+			"subsystem":  "Server",
+			"message":    "IP address '10.0.0.1' could not be resolved",
+		},
+		{
+			"thread_id":  31,
+			"severity":   "Note",
+			"error_code": "MY-012468",
+			"subsystem":  "InnoDB",
+			"category":   "deadlock",
+			"message": "Transactions deadlock detected, dumping detailed information.\n" +
+				"*** (1) TRANSACTION:\n" +
+				"TRANSACTION 1234, ACTIVE 0 sec starting index read",
+		},
+		{
+			"thread_id": 44,
+			"severity":  "Note",
+			"category":  "aborted_connection",
+			"message":   "Aborted connection 44 to db: 'app' user: 'app' host: '10.0.0.2' (Got timeout reading communication packets)",
+		},
+		{
+			"thread_id": 0,
+			"severity":  "Note",
+			"subsystem": "InnoDB",
+			"category":  "crash_recovery",
+			"message":   "InnoDB: Starting crash recovery.",
+		},
+		{
+			"thread_id": 0,
+			"severity":  "ERROR",
+			"message":   "mysqld: Table 'foo' is marked as crashed",
+		},
+	}
+	for i := range expected {
+		if !reflect.DeepEqual(events[i].Data, expected[i]) {
+			t.Errorf("event %d: expected %v, got %v", i, expected[i], events[i].Data)
+		}
+	}
+	if ts, _ := time.Parse(time.RFC3339Nano, "2022-05-17T10:12:03.123456Z"); !events[0].Timestamp.Equal(ts) {
+		t.Errorf("expected timestamp %s, got %s", ts, events[0].Timestamp)
+	}
+	if ts, _ := time.Parse(time.RFC3339, "2022-05-17T10:12:06Z"); !events[3].Timestamp.Equal(ts) {
+		t.Errorf("expected timestamp %s, got %s", ts, events[3].Timestamp)
+	}
+	if ts, _ := time.Parse(time.RFC3339, "2022-05-17T09:12:07Z"); !events[4].Timestamp.Equal(ts) {
+		t.Errorf("expected timestamp %s, got %s", ts, events[4].Timestamp)
+	}
+}
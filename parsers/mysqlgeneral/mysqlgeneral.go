@@ -0,0 +1,128 @@
+// Package mysqlgeneral parses the MySQL general query log (on RDS,
+// general/mysql-general.log, when log_output is FILE).
+//
+// MySQL 5.7 and later write one tab-separated entry per command:
+//
+// 2022-05-17T10:12:03.123456Z	   12 Query	SELECT * FROM account
+// |<-------timestamp------->|  |thread|command|<--argument-->|
+//
+// 5.6 uses a short "220517 10:12:03" timestamp and only writes it on the
+// first entry in each second; the entries that follow start with a tab
+// instead. Queries containing newlines continue on following lines without a
+// header. After each restart the server writes a short preamble, which is
+// skipped.
+package mysqlgeneral
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+	"github.com/sirupsen/logrus"
+)
+
+var headerRegex = &parsers.ExtRegexp{Regexp: regexp.MustCompile(
+	`^(?:(?P<timestamp>\d{4}-\d{2}-\d{2}T\S+|\d{6}\s+\d{1,2}:\d{2}:\d{2})|\t)\s*` +
+		`(?P<thread_id>\d+) (?P<command>[A-Za-z][A-Za-z ]*?)(?:\t|$)`)}
+
+var preambleRegex = regexp.MustCompile(
+	`^(?:\S+, Version: .* started with:|Tcp port: .*|Time\s+Id\s+Command\s+Argument)\s*$`)
+
+var connectRegex = &parsers.ExtRegexp{Regexp: regexp.MustCompile(
+	`^(?P<user>[^@\s]+)@(?P<client>\S+) on (?P<database>\S*)`)}
+
+// commands whose argument is a SQL statement. These are sent as the query
+// field, so that --scrub_query applies to them.
+var queryCommands = map[string]bool{
+	"Query":   true,
+	"Prepare": true,
+	"Execute": true,
+}
+
+type Options struct{}
+
+type Parser struct {
+	// 5.6 only writes the timestamp once per second, so remember the last one
+	lastTimestamp time.Time
+}
+
+func (p *Parser) Init(options interface{}) error {
+	return nil
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	var groupedLines []string
+	for line := range lines {
+		if prefixRegex != nil {
+			line = strings.TrimPrefix(line, prefixRegex.FindString(line))
+		}
+		if preambleRegex.MatchString(line) {
+			continue
+		}
+		if headerRegex.MatchString(line) && len(groupedLines) > 0 {
+			if ev := p.handleEvent(groupedLines); ev != nil {
+				send <- *ev
+			}
+			groupedLines = make([]string, 0, 1)
+		}
+		groupedLines = append(groupedLines, line)
+	}
+	if len(groupedLines) > 0 {
+		if ev := p.handleEvent(groupedLines); ev != nil {
+			send <- *ev
+		}
+	}
+}
+
+// handleEvent builds an event out of an entry and its continuation lines,
+// returning nil if it doesn't look like a general log entry at all
+func (p *Parser) handleEvent(rawEvent []string) *event.Event {
+	header, fields := headerRegex.FindStringSubmatchMap(rawEvent[0])
+	if header == "" {
+		logrus.WithField("line", rawEvent[0]).Debug("didn't find general log header, skipping line")
+		return nil
+	}
+	argument := strings.Join(append([]string{rawEvent[0][len(header):]}, rawEvent[1:]...), "\n")
+
+	ev := &event.Event{Data: make(map[string]interface{})}
+	if ts := fields["timestamp"]; ts != "" {
+		p.lastTimestamp = parseTimestamp(ts)
+	}
+	ev.Timestamp = p.lastTimestamp
+	if threadID, err := strconv.Atoi(fields["thread_id"]); err == nil {
+		ev.Data["thread_id"] = threadID
+	}
+	command := fields["command"]
+	ev.Data["command"] = command
+
+	if queryCommands[command] {
+		ev.Data["query"] = argument
+	} else if argument != "" {
+		ev.Data["argument"] = argument
+	}
+	if command == "Connect" {
+		if _, connectFields := connectRegex.FindStringSubmatchMap(argument); connectFields != nil {
+			for k, v := range connectFields {
+				if v != "" {
+					ev.Data[k] = v
+				}
+			}
+		}
+	}
+	return ev
+}
+
+func parseTimestamp(v string) time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return t
+	}
+	// 5.6 pads the hour with a space
+	if t, err := time.Parse("060102 15:04:05", strings.Join(strings.Fields(v), " ")); err == nil {
+		return t
+	}
+	logrus.WithField("timestamp", v).Debug("Error parsing general log timestamp")
+	return time.Time{}
+}
@@ -0,0 +1,88 @@
+package mysqlgeneral
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+func processLines(lines []string) []event.Event {
+	linesCh := make(chan string, len(lines))
+	for _, line := range lines {
+		linesCh <- line
+	}
+	close(linesCh)
+	send := make(chan event.Event, len(lines))
+	p := &Parser{}
+	p.Init(&Options{})
+	p.ProcessLines(linesCh, send, nil)
+	close(send)
+	var events []event.Event
+	for ev := range send {
+		events = append(events, ev)
+	}
+	return events
+}
+
+func TestProcessLines(t *testing.T) {
+	events := processLines([]string{
+		"/rdsdbbin/mysql/bin/mysqld, Version: 8.0.28 (Source distribution). started with:",
+		"Tcp port: 3306  Unix socket: /tmp/mysql.sock",
+		"Time                 Id Command    Argument",
+		"2022-05-17T10:12:03.123456Z\t   12 Connect\tapp@10.0.0.1 on prod using TCP/IP",
+		"2022-05-17T10:12:03.223456Z\t   12 Query\tSELECT *",
+		"FROM account",
+		"2022-05-17T10:12:04.000000Z\t   12 Quit\t",
+	})
+	expected := []map[string]interface{}{
+		{
+			"thread_id": 12,
+			"command":   "Connect",
+			"argument":  "app@10.0.0.1 on prod using TCP/IP",
+			"user":      "app",
+			"client":    "10.0.0.1",
+			"database":  "prod",
+		},
+		{
+			"thread_id": 12,
+			"command":   "Query",
+			"query":     "SELECT *\nFROM account",
+		},
+		{
+			"thread_id": 12,
+			"command":   "Quit",
+		},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %d: %v", len(expected), len(events), events)
+	}
+	for i := range expected {
+		if !reflect.DeepEqual(events[i].Data, expected[i]) {
+			t.Errorf("event %d: expected %v, got %v", i, expected[i], events[i].Data)
+		}
+	}
+	if ts, _ := time.Parse(time.RFC3339Nano, "2022-05-17T10:12:03.223456Z"); !events[1].Timestamp.Equal(ts) {
+		t.Errorf("expected timestamp %s, got %s", ts, events[1].Timestamp)
+	}
+}
+
+func TestProcessLines56(t *testing.T) {
+	events := processLines([]string{
+		"220517  9:12:03\t   12 Query\tSELECT 1",
+		"\t\t   13 Init DB\tprod",
+	})
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d: %v", len(events), events)
+	}
+	ts, _ := time.Parse(time.RFC3339, "2022-05-17T09:12:03Z")
+	for i, ev := range events {
+		if !ev.Timestamp.Equal(ts) {
+			t.Errorf("event %d: expected timestamp %s, got %s", i, ts, ev.Timestamp)
+		}
+	}
+	if events[1].Data["command"] != "Init DB" || events[1].Data["argument"] != "prod" {
+		t.Errorf("unexpected event %v", events[1].Data)
+	}
+}
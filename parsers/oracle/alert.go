@@ -0,0 +1,100 @@
+// Package oracle parses the logs RDS for Oracle exposes: the alert log, the
+// listener log and the OS audit trail.
+package oracle
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+	"github.com/sirupsen/logrus"
+)
+
+// The alert log (trace/alert_<SID>.log) writes a timestamp on a line of its
+// own, followed by one or more lines of message:
+//
+//	2022-05-17T10:12:03.123456+00:00
+//	ORA-00060: Deadlock detected. See Note 60.1 at My Oracle Support for Troubleshooting ORA-60 Errors. More info in file /rdsdbdata/log/diag/rdbms/orcl_a/ORCL/trace/ORCL_ora_1234.trc.
+//
+// Releases before 12c use "Tue May 17 10:12:03 2022" style timestamps.
+var alertTimestampRegex = regexp.MustCompile(
+	`^(?:\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d+)?[+-]\d{2}:\d{2}|[A-Z][a-z]{2} [A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2} \d{4})$`)
+
+var oraErrorRegex = regexp.MustCompile(`\bORA-\d+`)
+
+var alertTimestampFormats = []string{
+	"2006-01-02T15:04:05.999999999-07:00",
+	"Mon Jan _2 15:04:05 2006",
+}
+
+type AlertOptions struct{}
+
+// AlertParser parses the Oracle alert log
+type AlertParser struct{}
+
+func (p *AlertParser) Init(options interface{}) error {
+	return nil
+}
+
+func (p *AlertParser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	var timestamp time.Time
+	var message []string
+	flush := func() {
+		if len(message) == 0 {
+			return
+		}
+		send <- alertEvent(timestamp, message)
+		message = nil
+	}
+	for line := range lines {
+		if prefixRegex != nil {
+			line = strings.TrimPrefix(line, prefixRegex.FindString(line))
+		}
+		if alertTimestampRegex.MatchString(line) {
+			flush()
+			timestamp = parseTimestamp(line, alertTimestampFormats)
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		message = append(message, line)
+	}
+	flush()
+}
+
+func alertEvent(timestamp time.Time, message []string) event.Event {
+	ev := event.Event{
+		Timestamp: timestamp,
+		Data:      map[string]interface{}{"message": strings.Join(message, "\n")},
+	}
+	if errors := oraErrorRegex.FindAllString(ev.Data["message"].(string), -1); len(errors) > 0 {
+		ev.Data["error_code"] = errors[0]
+		ev.Data["ora_errors"] = strings.Join(unique(errors), " ")
+	}
+	return ev
+}
+
+func parseTimestamp(v string, formats []string) time.Time {
+	for _, format := range formats {
+		if t, err := time.Parse(format, v); err == nil {
+			return t
+		}
+	}
+	logrus.WithField("timestamp", v).Debug("Error parsing oracle log timestamp")
+	return time.Time{}
+}
+
+func unique(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	uniq := make([]string, 0, len(values))
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			uniq = append(uniq, v)
+		}
+	}
+	return uniq
+}
@@ -0,0 +1,118 @@
+package oracle
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+// With audit_trail=OS, each session writes its audit records to a file of its
+// own in audit/. The file starts with a banner describing the instance, then
+// each record is a timestamp line followed by KEY:[length] 'value' lines:
+//
+//	Tue May 17 10:12:03 2022 +00:00
+//	LENGTH : '275'
+//	ACTION :[7] 'CONNECT'
+//	DATABASE USER:[1] '/'
+//	PRIVILEGE :[6] 'SYSDBA'
+//	CLIENT USER:[6] 'rdsdb'
+//	STATUS:[1] '0'
+//
+// Values may contain newlines, which is why they're length-prefixed.
+var auditTimestampRegex = regexp.MustCompile(`^[A-Z][a-z]{2} [A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2} \d{4} [+-]\d{2}:\d{2}$`)
+
+var auditKeyRegex = regexp.MustCompile(`^([A-Z][A-Z ]*?)\s*:\s*(?:\[(\d+)\])?\s*'`)
+
+type AuditOptions struct{}
+
+// AuditParser parses Oracle OS audit trail files
+type AuditParser struct{}
+
+func (p *AuditParser) Init(options interface{}) error {
+	return nil
+}
+
+func (p *AuditParser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	var timestampLine string
+	var record []string
+	flush := func() {
+		if timestampLine != "" {
+			send <- auditEvent(timestampLine, strings.Join(record, "\n"))
+		}
+		timestampLine = ""
+		record = nil
+	}
+	for line := range lines {
+		if prefixRegex != nil {
+			line = strings.TrimPrefix(line, prefixRegex.FindString(line))
+		}
+		if auditTimestampRegex.MatchString(line) {
+			flush()
+			timestampLine = line
+			continue
+		}
+		// lines before the first timestamp are the file banner
+		if timestampLine != "" {
+			record = append(record, line)
+		}
+	}
+	flush()
+}
+
+func auditEvent(timestampLine, record string) event.Event {
+	ev := event.Event{
+		Timestamp: parseTimestamp(timestampLine, []string{"Mon Jan _2 15:04:05 2006 -07:00"}),
+		Data:      make(map[string]interface{}),
+	}
+	for k, v := range parseAuditRecord(record) {
+		if typed, err := strconv.Atoi(v); err == nil && k != "client_terminal" {
+			ev.Data[k] = typed
+		} else {
+			ev.Data[k] = v
+		}
+	}
+	return ev
+}
+
+// parseAuditRecord reads the KEY:[length] 'value' pairs in a record, using
+// the length where given so that values may contain quotes and newlines.
+func parseAuditRecord(record string) map[string]string {
+	fields := make(map[string]string)
+	for len(record) > 0 {
+		match := auditKeyRegex.FindStringSubmatch(record)
+		if match == nil {
+			// skip anything we don't understand up to the next line
+			next := strings.IndexByte(record, '\n')
+			if next < 0 {
+				break
+			}
+			record = record[next+1:]
+			continue
+		}
+		key := strings.ToLower(strings.Replace(strings.TrimSpace(match[1]), " ", "_", -1))
+		record = record[len(match[0]):]
+		var value string
+		if length, err := strconv.Atoi(match[2]); err == nil && length <= len(record) {
+			value = record[:length]
+			record = record[length:]
+		} else {
+			end := strings.Index(record, "'\n")
+			if end < 0 {
+				end = strings.LastIndexByte(record, '\'')
+			}
+			if end < 0 {
+				end = len(record)
+			}
+			value = record[:end]
+			record = record[end:]
+		}
+		fields[key] = value
+		// step past the closing quote and newline
+		record = strings.TrimPrefix(record, "'")
+		record = strings.TrimPrefix(record, "\n")
+	}
+	return fields
+}
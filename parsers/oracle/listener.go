@@ -0,0 +1,127 @@
+package oracle
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+	"github.com/sirupsen/logrus"
+)
+
+// The listener log (trace/listener.log) writes one " * " separated record per
+// connection or service event:
+//
+//	17-MAY-2022 10:12:03 * (CONNECT_DATA=(SERVICE_NAME=ORCL)(CID=(PROGRAM=sqlplus)(HOST=client)(USER=alice))) * (ADDRESS=(PROTOCOL=tcp)(HOST=10.0.0.1)(PORT=51234)) * establish * ORCL * 0
+//	17-MAY-2022 10:12:04 * service_update * ORCL * 0
+//
+// Failed connections are followed by the TNS error on lines of their own.
+var listenerTimestampRegex = regexp.MustCompile(`^\d{2}-[A-Za-z]{3}-\d{4} \d{2}:\d{2}:\d{2} \* `)
+
+// picks (KEY=value) pairs out of a TNS descriptor
+var descriptorRegex = regexp.MustCompile(`\(([A-Z_]+)=([^()]*)\)`)
+
+var tnsErrorRegex = regexp.MustCompile(`\bTNS-\d+`)
+
+// descriptor keys we keep, and the field names we keep them as
+var connectDataFields = map[string]string{
+	"SERVICE_NAME": "service_name",
+	"SID":          "sid",
+	"PROGRAM":      "program",
+	"HOST":         "client_host",
+	"USER":         "client_user",
+}
+
+var addressFields = map[string]string{
+	"PROTOCOL": "protocol",
+	"HOST":     "client_address",
+	"PORT":     "client_port",
+}
+
+type ListenerOptions struct{}
+
+// ListenerParser parses the Oracle listener log
+type ListenerParser struct{}
+
+func (p *ListenerParser) Init(options interface{}) error {
+	return nil
+}
+
+func (p *ListenerParser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	var groupedLines []string
+	for line := range lines {
+		if prefixRegex != nil {
+			line = strings.TrimPrefix(line, prefixRegex.FindString(line))
+		}
+		if listenerTimestampRegex.MatchString(line) && len(groupedLines) > 0 {
+			if ev := listenerEvent(groupedLines); ev != nil {
+				send <- *ev
+			}
+			groupedLines = make([]string, 0, 1)
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		groupedLines = append(groupedLines, line)
+	}
+	if len(groupedLines) > 0 {
+		if ev := listenerEvent(groupedLines); ev != nil {
+			send <- *ev
+		}
+	}
+}
+
+// listenerEvent builds an event out of a record and the error lines that
+// follow it, returning nil for the banner lines written at startup
+func listenerEvent(rawEvent []string) *event.Event {
+	if !listenerTimestampRegex.MatchString(rawEvent[0]) {
+		logrus.WithField("line", rawEvent[0]).Debug("didn't find listener log timestamp, skipping line")
+		return nil
+	}
+	parts := strings.Split(rawEvent[0], " * ")
+	ev := &event.Event{
+		Timestamp: parseTimestamp(parts[0], []string{"02-Jan-2006 15:04:05"}),
+		Data:      make(map[string]interface{}),
+	}
+	switch len(parts) {
+	case 6:
+		// timestamp * connect data * address * event * service * return code
+		addDescriptor(parts[1], connectDataFields, ev.Data)
+		addDescriptor(parts[2], addressFields, ev.Data)
+		parts = append(parts[:1], parts[3:]...)
+		fallthrough
+	case 4:
+		// timestamp * event * service * return code
+		ev.Data["event"] = parts[1]
+		ev.Data["service"] = parts[2]
+		if code, err := strconv.Atoi(strings.TrimSpace(parts[3])); err == nil {
+			ev.Data["return_code"] = code
+		}
+	default:
+		ev.Data["message"] = strings.Join(parts[1:], " * ")
+	}
+	if len(rawEvent) > 1 {
+		errorMessage := strings.Join(rawEvent[1:], "\n")
+		ev.Data["error_message"] = errorMessage
+		if code := tnsErrorRegex.FindString(errorMessage); code != "" {
+			ev.Data["error_code"] = code
+		}
+	}
+	return ev
+}
+
+// addDescriptor copies the interesting values out of a TNS descriptor
+func addDescriptor(descriptor string, fields map[string]string, data map[string]interface{}) {
+	for _, match := range descriptorRegex.FindAllStringSubmatch(descriptor, -1) {
+		if name, ok := fields[match[1]]; ok && match[2] != "" {
+			if name == "client_port" {
+				if port, err := strconv.Atoi(match[2]); err == nil {
+					data[name] = port
+					continue
+				}
+			}
+			data[name] = match[2]
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package oracle
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+func processLines(p parsers.Parser, lines []string) []event.Event {
+	linesCh := make(chan string, len(lines))
+	for _, line := range lines {
+		linesCh <- line
+	}
+	close(linesCh)
+	send := make(chan event.Event, len(lines))
+	p.Init(nil)
+	p.ProcessLines(linesCh, send, nil)
+	close(send)
+	var events []event.Event
+	for ev := range send {
+		events = append(events, ev)
+	}
+	return events
+}
+
+func TestAlertParser(t *testing.T) {
+	events := processLines(&AlertParser{}, []string{
+		"2022-05-17T10:12:03.123456+00:00",
+		"Thread 1 advanced to log sequence 123 (LGWR switch)",
+		"  Current log# 2 seq# 123 mem# 0: /rdsdbdata/db/ORCL_A/onlinelog/o1_mf_2.log",
+		"Tue May 17 10:12:04 2022",
+		"Errors in file /rdsdbdata/log/diag/rdbms/orcl_a/ORCL/trace/ORCL_ora_1234.trc:",
+		"ORA-00060: Deadlock detected. ORA-00060 again",
+	})
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d: %v", len(events), events)
+	}
+	if msg := events[0].Data["message"]; msg != "Thread 1 advanced to log sequence 123 (LGWR switch)\n"+
+		"  Current log# 2 seq# 123 mem# 0: /rdsdbdata/db/ORCL_A/onlinelog/o1_mf_2.log" {
+		t.Errorf("unexpected message %q", msg)
+	}
+	if ts, _ := time.Parse(time.RFC3339Nano, "2022-05-17T10:12:03.123456Z"); !events[0].Timestamp.Equal(ts) {
+		t.Errorf("expected timestamp %s, got %s", ts, events[0].Timestamp)
+	}
+	if events[1].Data["error_code"] != "ORA-00060" || events[1].Data["ora_errors"] != "ORA-00060" {
+		t.Errorf("unexpected errors in %v", events[1].Data)
+	}
+	if ts, _ := time.Parse(time.RFC3339, "2022-05-17T10:12:04Z"); !events[1].Timestamp.Equal(ts) {
+		t.Errorf("expected timestamp %s, got %s", ts, events[1].Timestamp)
+	}
+}
+
+func TestListenerParser(t *testing.T) {
+	events := processLines(&ListenerParser{}, []string{
+		"17-MAY-2022 10:12:03 * (CONNECT_DATA=(SERVICE_NAME=ORCL)(CID=(PROGRAM=sqlplus)(HOST=client)(USER=alice))) * (ADDRESS=(PROTOCOL=tcp)(HOST=10.0.0.1)(PORT=51234)) * establish * ORCL * 0",
+		"17-MAY-2022 10:12:04 * service_update * ORCL * 0",
+		"17-MAY-2022 10:12:05 * (CONNECT_DATA=(SERVICE_NAME=NOPE)(CID=(PROGRAM=sqlplus)(HOST=client)(USER=bob))) * (ADDRESS=(PROTOCOL=tcp)(HOST=10.0.0.2)(PORT=51235)) * establish * NOPE * 12514",
+		"TNS-12514: TNS:listener does not currently know of service requested in connect descriptor",
+	})
+	expected := []map[string]interface{}{
+		{
+			"service_name":   "ORCL",
+			"program":        "sqlplus",
+			"client_host":    "client",
+			"client_user":    "alice",
+			"protocol":       "tcp",
+			"client_address": "10.0.0.1",
+			"client_port":    51234,
+			"event":          "establish",
+			"service":        "ORCL",
+			"return_code":    0,
+		},
+		{
+			"event":       "service_update",
+			"service":     "ORCL",
+			"return_code": 0,
+		},
+		{
+			"service_name":   "NOPE",
+			"program":        "sqlplus",
+			"client_host":    "client",
+			"client_user":    "bob",
+			"protocol":       "tcp",
+			"client_address": "10.0.0.2",
+			"client_port":    51235,
+			"event":          "establish",
+			"service":        "NOPE",
+			"return_code":    12514,
+			"error_code":     "TNS-12514",
+			"error_message":  "TNS-12514: TNS:listener does not currently know of service requested in connect descriptor",
+		},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %d: %v", len(expected), len(events), events)
+	}
+	for i := range expected {
+		if !reflect.DeepEqual(events[i].Data, expected[i]) {
+			t.Errorf("event %d: expected %v, got %v", i, expected[i], events[i].Data)
+		}
+	}
+	if ts, _ := time.Parse(time.RFC3339, "2022-05-17T10:12:03Z"); !events[0].Timestamp.Equal(ts) {
+		t.Errorf("expected timestamp %s, got %s", ts, events[0].Timestamp)
+	}
+}
+
+func TestAuditParser(t *testing.T) {
+	events := processLines(&AuditParser{}, []string{
+		"Audit file /rdsdbdata/admin/ORCL/adump/ORCL_ora_1234_20220517101203.aud",
+		"Oracle Database 19c Enterprise Edition Release 19.0.0.0.0 - Production",
+		"Tue May 17 10:12:03 2022 +00:00",
+		"LENGTH : '275'",
+		"ACTION :[7] 'CONNECT'",
+		"DATABASE USER:[1] '/'",
+		"CLIENT TERMINAL:[0] ''",
+		"STATUS:[1] '0'",
+		"",
+		"Tue May 17 10:12:04 2022 +00:00",
+		"ACTION :[20] 'select 'a'",
+		"from dual'",
+		"STATUS:[1] '0'",
+	})
+	expected := []map[string]interface{}{
+		{
+			"length":          275,
+			"action":          "CONNECT",
+			"database_user":   "/",
+			"client_terminal": "",
+			"status":          0,
+		},
+		{
+			"action": "select 'a'\nfrom dual",
+			"status": 0,
+		},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %d: %v", len(expected), len(events), events)
+	}
+	for i := range expected {
+		if !reflect.DeepEqual(events[i].Data, expected[i]) {
+			t.Errorf("event %d: expected %v, got %v", i, expected[i], events[i].Data)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// Package pgaudit parses pgaudit records out of the PostgreSQL log.
+//
+// The pgaudit extension writes its records in to the regular postgres log at
+// level LOG, as a CSV-ish message following an "AUDIT: " marker:
+//
+// 2022-05-17 10:12:03 UTC:10.0.0.1(5432):app@prod:[1234]:LOG:  AUDIT: SESSION,1,1,READ,SELECT,TABLE,public.account,"select * from account where id = 1",<not logged>
+// |<------------------------prefix----------------------->|level|     |<-------------------------------------record------------------------------------->|
+//
+// The record fields are audit_type, statement_id, substatement_id, class,
+// command, object_type, object_name, statement and parameter. Statements
+// containing commas or quotes are quoted, and statements containing newlines
+// continue on following tab-indented lines, like any other multi-line postgres
+// message.
+package pgaudit
+
+import (
+	"encoding/csv"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+	"github.com/sirupsen/logrus"
+
+	"github.com/honeycombio/rdslogs/parsers/pglog"
+)
+
+const (
+	auditMarker = "AUDIT: "
+	// same default as honeytail's postgresql parser
+	defaultPrefix = "%t [%p-%l] %u@%d"
+)
+
+var auditFields = []string{
+	"audit_type",
+	"statement_id",
+	"substatement_id",
+	"class",
+	"command",
+	"object_type",
+	"object_name",
+	"statement",
+	"parameter",
+}
+
+type Options struct {
+	// LogLinePrefix is the postgres log_line_prefix format
+	LogLinePrefix string
+	// PassThrough sends non-audit log statements along with their prefix
+	// fields, level and message instead of dropping them
+	PassThrough bool
+}
+
+type Parser struct {
+	pgPrefixRegex *parsers.ExtRegexp
+	passThrough   bool
+}
+
+func (p *Parser) Init(options interface{}) (err error) {
+	conf, ok := options.(*Options)
+	if !ok {
+		conf = &Options{}
+	}
+	logLinePrefixFormat := conf.LogLinePrefix
+	if logLinePrefixFormat == "" {
+		logLinePrefixFormat = defaultPrefix
+	}
+	p.passThrough = conf.PassThrough
+	p.pgPrefixRegex, err = pglog.BuildPrefixRegexp(logLinePrefixFormat)
+	return err
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	rawEvents := make(chan []string)
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for rawEvent := range rawEvents {
+			if ev := p.handleEvent(rawEvent); ev != nil {
+				send <- *ev
+			}
+		}
+	}()
+	pglog.GroupLines(lines, rawEvents, prefixRegex)
+	wg.Wait()
+}
+
+// handleEvent parses a single log statement, returning nil if it should be
+// dropped
+func (p *Parser) handleEvent(rawEvent []string) *event.Event {
+	match, suffix, prefixFields := pglog.ParsePrefix(p.pgPrefixRegex, rawEvent[0])
+	if !match {
+		logrus.WithField("line", rawEvent[0]).Debug("Log line prefix didn't match expected format")
+		return nil
+	}
+	level, message, _ := pglog.ParseLevel(suffix)
+	for _, line := range rawEvent[1:] {
+		message += "\n" + strings.TrimPrefix(line, "\t")
+	}
+
+	ev := &event.Event{Data: make(map[string]interface{})}
+	pglog.AddFields(prefixFields, ev)
+	if level != "" {
+		ev.Data["level"] = level
+	}
+
+	if !strings.HasPrefix(message, auditMarker) {
+		if !p.passThrough {
+			return nil
+		}
+		ev.Data["message"] = message
+		return ev
+	}
+
+	record, err := parseRecord(strings.TrimPrefix(message, auditMarker))
+	if err != nil {
+		logrus.WithError(err).WithField("message", message).Debug("Unable to parse audit record")
+		if !p.passThrough {
+			return nil
+		}
+		ev.Data["message"] = message
+		return ev
+	}
+	for k, v := range record {
+		ev.Data[k] = v
+	}
+	return ev
+}
+
+// parseRecord splits a pgaudit record in to its fields
+func parseRecord(record string) (map[string]interface{}, error) {
+	r := csv.NewReader(strings.NewReader(record))
+	r.LazyQuotes = true
+	r.FieldsPerRecord = -1
+	values, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	// with pgaudit.log_parameter on, the parameters are themselves written as
+	// unquoted CSV on the end of the record
+	if len(values) > len(auditFields) {
+		last := len(auditFields) - 1
+		values = append(values[:last], strings.Join(values[last:], ","))
+	}
+	fields := make(map[string]interface{}, len(values))
+	for i, v := range values {
+		name := auditFields[i]
+		switch name {
+		case "statement_id", "substatement_id":
+			if typed, err := strconv.Atoi(v); err == nil {
+				fields[name] = typed
+				continue
+			}
+		}
+		fields[name] = v
+	}
+	return fields, nil
+}
@@ -0,0 +1,90 @@
+package pgaudit
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+const rdsPrefix = "%t:%r:%u@%d:[%p]:"
+
+func processLines(t *testing.T, opts *Options, lines []string) []event.Event {
+	p := &Parser{}
+	if err := p.Init(opts); err != nil {
+		t.Fatal(err)
+	}
+	linesCh := make(chan string, len(lines))
+	for _, line := range lines {
+		linesCh <- line
+	}
+	close(linesCh)
+	send := make(chan event.Event, len(lines))
+	p.ProcessLines(linesCh, send, nil)
+	close(send)
+	var events []event.Event
+	for ev := range send {
+		events = append(events, ev)
+	}
+	return events
+}
+
+func TestProcessLines(t *testing.T) {
+	lines := []string{
+		`2022-05-17 10:12:03 UTC:10.0.0.1(5432):app@prod:[1234]:LOG:  AUDIT: SESSION,1,1,READ,SELECT,TABLE,public.account,"select a, b from account",<not logged>`,
+		`2022-05-17 10:12:04 UTC:10.0.0.1(5432):app@prod:[1234]:LOG:  duration: 1.234 ms  statement: select 1`,
+		`2022-05-17 10:12:05 UTC:10.0.0.1(5432):app@prod:[1234]:LOG:  AUDIT: SESSION,2,1,WRITE,UPDATE,TABLE,public.account,"update account`,
+		"\tset name = 'x'\",1,x",
+	}
+	events := processLines(t, &Options{LogLinePrefix: rdsPrefix}, lines)
+	if len(events) != 2 {
+		t.Fatalf("expected 2 audit events, got %d: %v", len(events), events)
+	}
+	expected := map[string]interface{}{
+		"audit_type":      "SESSION",
+		"statement_id":    1,
+		"substatement_id": 1,
+		"class":           "READ",
+		"command":         "SELECT",
+		"object_type":     "TABLE",
+		"object_name":     "public.account",
+		"statement":       "select a, b from account",
+		"parameter":       "<not logged>",
+		"level":           "LOG",
+		"host_port":       "10.0.0.1(5432)",
+		"user":            "app",
+		"database":        "prod",
+		"pid":             1234,
+	}
+	if !reflect.DeepEqual(events[0].Data, expected) {
+		t.Errorf("expected %v, got %v", expected, events[0].Data)
+	}
+	if ts, _ := time.Parse(time.RFC3339, "2022-05-17T10:12:03Z"); !events[0].Timestamp.Equal(ts) {
+		t.Errorf("expected timestamp %s, got %s", ts, events[0].Timestamp)
+	}
+
+	// multi-line statements are reassembled and extra parameters are kept
+	if stmt := events[1].Data["statement"]; stmt != "update account\nset name = 'x'" {
+		t.Errorf("unexpected multi-line statement %q", stmt)
+	}
+	if param := events[1].Data["parameter"]; param != "1,x" {
+		t.Errorf("unexpected parameter %q", param)
+	}
+}
+
+func TestPassThrough(t *testing.T) {
+	lines := []string{
+		`2022-05-17 10:12:04 UTC:10.0.0.1(5432):app@prod:[1234]:ERROR:  relation "nope" does not exist`,
+	}
+	if events := processLines(t, &Options{LogLinePrefix: rdsPrefix}, lines); len(events) != 0 {
+		t.Errorf("expected non-audit lines to be dropped, got %v", events)
+	}
+	events := processLines(t, &Options{LogLinePrefix: rdsPrefix, PassThrough: true}, lines)
+	if len(events) != 1 {
+		t.Fatalf("expected 1 passed through event, got %d", len(events))
+	}
+	if events[0].Data["level"] != "ERROR" || events[0].Data["message"] != `relation "nope" does not exist` {
+		t.Errorf("unexpected passed through event %v", events[0].Data)
+	}
+}
@@ -0,0 +1,144 @@
+// Package pglog contains helpers shared by the parsers for the various kinds of
+// records found in PostgreSQL's log: splitting lines into log statements,
+// and parsing the `log_line_prefix` and level off the front of each.
+//
+// The prefix handling mirrors honeytail's postgresql parser, which doesn't
+// export it.
+package pglog
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+	"github.com/sirupsen/logrus"
+)
+
+// Regex string that matches timestamps in log
+const timestampRe = `\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}[.0-9]* [A-Z]+`
+
+// levelRegex matches the severity that follows the prefix, eg "LOG:  "
+var levelRegex = &parsers.ExtRegexp{Regexp: regexp.MustCompile(`^\s*(?P<level>[A-Z0-9]+):\s+`)}
+
+// prefixValues maps log_line_prefix format specifiers to the field name and
+// pattern they produce
+var prefixValues = map[string]struct {
+	Name    string
+	Pattern string
+}{
+	"%a": {Name: "application", Pattern: "\\S+"},
+	"%u": {Name: "user", Pattern: "\\S+"},
+	"%d": {Name: "database", Pattern: "\\S+"},
+	"%r": {Name: "host_port", Pattern: "\\S+"},
+	"%h": {Name: "host", Pattern: "\\S+"},
+	"%p": {Name: "pid", Pattern: "\\d+"},
+	"%t": {Name: "timestamp", Pattern: timestampRe},
+	"%m": {Name: "timestamp_millis", Pattern: timestampRe},
+	"%n": {Name: "timestamp_unix", Pattern: "\\d+"},
+	"%i": {Name: "command_tag", Pattern: "\\S+"},
+	"%e": {Name: "sql_state", Pattern: "\\S+"},
+	"%c": {Name: "session_id", Pattern: "\\d+"},
+	"%l": {Name: "session_line_number", Pattern: "\\d+"},
+	"%s": {Name: "session_start", Pattern: timestampRe},
+	"%v": {Name: "virtual_transaction_id", Pattern: "\\S+"},
+	"%x": {Name: "transaction_id", Pattern: "\\S+"},
+}
+
+// BuildPrefixRegexp compiles a log_line_prefix format string in to a regex
+// with a named group for each format specifier.
+func BuildPrefixRegexp(prefixFormat string) (*parsers.ExtRegexp, error) {
+	prefixFormat = strings.Replace(prefixFormat, "%%", "%", -1)
+	// %q means "stop here in non-session processes"; the records we care about
+	// always come from sessions.
+	prefixFormat = strings.Replace(prefixFormat, "%q", "", -1)
+	prefixFormat = regexp.QuoteMeta(prefixFormat)
+	for k, v := range prefixValues {
+		prefixFormat = strings.Replace(prefixFormat, k, "(?P<"+v.Name+">"+v.Pattern+")", -1)
+	}
+	re, err := regexp.Compile("^" + prefixFormat)
+	if err != nil {
+		return nil, err
+	}
+	return &parsers.ExtRegexp{Regexp: re}, nil
+}
+
+// ParsePrefix matches re against the start of line, returning the named groups
+// and whatever follows the match.
+func ParsePrefix(re *parsers.ExtRegexp, line string) (matched bool, suffix string, fields map[string]string) {
+	prefix, fields := re.FindStringSubmatchMap(line)
+	if prefix == "" {
+		return false, "", nil
+	}
+	return true, line[len(prefix):], fields
+}
+
+// ParseLevel splits the severity (LOG, ERROR, ...) off the front of the text
+// following the prefix.
+func ParseLevel(suffix string) (level string, message string, ok bool) {
+	matched, message, fields := ParsePrefix(levelRegex, suffix)
+	if !matched {
+		return "", suffix, false
+	}
+	return fields["level"], message, true
+}
+
+// IsContinuationLine reports whether line continues the previous log
+// statement. Postgres indents all but the first line of multi-line messages
+// with a tab.
+func IsContinuationLine(line string) bool {
+	return strings.HasPrefix(line, "\t")
+}
+
+// GroupLines reads lines and sends each log statement, as the list of lines
+// that make it up, to rawEvents. It closes rawEvents when lines is closed.
+func GroupLines(lines <-chan string, rawEvents chan<- []string, prefixRegex *parsers.ExtRegexp) {
+	var groupedLines []string
+	for line := range lines {
+		if prefixRegex != nil {
+			// this is the global --log_prefix style regex for stripping
+			// syslog-type prefixes, not the postgres log_line_prefix
+			line = strings.TrimPrefix(line, prefixRegex.FindString(line))
+		}
+		if !IsContinuationLine(line) && len(groupedLines) > 0 {
+			rawEvents <- groupedLines
+			groupedLines = make([]string, 0, 1)
+		}
+		groupedLines = append(groupedLines, line)
+	}
+	if len(groupedLines) > 0 {
+		rawEvents <- groupedLines
+	}
+	close(rawEvents)
+}
+
+// AddFields adds the fields parsed from a log line prefix to ev, converting
+// numeric values and using the timestamp as the event's time.
+func AddFields(fields map[string]string, ev *event.Event) {
+	for k, v := range fields {
+		switch k {
+		case "session_id", "pid", "session_line_number":
+			if typed, err := strconv.Atoi(v); err == nil {
+				ev.Data[k] = typed
+			} else {
+				ev.Data[k] = v
+			}
+		case "timestamp", "timestamp_millis":
+			if timestamp, err := time.Parse("2006-01-02 15:04:05.999 MST", v); err == nil {
+				ev.Timestamp = timestamp
+			} else {
+				logrus.WithField("timestamp", v).WithError(err).Debug("Error parsing query timestamp")
+			}
+		case "timestamp_unix":
+			if typed, err := strconv.Atoi(v); err == nil {
+				ev.Timestamp = time.Unix(int64(typed/1000), int64((1000*1000)*(typed%1000))).UTC()
+			} else {
+				logrus.WithField("timestamp", v).WithError(err).Debug("Error parsing query timestamp")
+			}
+		default:
+			ev.Data[k] = v
+		}
+	}
+}
@@ -0,0 +1,383 @@
+// Package pgquery parses the PostgreSQL slow query log, including the query
+// plans written by the auto_explain extension.
+//
+// Slow query statements ("duration: ... statement: ...") are handed to
+// honeytail's postgresql parser. auto_explain logs a plan, in text or JSON
+// format, as a multi-line message:
+//
+//	2022-05-17 10:12:03 UTC:10.0.0.1(5432):app@prod:[1234]:LOG:  duration: 12.345 ms  plan:
+//		Query Text: select * from account where id = 1
+//		Seq Scan on account  (cost=0.00..35.50 rows=10 width=4) (actual time=0.010..0.020 rows=1 loops=1)
+//		  Filter: (id = 1)
+//
+// auto_explain logs the plan just before postgres logs the statement's
+// duration, so when both are enabled the plan is attached to the statement's
+// event from the same backend. Plans that no statement turns up for within
+// planWait are sent as events of their own.
+package pgquery
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+	"github.com/honeycombio/honeytail/parsers/postgresql"
+	"github.com/honeycombio/mysqltools/query/normalizer"
+	"github.com/sirupsen/logrus"
+
+	"github.com/honeycombio/rdslogs/parsers/pglog"
+)
+
+const (
+	// same default as honeytail's postgresql parser
+	defaultPrefix = "%t [%p-%l] %u@%d"
+	// Seq Scans expected to read at least this many rows are reported
+	defaultSeqScanRows = 10000
+	// how long to hold on to a plan waiting for its statement
+	planWait = 10 * time.Second
+)
+
+var planHeaderRegex = &parsers.ExtRegexp{Regexp: regexp.MustCompile(
+	`^duration: (?P<duration>[0-9\.]+) ms\s+plan:\s*`)}
+
+// matches a plan node in the text format, eg
+// ->  Index Scan using account_pkey on account a  (cost=0.29..8.30 rows=1 width=4) (actual time=0.01..0.01 rows=1 loops=1)
+var textNodeRegex = &parsers.ExtRegexp{Regexp: regexp.MustCompile(
+	`^(?P<indent>\s*)(?:->\s+)?(?P<node_type>[A-Z][A-Za-z ]*?)` +
+		`(?: using \S+)?(?: on (?P<relation>\S+)(?: \S+)?)?` +
+		`\s+\(cost=[0-9.]+\.\.(?P<total_cost>[0-9.]+) rows=(?P<plan_rows>\d+) width=\d+\)` +
+		`(?: \(actual time=[0-9.]+\.\.[0-9.]+ rows=(?P<actual_rows>\d+) loops=\d+\))?`)}
+
+type Options struct {
+	// LogLinePrefix is the postgres log_line_prefix format
+	LogLinePrefix string
+	// SeqScanRows is the number of rows above which a Seq Scan is reported in
+	// plan_seq_scans
+	SeqScanRows int
+}
+
+type Parser struct {
+	pgPrefixRegex *parsers.ExtRegexp
+	seqScanRows   float64
+	inner         *postgresql.Parser
+
+	mu sync.Mutex
+	// pending holds the plans waiting for their statements, oldest first for
+	// each statement, as the same query can run again before the first
+	// statement comes out of the inner parser
+	pending map[string][]*pendingPlan
+	nowFunc func() time.Time
+}
+
+// pendingPlan is a plan waiting for the statement it belongs to
+type pendingPlan struct {
+	ev       event.Event
+	received time.Time
+}
+
+func (p *Parser) Init(options interface{}) error {
+	conf, ok := options.(*Options)
+	if !ok {
+		conf = &Options{}
+	}
+	logLinePrefixFormat := conf.LogLinePrefix
+	if logLinePrefixFormat == "" {
+		logLinePrefixFormat = defaultPrefix
+	}
+	p.seqScanRows = float64(conf.SeqScanRows)
+	if p.seqScanRows == 0 {
+		p.seqScanRows = defaultSeqScanRows
+	}
+	var err error
+	if p.pgPrefixRegex, err = pglog.BuildPrefixRegexp(logLinePrefixFormat); err != nil {
+		return err
+	}
+	p.pending = make(map[string][]*pendingPlan)
+	p.nowFunc = time.Now
+	p.inner = &postgresql.Parser{}
+	return p.inner.Init(&postgresql.Options{LogLinePrefix: logLinePrefixFormat})
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	innerLines := make(chan string)
+	statements := make(chan event.Event)
+	go func() {
+		p.inner.ProcessLines(innerLines, statements, nil)
+		close(statements)
+	}()
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		p.attachPlans(statements, send)
+	}()
+
+	rawEvents := make(chan []string)
+	go pglog.GroupLines(lines, rawEvents, prefixRegex)
+	for rawEvent := range rawEvents {
+		if p.handlePlan(rawEvent) {
+			continue
+		}
+		for _, line := range rawEvent {
+			innerLines <- line
+		}
+	}
+	close(innerLines)
+	wg.Wait()
+}
+
+// attachPlans adds any pending plan to the statement events it belongs to,
+// and sends plans that have waited too long on their own
+func (p *Parser) attachPlans(statements <-chan event.Event, send chan<- event.Event) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev, ok := <-statements:
+			if !ok {
+				for _, plan := range p.expirePlans(true) {
+					send <- plan
+				}
+				return
+			}
+			p.mu.Lock()
+			key := planKey(ev.Data["pid"], ev.Data["query"])
+			if plans := p.pending[key]; len(plans) > 0 {
+				plan := plans[0]
+				if len(plans) == 1 {
+					delete(p.pending, key)
+				} else {
+					p.pending[key] = plans[1:]
+				}
+				for k, v := range plan.ev.Data {
+					if k == "plan" || strings.HasPrefix(k, "plan_") {
+						ev.Data[k] = v
+					}
+				}
+			}
+			p.mu.Unlock()
+			send <- ev
+		case <-ticker.C:
+			for _, plan := range p.expirePlans(false) {
+				send <- plan
+			}
+		}
+	}
+}
+
+// expirePlans removes and returns the plans that have waited longer than
+// planWait, or all of them if all is set
+func (p *Parser) expirePlans(all bool) []event.Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var expired []event.Event
+	for key, plans := range p.pending {
+		// each queue is oldest first, so the expired plans are at its front
+		n := 0
+		for n < len(plans) && (all || p.nowFunc().Sub(plans[n].received) >= planWait) {
+			expired = append(expired, plans[n].ev)
+			n++
+		}
+		if n == len(plans) {
+			delete(p.pending, key)
+		} else {
+			p.pending[key] = plans[n:]
+		}
+	}
+	return expired
+}
+
+// handlePlan parses rawEvent if it's an auto_explain plan, holding on to it
+// for its statement. It returns false if rawEvent isn't a plan.
+func (p *Parser) handlePlan(rawEvent []string) bool {
+	match, suffix, prefixFields := pglog.ParsePrefix(p.pgPrefixRegex, rawEvent[0])
+	if !match {
+		return false
+	}
+	level, message, _ := pglog.ParseLevel(suffix)
+	match, firstLine, headerFields := pglog.ParsePrefix(planHeaderRegex, message)
+	if !match {
+		return false
+	}
+	planLines := make([]string, 0, len(rawEvent))
+	if strings.TrimSpace(firstLine) != "" {
+		planLines = append(planLines, firstLine)
+	}
+	for _, line := range rawEvent[1:] {
+		planLines = append(planLines, strings.TrimPrefix(line, "\t"))
+	}
+
+	var query string
+	var plan map[string]interface{}
+	var err error
+	if len(planLines) > 0 && strings.HasPrefix(strings.TrimSpace(planLines[0]), "{") {
+		query, plan, err = parseJSONPlan(strings.Join(planLines, "\n"))
+	} else {
+		query, plan, err = parseTextPlan(planLines)
+	}
+	if err != nil {
+		logrus.WithError(err).WithField("line", rawEvent[0]).Debug("unable to parse auto_explain plan")
+		return false
+	}
+
+	ev := event.Event{Data: make(map[string]interface{})}
+	pglog.AddFields(prefixFields, &ev)
+	if level != "" {
+		ev.Data["level"] = level
+	}
+	if duration, err := strconv.ParseFloat(headerFields["duration"], 64); err == nil {
+		ev.Data["duration"] = duration
+	}
+	if query != "" {
+		n := normalizer.Parser{}
+		ev.Data["query"] = query
+		ev.Data["normalized_query"] = n.NormalizeQuery(query)
+		if len(n.LastTables) > 0 {
+			ev.Data["tables"] = strings.Join(n.LastTables, " ")
+		}
+	}
+	ev.Data["plan"] = plan
+	p.addPlanFields(plan, ev.Data)
+
+	p.mu.Lock()
+	key := planKey(ev.Data["pid"], query)
+	p.pending[key] = append(p.pending[key], &pendingPlan{ev: ev, received: p.nowFunc()})
+	p.mu.Unlock()
+	return true
+}
+
+// addPlanFields derives the plan_* summary fields from a plan's root node
+func (p *Parser) addPlanFields(plan map[string]interface{}, data map[string]interface{}) {
+	if nodeType, ok := plan["Node Type"]; ok {
+		data["plan_node_type"] = nodeType
+	}
+	if cost, ok := plan["Total Cost"]; ok {
+		data["plan_total_cost"] = cost
+	}
+	if rows, ok := plan["Plan Rows"]; ok {
+		data["plan_rows"] = rows
+	}
+	if rows, ok := plan["Actual Rows"]; ok {
+		data["plan_actual_rows"] = rows
+	}
+	var seqScans []string
+	var shape strings.Builder
+	walkPlan(plan, 0, func(node map[string]interface{}, depth int) {
+		nodeType, _ := node["Node Type"].(string)
+		relation, _ := node["Relation Name"].(string)
+		fmt.Fprintf(&shape, "%d:%s:%s;", depth, nodeType, relation)
+		if nodeType != "Seq Scan" {
+			return
+		}
+		rows, _ := node["Actual Rows"].(float64)
+		if planRows, _ := node["Plan Rows"].(float64); planRows > rows {
+			rows = planRows
+		}
+		if rows >= p.seqScanRows {
+			seqScans = append(seqScans, relation)
+		}
+	})
+	if len(seqScans) > 0 {
+		data["plan_seq_scans"] = strings.Join(seqScans, " ")
+	}
+	// the fingerprint is the shape of the plan, so it stays the same as costs
+	// and row counts change but not if the planner picks a different plan
+	sum := sha256.Sum256([]byte(shape.String()))
+	data["plan_fingerprint"] = fmt.Sprintf("%x", sum[:8])
+}
+
+// walkPlan calls fn for node and each of its descendants
+func walkPlan(node map[string]interface{}, depth int, fn func(map[string]interface{}, int)) {
+	fn(node, depth)
+	children, _ := node["Plans"].([]interface{})
+	for _, child := range children {
+		if childNode, ok := child.(map[string]interface{}); ok {
+			walkPlan(childNode, depth+1, fn)
+		}
+	}
+}
+
+// parseJSONPlan parses auto_explain.log_format=json output
+func parseJSONPlan(text string) (string, map[string]interface{}, error) {
+	var explained map[string]interface{}
+	if err := json.Unmarshal([]byte(text), &explained); err != nil {
+		return "", nil, err
+	}
+	query, _ := explained["Query Text"].(string)
+	plan, ok := explained["Plan"].(map[string]interface{})
+	if !ok {
+		return "", nil, fmt.Errorf("no Plan in auto_explain output")
+	}
+	return query, plan, nil
+}
+
+// parseTextPlan parses auto_explain.log_format=text output in to the same
+// shape as the JSON format, keeping only the node type, relation, cost and
+// row counts of each node.
+func parseTextPlan(lines []string) (string, map[string]interface{}, error) {
+	var queryLines []string
+	var root map[string]interface{}
+	type level struct {
+		indent int
+		node   map[string]interface{}
+	}
+	var stack []level
+	for _, line := range lines {
+		_, fields := textNodeRegex.FindStringSubmatchMap(line)
+		if fields == nil {
+			if root == nil {
+				queryLines = append(queryLines, line)
+			}
+			continue
+		}
+		node := map[string]interface{}{"Node Type": fields["node_type"]}
+		if fields["relation"] != "" {
+			node["Relation Name"] = fields["relation"]
+		}
+		for field, name := range map[string]string{
+			"total_cost":  "Total Cost",
+			"plan_rows":   "Plan Rows",
+			"actual_rows": "Actual Rows",
+		} {
+			if v, err := strconv.ParseFloat(fields[field], 64); err == nil {
+				node[name] = v
+			}
+		}
+		indent := len(fields["indent"])
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			if root != nil {
+				// a second root means this wasn't a plan we understand
+				return "", nil, fmt.Errorf("multiple root plan nodes")
+			}
+			root = node
+		} else {
+			parent := stack[len(stack)-1].node
+			children, _ := parent["Plans"].([]interface{})
+			parent["Plans"] = append(children, node)
+		}
+		stack = append(stack, level{indent: indent, node: node})
+	}
+	if root == nil {
+		return "", nil, fmt.Errorf("no plan nodes in auto_explain output")
+	}
+	query := strings.TrimPrefix(strings.Join(queryLines, "\n"), "Query Text: ")
+	return strings.TrimSpace(query), root, nil
+}
+
+// planKey identifies the statement a plan belongs to. honeytail joins the
+// lines of multi-line statements with spaces, so compare on whitespace
+// normalized queries.
+func planKey(pid interface{}, query interface{}) string {
+	q, _ := query.(string)
+	return fmt.Sprintf("%v:%s", pid, strings.Join(strings.Fields(q), " "))
+}
@@ -0,0 +1,148 @@
+package pgquery
+
+import (
+	"testing"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+const rdsPrefix = "%t:%r:%u@%d:[%p]:"
+
+func processLines(t *testing.T, lines []string) []event.Event {
+	p := &Parser{}
+	if err := p.Init(&Options{LogLinePrefix: rdsPrefix, SeqScanRows: 100}); err != nil {
+		t.Fatal(err)
+	}
+	linesCh := make(chan string, len(lines))
+	for _, line := range lines {
+		linesCh <- line
+	}
+	close(linesCh)
+	send := make(chan event.Event, len(lines))
+	p.ProcessLines(linesCh, send, nil)
+	close(send)
+	var events []event.Event
+	for ev := range send {
+		events = append(events, ev)
+	}
+	return events
+}
+
+func TestTextPlanAttachedToStatement(t *testing.T) {
+	events := processLines(t, []string{
+		"2022-05-17 10:12:03 UTC:10.0.0.1(5432):app@prod:[1234]:LOG:  duration: 12.345 ms  plan:",
+		"\tQuery Text: select * from account a join owner o on o.id = a.owner_id",
+		"\tHash Join  (cost=1.09..37.50 rows=500 width=8) (actual time=0.010..0.020 rows=480 loops=1)",
+		"\t  Hash Cond: (a.owner_id = o.id)",
+		"\t  ->  Seq Scan on account a  (cost=0.00..30.00 rows=2000 width=4) (actual time=0.01..0.01 rows=1990 loops=1)",
+		"\t  ->  Hash  (cost=1.04..1.04 rows=4 width=4) (actual time=0.01..0.01 rows=4 loops=1)",
+		"\t        ->  Seq Scan on owner o  (cost=0.00..1.04 rows=4 width=4) (actual time=0.01..0.01 rows=4 loops=1)",
+		"2022-05-17 10:12:03 UTC:10.0.0.1(5432):app@prod:[1234]:LOG:  duration: 12.400 ms  statement: select * from account a",
+		"\tjoin owner o on o.id = a.owner_id",
+		"2022-05-17 10:12:04 UTC:10.0.0.1(5432):app@prod:[1234]:LOG:  duration: 1.0 ms  statement: select 1",
+	})
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d: %v", len(events), events)
+	}
+	ev := events[0].Data
+	if ev["duration"] != 12.4 {
+		t.Errorf("expected the statement's duration, got %v", ev["duration"])
+	}
+	expected := map[string]interface{}{
+		"plan_node_type":   "Hash Join",
+		"plan_total_cost":  37.5,
+		"plan_rows":        500.0,
+		"plan_actual_rows": 480.0,
+		"plan_seq_scans":   "account",
+	}
+	for k, v := range expected {
+		if ev[k] != v {
+			t.Errorf("field %s: expected %v, got %v", k, v, ev[k])
+		}
+	}
+	plan, ok := ev["plan"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected a structured plan, got %v", ev["plan"])
+	}
+	children := plan["Plans"].([]interface{})
+	if len(children) != 2 {
+		t.Fatalf("expected 2 children of the root node, got %v", children)
+	}
+	hash := children[1].(map[string]interface{})
+	if hash["Node Type"] != "Hash" || len(hash["Plans"].([]interface{})) != 1 {
+		t.Errorf("unexpected Hash node %v", hash)
+	}
+	if _, ok := events[1].Data["plan"]; ok {
+		t.Errorf("didn't expect a plan on an unexplained statement: %v", events[1].Data)
+	}
+}
+
+func TestJSONPlan(t *testing.T) {
+	lines := []string{
+		"2022-05-17 10:12:03 UTC:10.0.0.1(5432):app@prod:[1234]:LOG:  duration: 0.017 ms  plan:",
+		"\t{",
+		"\t  \"Query Text\": \"select * from account where id = 1\",",
+		"\t  \"Plan\": {",
+		"\t    \"Node Type\": \"Index Scan\",",
+		"\t    \"Relation Name\": \"account\",",
+		"\t    \"Total Cost\": 8.30,",
+		"\t    \"Plan Rows\": 1",
+		"\t  }",
+		"\t}",
+	}
+	events := processLines(t, lines)
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d: %v", len(events), events)
+	}
+	ev := events[0].Data
+	expected := map[string]interface{}{
+		"duration":        0.017,
+		"query":           "select * from account where id = 1",
+		"plan_node_type":  "Index Scan",
+		"plan_total_cost": 8.3,
+		"plan_rows":       1.0,
+		"pid":             1234,
+	}
+	for k, v := range expected {
+		if ev[k] != v {
+			t.Errorf("field %s: expected %v, got %v", k, v, ev[k])
+		}
+	}
+	if _, ok := ev["plan_seq_scans"]; ok {
+		t.Errorf("didn't expect any seq scans: %v", ev["plan_seq_scans"])
+	}
+
+	// the fingerprint depends on the shape of the plan, not its costs
+	lines[6] = "\t    \"Total Cost\": 12.00,"
+	other := processLines(t, lines)
+	if other[0].Data["plan_fingerprint"] != ev["plan_fingerprint"] {
+		t.Errorf("expected matching fingerprints, got %v and %v", other[0].Data["plan_fingerprint"], ev["plan_fingerprint"])
+	}
+}
+
+func TestSameQueryTwice(t *testing.T) {
+	plan := func(rows string) []string {
+		return []string{
+			"2022-05-17 10:12:03 UTC:10.0.0.1(5432):app@prod:[1234]:LOG:  duration: 5.0 ms  plan:",
+			"\tQuery Text: select * from account",
+			"\tSeq Scan on account  (cost=0.00..30.00 rows=2000 width=4) (actual time=0.01..0.01 rows=" + rows + " loops=1)",
+		}
+	}
+	statement := "2022-05-17 10:12:03 UTC:10.0.0.1(5432):app@prod:[1234]:LOG:  duration: 5.1 ms  statement: select * from account"
+	var lines []string
+	lines = append(lines, plan("10")...)
+	lines = append(lines, statement)
+	lines = append(lines, plan("20")...)
+	lines = append(lines, statement,
+		"2022-05-17 10:12:04 UTC:10.0.0.1(5432):app@prod:[1234]:LOG:  duration: 1.0 ms  statement: select 1")
+	events := processLines(t, lines)
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d: %v", len(events), events)
+	}
+	// each run gets its own plan, in order
+	for i, rows := range []float64{10, 20} {
+		if actual := events[i].Data["plan_actual_rows"]; actual != rows {
+			t.Errorf("statement %d: expected plan_actual_rows %v, got %v", i, rows, actual)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// Package sqlserver parses the logs RDS for SQL Server exposes: the error log
+// (log/ERROR) and the SQL Server Agent log (log/SQLAGENT.OUT).
+package sqlserver
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+	"github.com/sirupsen/logrus"
+)
+
+// The error log writes a timestamp and the source of each message, which is
+// either a session (spidNN) or a component such as Server, Logon or Backup:
+//
+//	2022-05-17 10:12:03.45 Logon       Error: 18456, Severity: 14, State: 8.
+//	2022-05-17 10:12:03.45 Logon       Login failed for user 'app'. Reason: Password did not match that for the login provided. [CLIENT: 10.0.0.1]
+//
+// Errors are logged as an "Error:" line followed by the message on a line of
+// its own with the same timestamp and source; the two are sent as one event.
+var errorHeaderRegex = &parsers.ExtRegexp{Regexp: regexp.MustCompile(
+	`^(?P<timestamp>\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}(?:\.\d+)?) (?P<source>\S+)\s+`)}
+
+var errorNumberRegex = &parsers.ExtRegexp{Regexp: regexp.MustCompile(
+	`^Error: (?P<error_number>\d+), Severity: (?P<severity>\d+), State: (?P<state>\d+)\.`)}
+
+var clientRegex = regexp.MustCompile(`\[CLIENT: ([^\]]+)\]`)
+
+type ErrorOptions struct{}
+
+// ErrorParser parses the SQL Server error log
+type ErrorParser struct{}
+
+func (p *ErrorParser) Init(options interface{}) error {
+	return nil
+}
+
+func (p *ErrorParser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	var pending *event.Event
+	var pendingHeader string
+	for line := range lines {
+		if prefixRegex != nil {
+			line = strings.TrimPrefix(line, prefixRegex.FindString(line))
+		}
+		line = strings.TrimRight(line, "\r")
+		header, fields := errorHeaderRegex.FindStringSubmatchMap(line)
+		if header == "" {
+			// continuation of a multi-line message
+			if pending != nil {
+				pending.Data["message"] = pending.Data["message"].(string) + "\n" + line
+			}
+			continue
+		}
+		message := line[len(header):]
+		// the message following an "Error:" line belongs with it
+		if pending != nil && pending.Data["message"] == "" &&
+			pendingHeader == fields["timestamp"]+" "+fields["source"] {
+			pending.Data["message"] = message
+			addClient(pending)
+			continue
+		}
+		if pending != nil {
+			send <- *pending
+		}
+		pending = errorEvent(fields, message)
+		pendingHeader = fields["timestamp"] + " " + fields["source"]
+	}
+	if pending != nil {
+		send <- *pending
+	}
+}
+
+func errorEvent(fields map[string]string, message string) *event.Event {
+	ev := &event.Event{
+		Timestamp: parseTimestamp(fields["timestamp"], "2006-01-02 15:04:05.99"),
+		Data:      map[string]interface{}{"source": fields["source"]},
+	}
+	if strings.HasPrefix(fields["source"], "spid") {
+		if spid, err := strconv.Atoi(strings.TrimPrefix(fields["source"], "spid")); err == nil {
+			ev.Data["spid"] = spid
+		}
+	}
+	if matched, errorFields := errorNumberRegex.FindStringSubmatchMap(message); matched != "" {
+		for k, v := range errorFields {
+			ev.Data[k], _ = strconv.Atoi(v)
+		}
+		// wait for the message itself on the next line
+		ev.Data["message"] = ""
+		return ev
+	}
+	ev.Data["message"] = message
+	addClient(ev)
+	return ev
+}
+
+// addClient picks the client address out of login messages
+func addClient(ev *event.Event) {
+	if match := clientRegex.FindStringSubmatch(ev.Data["message"].(string)); match != nil {
+		ev.Data["client"] = match[1]
+	}
+}
+
+// The agent log writes a timestamp, a message type (? for information, + for
+// warnings and ! for errors) and a message id:
+//
+//	2022-05-17 10:12:03 - ? [100] Microsoft SQLServerAgent version 15.0.4198.2 (X64 unicode retail build) : Process ID 3840
+//	2022-05-17 10:12:04 - ! [298] SQLServer Error: 15404, Could not obtain information about Windows NT group/user 'RDS\rdsa'. [SQLSTATE 42000]
+var agentRegex = &parsers.ExtRegexp{Regexp: regexp.MustCompile(
+	`^(?P<timestamp>\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}) - (?P<type>[?+!]) \[(?P<message_id>\d+)\] `)}
+
+var agentSeverities = map[string]string{
+	"?": "info",
+	"+": "warning",
+	"!": "error",
+}
+
+type AgentOptions struct{}
+
+// AgentParser parses the SQL Server Agent log
+type AgentParser struct{}
+
+func (p *AgentParser) Init(options interface{}) error {
+	return nil
+}
+
+func (p *AgentParser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	var pending *event.Event
+	for line := range lines {
+		if prefixRegex != nil {
+			line = strings.TrimPrefix(line, prefixRegex.FindString(line))
+		}
+		line = strings.TrimRight(line, "\r")
+		header, fields := agentRegex.FindStringSubmatchMap(line)
+		if header == "" {
+			if pending != nil {
+				pending.Data["message"] = pending.Data["message"].(string) + "\n" + line
+			}
+			continue
+		}
+		if pending != nil {
+			send <- *pending
+		}
+		pending = &event.Event{
+			Timestamp: parseTimestamp(fields["timestamp"], "2006-01-02 15:04:05"),
+			Data: map[string]interface{}{
+				"severity": agentSeverities[fields["type"]],
+				"message":  line[len(header):],
+			},
+		}
+		if id, err := strconv.Atoi(fields["message_id"]); err == nil {
+			pending.Data["message_id"] = id
+		}
+	}
+	if pending != nil {
+		send <- *pending
+	}
+}
+
+func parseTimestamp(v, format string) time.Time {
+	t, err := time.Parse(format, v)
+	if err != nil {
+		logrus.WithField("timestamp", v).Debug("Error parsing sql server log timestamp")
+	}
+	return t
+}
@@ -0,0 +1,94 @@
+package sqlserver
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+func processLines(p parsers.Parser, lines []string) []event.Event {
+	linesCh := make(chan string, len(lines))
+	for _, line := range lines {
+		linesCh <- line
+	}
+	close(linesCh)
+	send := make(chan event.Event, len(lines))
+	p.Init(nil)
+	p.ProcessLines(linesCh, send, nil)
+	close(send)
+	var events []event.Event
+	for ev := range send {
+		events = append(events, ev)
+	}
+	return events
+}
+
+func TestErrorParser(t *testing.T) {
+	events := processLines(&ErrorParser{}, []string{
+		"2022-05-17 10:12:03.45 Logon       Error: 18456, Severity: 14, State: 8.",
+		"2022-05-17 10:12:03.45 Logon       Login failed for user 'app'. Reason: Password did not match. [CLIENT: 10.0.0.1]",
+		"2022-05-17 10:12:04.10 spid52      DBCC CHECKDB (app) executed by rdsa found 0 errors",
+		"and repaired 0 errors.",
+		"2022-05-17 10:12:05.00 Server      SQL Server is starting at normal priority base (=7).\r",
+	})
+	expected := []map[string]interface{}{
+		{
+			"source":       "Logon",
+			"error_number": 18456,
+			"severity":     14,
+			"state":        8,
+			"message":      "Login failed for user 'app'. Reason: Password did not match. [CLIENT: 10.0.0.1]",
+			"client":       "10.0.0.1",
+		},
+		{
+			"source":  "spid52",
+			"spid":    52,
+			"message": "DBCC CHECKDB (app) executed by rdsa found 0 errors\nand repaired 0 errors.",
+		},
+		{
+			"source":  "Server",
+			"message": "SQL Server is starting at normal priority base (=7).",
+		},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %d: %v", len(expected), len(events), events)
+	}
+	for i := range expected {
+		if !reflect.DeepEqual(events[i].Data, expected[i]) {
+			t.Errorf("event %d: expected %v, got %v", i, expected[i], events[i].Data)
+		}
+	}
+	if ts, _ := time.Parse(time.RFC3339Nano, "2022-05-17T10:12:03.45Z"); !events[0].Timestamp.Equal(ts) {
+		t.Errorf("expected timestamp %s, got %s", ts, events[0].Timestamp)
+	}
+}
+
+func TestAgentParser(t *testing.T) {
+	events := processLines(&AgentParser{}, []string{
+		"2022-05-17 10:12:03 - ? [100] Microsoft SQLServerAgent version 15.0.4198.2 (X64 unicode retail build) : Process ID 3840",
+		"2022-05-17 10:12:04 - ! [298] SQLServer Error: 15404, Could not obtain information about Windows NT group/user 'RDS\\rdsa'. [SQLSTATE 42000]",
+	})
+	expected := []map[string]interface{}{
+		{
+			"severity":   "info",
+			"message_id": 100,
+			"message":    "Microsoft SQLServerAgent version 15.0.4198.2 (X64 unicode retail build) : Process ID 3840",
+		},
+		{
+			"severity":   "error",
+			"message_id": 298,
+			"message":    "SQLServer Error: 15404, Could not obtain information about Windows NT group/user 'RDS\\rdsa'. [SQLSTATE 42000]",
+		},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %d: %v", len(expected), len(events), events)
+	}
+	for i := range expected {
+		if !reflect.DeepEqual(events[i].Data, expected[i]) {
+			t.Errorf("event %d: expected %v, got %v", i, expected[i], events[i].Data)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package publisher
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+// aggregateMetrics are the numeric fields summarized for each query digest.
+// query_time, lock_time, rows_sent and rows_examined come from the mysql
+// parser; duration (in ms) comes from the postgresql parser.
+var aggregateMetrics = []string{"query_time", "lock_time", "rows_sent", "rows_examined", "duration"}
+
+// fields copied from the first event seen in a group on to its digest, to make
+// the summary easier to slice on.
+var aggregateSampleFields = []string{"database", "user", "statement", "tables", "query"}
+
+// percentiles are estimated from a reservoir of at most this many values per
+// metric per group, so a single hot fingerprint can't eat all our memory.
+const maxReservoirSize = 1024
+
+// Aggregator groups parsed events by query fingerprint (the normalized query)
+// and summarizes them per interval, in the style of pt-query-digest.
+type Aggregator struct {
+	mu         sync.Mutex
+	groups     map[string]*digest
+	start      time.Time
+	nowFunc    func() time.Time
+	randSource *rand.Rand
+}
+
+// NewAggregator returns an Aggregator whose first interval starts now.
+func NewAggregator() *Aggregator {
+	a := &Aggregator{
+		nowFunc:    time.Now,
+		randSource: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	a.start = a.nowFunc()
+	a.groups = make(map[string]*digest)
+	return a
+}
+
+// Add accounts for ev in its fingerprint's group. Events without a
+// fingerprint can't be grouped and are ignored.
+func (a *Aggregator) Add(ev event.Event) {
+	fingerprint, ok := ev.Data["normalized_query"].(string)
+	if !ok || fingerprint == "" {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	d, ok := a.groups[fingerprint]
+	if !ok {
+		d = &digest{
+			fingerprint: fingerprint,
+			samples:     make(map[string]interface{}),
+			metrics:     make(map[string]*metricSummary),
+		}
+		for _, field := range aggregateSampleFields {
+			if val, ok := ev.Data[field]; ok {
+				d.samples[field] = val
+			}
+		}
+		a.groups[fingerprint] = d
+	}
+	d.count++
+	for _, name := range aggregateMetrics {
+		val, ok := toFloat(ev.Data[name])
+		if !ok {
+			continue
+		}
+		m, ok := d.metrics[name]
+		if !ok {
+			m = &metricSummary{min: val, max: val}
+			d.metrics[name] = m
+		}
+		m.add(val, a.randSource)
+	}
+}
+
+// Flush ends the current interval and returns one summary event per group
+// seen during it. The next interval starts immediately.
+func (a *Aggregator) Flush() []event.Event {
+	a.mu.Lock()
+	groups := a.groups
+	start := a.start
+	end := a.nowFunc()
+	a.groups = make(map[string]*digest)
+	a.start = end
+	a.mu.Unlock()
+
+	events := make([]event.Event, 0, len(groups))
+	for _, d := range groups {
+		events = append(events, d.toEvent(start, end))
+	}
+	// keep output deterministic for anyone diffing stdout
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Data["normalized_query"].(string) < events[j].Data["normalized_query"].(string)
+	})
+	return events
+}
+
+// digest accumulates all the events for one fingerprint within one interval
+type digest struct {
+	fingerprint string
+	count       int
+	samples     map[string]interface{}
+	metrics     map[string]*metricSummary
+}
+
+func (d *digest) toEvent(start, end time.Time) event.Event {
+	data := make(map[string]interface{}, len(d.samples)+len(d.metrics)*7+5)
+	for k, v := range d.samples {
+		data[k] = v
+	}
+	data["normalized_query"] = d.fingerprint
+	data["digest"] = true
+	data["count"] = d.count
+	data["interval_start"] = start.UTC().Format(time.RFC3339Nano)
+	data["interval_sec"] = end.Sub(start).Seconds()
+	for name, m := range d.metrics {
+		data[name+".sum"] = m.sum
+		data[name+".min"] = m.min
+		data[name+".max"] = m.max
+		data[name+".p50"] = m.percentile(50)
+		data[name+".p95"] = m.percentile(95)
+		data[name+".p99"] = m.percentile(99)
+	}
+	// digests summarize every event we saw, so they're never sampled
+	return event.Event{
+		Timestamp:  start,
+		SampleRate: 1,
+		Data:       data,
+	}
+}
+
+// metricSummary holds exact count/sum/min/max and a reservoir sample of values
+// from which percentiles are estimated.
+type metricSummary struct {
+	count     int
+	sum       float64
+	min       float64
+	max       float64
+	reservoir []float64
+}
+
+func (m *metricSummary) add(val float64, r *rand.Rand) {
+	m.count++
+	m.sum += val
+	m.min = math.Min(m.min, val)
+	m.max = math.Max(m.max, val)
+	if len(m.reservoir) < maxReservoirSize {
+		m.reservoir = append(m.reservoir, val)
+		return
+	}
+	if i := r.Intn(m.count); i < maxReservoirSize {
+		m.reservoir[i] = val
+	}
+}
+
+// percentile returns the nearest-rank pct percentile of the sampled values
+func (m *metricSummary) percentile(pct float64) float64 {
+	if len(m.reservoir) == 0 {
+		return 0
+	}
+	sorted := make([]float64, len(m.reservoir))
+	copy(sorted, m.reservoir)
+	sort.Float64s(sorted)
+	rank := int(math.Ceil(pct / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// toFloat converts the numeric types produced by the parsers to a float64
+func toFloat(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
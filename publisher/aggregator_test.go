@@ -0,0 +1,79 @@
+package publisher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+func TestAggregatorFlush(t *testing.T) {
+	now, _ := time.Parse(time.RFC3339, "2010-06-21T15:00:00Z")
+	a := NewAggregator()
+	a.nowFunc = func() time.Time { return now }
+	a.start = now
+
+	for i := 1; i <= 100; i++ {
+		a.Add(event.Event{Data: map[string]interface{}{
+			"normalized_query": "select * from foo where id = ?",
+			"database":         "app",
+			"query_time":       float64(i),
+			"rows_examined":    i * 10,
+		}})
+	}
+	a.Add(event.Event{Data: map[string]interface{}{
+		"normalized_query": "select * from bar",
+		"query_time":       0.5,
+	}})
+	// no fingerprint, can't be grouped
+	a.Add(event.Event{Data: map[string]interface{}{"query_time": 3.0}})
+
+	now = now.Add(time.Minute)
+	digests := a.Flush()
+	if len(digests) != 2 {
+		t.Fatalf("expected 2 digests, got %d", len(digests))
+	}
+
+	bar := digests[0].Data
+	if bar["normalized_query"] != "select * from bar" || bar["count"] != 1 {
+		t.Errorf("unexpected digest for bar: %v", bar)
+	}
+	if _, ok := bar["rows_examined.sum"]; ok {
+		t.Error("didn't expect rows_examined for an event without it")
+	}
+
+	foo := digests[1].Data
+	expected := map[string]interface{}{
+		"count":             100,
+		"database":          "app",
+		"digest":            true,
+		"interval_sec":      60.0,
+		"query_time.sum":    5050.0,
+		"query_time.min":    1.0,
+		"query_time.max":    100.0,
+		"query_time.p50":    50.0,
+		"query_time.p95":    95.0,
+		"query_time.p99":    99.0,
+		"rows_examined.max": 1000.0,
+		"rows_examined.p50": 500.0,
+		"interval_start":    "2010-06-21T15:00:00Z",
+		"normalized_query":  "select * from foo where id = ?",
+		"rows_examined.min": 10.0,
+		"rows_examined.sum": 50500.0,
+		"rows_examined.p99": 990.0,
+		"rows_examined.p95": 950.0,
+	}
+	for k, v := range expected {
+		if foo[k] != v {
+			t.Errorf("field %s: expected %v, got %v", k, v, foo[k])
+		}
+	}
+	if digests[1].SampleRate != 1 {
+		t.Errorf("expected digests to have sample rate 1, got %d", digests[1].SampleRate)
+	}
+
+	// the next interval starts out empty
+	if digests := a.Flush(); len(digests) != 0 {
+		t.Errorf("expected no digests after flush, got %d", len(digests))
+	}
+}
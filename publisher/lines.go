@@ -0,0 +1,33 @@
+package publisher
+
+import "strings"
+
+// lineBuffer splits chunks of log in to lines. RDS hands back log portions
+// that can end part way through a line, so the trailing partial line of each
+// chunk is carried over and prepended to the next one.
+type lineBuffer struct {
+	partial string
+}
+
+// add returns the complete lines in chunk, holding on to any partial line at
+// the end
+func (b *lineBuffer) add(chunk string) []string {
+	if b.partial != "" {
+		chunk = b.partial + chunk
+		b.partial = ""
+	}
+	lines := strings.Split(chunk, "\n")
+	// the last element is "" if chunk ended in a newline, and the start of
+	// an unfinished line otherwise
+	b.partial = lines[len(lines)-1]
+	return lines[:len(lines)-1]
+}
+
+// flush returns the partial line being held, if any. The log it came from
+// has ended (been rotated, or we're shutting down), so it's as complete as
+// it'll get.
+func (b *lineBuffer) flush() string {
+	partial := b.partial
+	b.partial = ""
+	return partial
+}
@@ -0,0 +1,66 @@
+package publisher
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/honeycombio/honeytail/event"
+
+	"github.com/honeycombio/rdslogs/parsers/pgquery"
+)
+
+func TestLineBuffer(t *testing.T) {
+	b := &lineBuffer{}
+	var lines []string
+	for _, chunk := range []string{"first li", "ne\nsecond line\nthi", "rd", " line\n", "unfinished"} {
+		lines = append(lines, b.add(chunk)...)
+	}
+	expected := []string{"first line", "second line", "third line"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected %q, got %q", expected, lines)
+	}
+	if partial := b.flush(); partial != "unfinished" {
+		t.Errorf("expected partial line %q, got %q", "unfinished", partial)
+	}
+	if partial := b.flush(); partial != "" {
+		t.Errorf("expected nothing left after flush, got %q", partial)
+	}
+}
+
+func TestLineBufferReassemblesMultiLineStatement(t *testing.T) {
+	// a postgres statement with embedded newlines, split across portions in
+	// the middle of its continuation line
+	chunks := []string{
+		"2022-05-17 10:12:03 UTC:10.0.0.1(5432):app@prod:[1234]:LOG:  duration: 1.5 ms  statement: select *\n\tfrom acc",
+		"ount\n\twhere id = 1\n",
+	}
+	b := &lineBuffer{}
+	lines := make(chan string, 10)
+	for _, chunk := range chunks {
+		for _, line := range b.add(chunk) {
+			lines <- line
+		}
+	}
+	if partial := b.flush(); partial != "" {
+		lines <- partial
+	}
+	close(lines)
+
+	p := &pgquery.Parser{}
+	if err := p.Init(&pgquery.Options{LogLinePrefix: "%t:%r:%u@%d:[%p]:"}); err != nil {
+		t.Fatal(err)
+	}
+	send := make(chan event.Event, 10)
+	p.ProcessLines(lines, send, nil)
+	close(send)
+	var events []event.Event
+	for ev := range send {
+		events = append(events, ev)
+	}
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d: %v", len(events), events)
+	}
+	if query := events[0].Data["query"]; query != "select * from account where id = 1" {
+		t.Errorf("unexpected query %q", query)
+	}
+}
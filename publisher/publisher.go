@@ -6,6 +6,8 @@ import (
 	"io"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/honeycombio/honeytail/event"
@@ -18,11 +20,35 @@ import (
 // allows us to hand them off and fetch more while the line processor is doing work.
 const lineChanSize = 100000
 
+// truncatedPrefixLen is how much of a truncated line to send along with the
+// event reporting it
+const truncatedPrefixLen = 1000
+
 // Publisher is an interface to write rdslogs entries to a target. Current
 // implementations are STDOUT and Honeycomb
 type Publisher interface {
 	// Write accepts a long blob of text and writes it to the target
 	Write(blob string)
+	// Flush writes out any partial line held back from the previous blobs,
+	// when the log they came from has been rotated
+	Flush()
+	// Truncated reports a line from logFile too long for RDS to return
+	// whole. line is the part of it we got.
+	Truncated(line, logFile string)
+	// Gap reports a stretch of log that was skipped over, so the missing
+	// data is visible downstream
+	Gap(gap Gap)
+}
+
+// Gap describes a stretch of log rdslogs couldn't read
+type Gap struct {
+	LogFile string
+	// Marker is the RDS marker where the gap starts
+	Marker string
+	// Bytes is the length of the gap, or 0 if it isn't known
+	Bytes int64
+	// Reason is why it couldn't be read
+	Reason string
 }
 
 // HoneycombPublisher implements Publisher and sends the entries provided to
@@ -35,95 +61,270 @@ type HoneycombPublisher struct {
 	SampleRate     int
 	Parser         parsers.Parser
 	AddFields      map[string]string
+	settingsMu     sync.RWMutex // guards the settings Reconfigure changes
 	initialized    bool
+	client         *libhoney.Client
 	lines          chan string
+	buffer         lineBuffer
 	eventsToSend   chan event.Event
+	sent           chan struct{}
 	eventsSent     uint
 	lastUpdateTime time.Time
+	// discarded is set once what's buffered is no longer to be sent
+	discarded int32
+
+	// AggregateInterval, when set, groups events by query fingerprint and
+	// sends one digest event per group per interval
+	AggregateInterval time.Duration
+	// AggregateRaw sends the individual events alongside the digests
+	AggregateRaw   bool
+	aggregator     *Aggregator
+	stopAggregator chan struct{}
+	closeOnce      sync.Once
 }
 
 func (h *HoneycombPublisher) Write(chunk string) {
-	if !h.initialized {
-		fmt.Fprintln(os.Stderr, "initializing honeycomb")
-		h.initialized = true
-		libhoney.Init(libhoney.Config{
-			WriteKey:   h.Writekey,
-			Dataset:    h.Dataset,
-			APIHost:    h.APIHost,
-			SampleRate: uint(h.SampleRate),
-		})
-		h.lines = make(chan string, lineChanSize)
-		h.eventsToSend = make(chan event.Event)
-		go func() {
-			h.Parser.ProcessLines(h.lines, h.eventsToSend, nil)
-			close(h.eventsToSend)
-		}()
-		go func() {
-			fmt.Fprintln(os.Stderr, "spinning up goroutine to send events")
-			for ev := range h.eventsToSend {
-				if h.ScrubQuery {
-					if val, ok := ev.Data["query"]; ok {
-						// generate a sha256 hash
-						newVal := sha256.Sum256([]byte(fmt.Sprintf("%v", val)))
-						// and use the base16 string version of it
-						ev.Data["query"] = fmt.Sprintf("%x", newVal)
-					}
+	h.init()
+	for _, line := range h.buffer.add(chunk) {
+		if line == "" {
+			continue
+		}
+		h.lines <- line
+	}
+}
+
+// init sets up a libhoney client and the parsing and sending goroutines the first time
+// we have something to send
+func (h *HoneycombPublisher) init() {
+	if h.initialized {
+		return
+	}
+	fmt.Fprintln(os.Stderr, "initializing honeycomb")
+	h.initialized = true
+	// each publisher has its own client, so that several streams can send
+	// to different datasets from one process
+	client, err := libhoney.NewClient(libhoney.ClientConfig{
+		APIKey:     h.Writekey,
+		Dataset:    h.Dataset,
+		APIHost:    h.APIHost,
+		SampleRate: uint(h.SampleRate),
+	})
+	if err != nil {
+		logrus.WithError(err).Fatal("unable to initialize honeycomb")
+	}
+	h.client = client
+	h.lines = make(chan string, lineChanSize)
+	h.eventsToSend = make(chan event.Event)
+	h.sent = make(chan struct{})
+	go func() {
+		h.Parser.ProcessLines(h.lines, h.eventsToSend, nil)
+		close(h.eventsToSend)
+	}()
+	if h.AggregateInterval > 0 {
+		h.aggregator = NewAggregator()
+		h.stopAggregator = make(chan struct{})
+		go h.flushDigests()
+	}
+	go func() {
+		fmt.Fprintln(os.Stderr, "spinning up goroutine to send events")
+		defer close(h.sent)
+		for ev := range h.eventsToSend {
+			if h.isDiscarded() {
+				continue
+			}
+			h.settingsMu.RLock()
+			scrubQuery := h.ScrubQuery
+			h.settingsMu.RUnlock()
+			if scrubQuery {
+				if val, ok := ev.Data["query"]; ok {
+					// generate a sha256 hash
+					newVal := sha256.Sum256([]byte(fmt.Sprintf("%v", val)))
+					// and use the base16 string version of it
+					ev.Data["query"] = fmt.Sprintf("%x", newVal)
 				}
-				libhEv := libhoney.NewEvent()
-				libhEv.Timestamp = ev.Timestamp
-
-				// add extra fields first so they don't override anything parsed
-				// in the log file
-				if err := libhEv.Add(h.AddFields); err != nil {
-					logrus.WithFields(logrus.Fields{
-						"add_fields": h.AddFields,
-						"error":      err,
-					}).Error("Unexpected error adding extra fields data to libhoney event")
+			}
+			if h.aggregator != nil {
+				h.aggregator.Add(ev)
+				if !h.AggregateRaw {
+					continue
 				}
+			}
 
-				if err := libhEv.Add(ev.Data); err != nil {
-					logrus.WithFields(logrus.Fields{
-						"event": ev,
-						"error": err,
-					}).Error("Unexpected error adding data to libhoney event")
-				}
+			// periodically provide updates to indicate work is actually being done
+			if time.Since(h.lastUpdateTime) >= time.Minute {
+				logrus.WithFields(logrus.Fields{
+					"most_recent_event":        ev,
+					"events_since_last_update": h.eventsSent,
+					"last_update_time":         h.lastUpdateTime,
+				}).Info("status update")
+				h.eventsSent = 0
+				h.lastUpdateTime = time.Now()
+			}
 
-				// periodically provide updates to indicate work is actually being done
-				if time.Since(h.lastUpdateTime) >= time.Minute {
-					logrus.WithFields(logrus.Fields{
-						"most_recent_event":        ev,
-						"events_since_last_update": h.eventsSent,
-						"last_update_time":         h.lastUpdateTime,
-					}).Info("status update")
-					h.eventsSent = 0
-					h.lastUpdateTime = time.Now()
-				}
+			// sampling is handled by the mysql parser
+			// TODO make this work for postgres too
+			h.send(ev)
 
-				// sampling is handled by the mysql parser
-				// TODO make this work for postgres too
-				if err := libhEv.SendPresampled(); err != nil {
-					logrus.WithFields(logrus.Fields{
-						"event": ev,
-						"error": err,
-					}).Error("Unexpected error event to libhoney send")
-				}
+			h.eventsSent++
+		}
+	}()
+}
 
-				h.eventsSent++
-			}
-		}()
+// Flush sends any partial line held back from the previous chunks to the
+// parser
+func (h *HoneycombPublisher) Flush() {
+	if line := h.buffer.flush(); line != "" {
+		h.lines <- line
 	}
-	lines := strings.Split(chunk, "\n")
-	for _, line := range lines {
-		if line == "" {
-			continue
+}
+
+// Truncated sends an event reporting the truncated line in place of the
+// line itself, as the parser would only make a mess of the part we got
+func (h *HoneycombPublisher) Truncated(line, logFile string) {
+	h.init()
+	prefix := line
+	if len(prefix) > truncatedPrefixLen {
+		prefix = strings.ToValidUTF8(prefix[:truncatedPrefixLen], "")
+	}
+	// like a gap, a truncated line is never sampled away, so it's seen
+	h.send(event.Event{
+		Timestamp:  time.Now(),
+		SampleRate: 1,
+		Data: map[string]interface{}{
+			"truncated":   true,
+			"log_file":    logFile,
+			"line_bytes":  len(line),
+			"line_prefix": prefix,
+		},
+	})
+}
+
+// Gap sends an event marking where log was skipped. It isn't sampled, so
+// every gap is visible downstream.
+func (h *HoneycombPublisher) Gap(gap Gap) {
+	h.init()
+	h.send(event.Event{
+		Timestamp:  time.Now(),
+		SampleRate: 1,
+		Data: map[string]interface{}{
+			"gap":        true,
+			"log_file":   gap.LogFile,
+			"marker":     gap.Marker,
+			"gap_bytes":  gap.Bytes,
+			"gap_reason": gap.Reason,
+		},
+	})
+}
+
+// Reconfigure changes how events are scrubbed, sampled and decorated, while
+// the publisher is running
+func (h *HoneycombPublisher) Reconfigure(scrubQuery bool, sampleRate int, addFields map[string]string) {
+	h.settingsMu.Lock()
+	defer h.settingsMu.Unlock()
+	h.ScrubQuery = scrubQuery
+	h.SampleRate = sampleRate
+	h.AddFields = addFields
+}
+
+// send hands a single event to libhoney
+func (h *HoneycombPublisher) send(ev event.Event) {
+	h.settingsMu.RLock()
+	sampleRate, addFields := h.SampleRate, h.AddFields
+	h.settingsMu.RUnlock()
+
+	libhEv := h.client.NewEvent()
+	libhEv.Timestamp = ev.Timestamp
+	libhEv.SampleRate = uint(sampleRate)
+	if ev.SampleRate > 0 {
+		libhEv.SampleRate = uint(ev.SampleRate)
+	}
+
+	// add extra fields first so they don't override anything parsed
+	// in the log file
+	if err := libhEv.Add(addFields); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"add_fields": addFields,
+			"error":      err,
+		}).Error("Unexpected error adding extra fields data to libhoney event")
+	}
+
+	if err := libhEv.Add(ev.Data); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"event": ev,
+			"error": err,
+		}).Error("Unexpected error adding data to libhoney event")
+	}
+
+	if err := libhEv.SendPresampled(); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"event": ev,
+			"error": err,
+		}).Error("Unexpected error event to libhoney send")
+	}
+}
+
+// flushDigests periodically sends a digest event for each query fingerprint
+// seen since the last flush
+func (h *HoneycombPublisher) flushDigests() {
+	ticker := time.NewTicker(h.AggregateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			h.sendDigests()
+		case <-h.stopAggregator:
+			return
 		}
-		h.lines <- line
 	}
 }
 
-// Close flushes outstanding sends
+func (h *HoneycombPublisher) sendDigests() {
+	if h.isDiscarded() {
+		return
+	}
+	for _, ev := range h.aggregator.Flush() {
+		h.send(ev)
+	}
+}
+
+// Discard drops the lines not yet parsed and the events not yet handed to
+// libhoney, rather than have Close wait for them, for when another replica is
+// sending the same log now
+func (h *HoneycombPublisher) Discard() {
+	atomic.StoreInt32(&h.discarded, 1)
+	h.Close()
+}
+
+func (h *HoneycombPublisher) isDiscarded() bool {
+	return atomic.LoadInt32(&h.discarded) == 1
+}
+
+// Close waits for the lines written so far to be parsed and flushes
+// outstanding sends. It only closes the publisher the first time it's called.
 func (h *HoneycombPublisher) Close() {
-	libhoney.Close()
+	h.closeOnce.Do(h.close)
+}
+
+func (h *HoneycombPublisher) close() {
+	if h.initialized {
+		if h.isDiscarded() {
+			// skip the lines still waiting to be parsed
+			for len(h.lines) > 0 {
+				<-h.lines
+			}
+		} else {
+			h.Flush()
+		}
+		close(h.lines)
+		<-h.sent
+	}
+	if h.aggregator != nil {
+		close(h.stopAggregator)
+		h.sendDigests()
+	}
+	if h.client != nil {
+		h.client.Close()
+	}
 }
 
 // STDOUTPublisher implements Publisher and sends the entries provided to
@@ -134,3 +335,19 @@ type STDOUTPublisher struct {
 func (s *STDOUTPublisher) Write(line string) {
 	io.WriteString(os.Stdout, line)
 }
+
+// Flush is a no-op, as STDOUTPublisher writes partial lines as they come
+func (s *STDOUTPublisher) Flush() {}
+
+// Truncated writes the part of the line we got, ending it so the next line
+// starts on a line of its own
+func (s *STDOUTPublisher) Truncated(line, logFile string) {
+	io.WriteString(os.Stdout, line+"\n")
+}
+
+// Gap writes a line marking where log was skipped, in the style of the
+// "[Your log message was truncated]" note RDS adds to truncated portions
+func (s *STDOUTPublisher) Gap(gap Gap) {
+	fmt.Fprintf(os.Stdout, "[rdslogs skipped %d bytes of %s at marker %s: %s]\n",
+		gap.Bytes, gap.LogFile, gap.Marker, gap.Reason)
+}
@@ -15,8 +15,10 @@ resource "aws_iam_policy" "honeycomb-rdslogs-policy" {
         "Effect": "Allow",
         "Action": [
             "rds:DescribeDBInstances",
+            "rds:DescribeDBParameters",
             "rds:DescribeDBLogFiles",
-            "rds:DownloadDBLogFilePortion"
+            "rds:DownloadDBLogFilePortion",
+            "logs:FilterLogEvents"
         ],
         "Resource": "*"
     }